@@ -0,0 +1,547 @@
+package main
+
+import (
+    "encoding/json"
+    "flag"
+    "fmt"
+    "net/http"
+    "net/url"
+    "log"
+    "os"
+    "strconv"
+    "github.com/syndtr/goleveldb/leveldb"
+    "blockchain/common"
+    "blockchain/core"
+    "blockchain/database"
+    "blockchain/network"
+    "github.com/libp2p/go-libp2p/core/peer"
+)
+
+func usage() {
+    fmt.Fprintln(os.Stderr, "Uso: go run admin.go <comando> [opciones]")
+    fmt.Fprintln(os.Stderr, "")
+    fmt.Fprintln(os.Stderr, "Comandos (todos aceptan -db <ruta>; los de consulta aceptan -json):")
+    fmt.Fprintln(os.Stderr, "  head                            muestra el último bloque")
+    fmt.Fprintln(os.Stderr, "  block <altura|hash>             muestra un bloque")
+    fmt.Fprintln(os.Stderr, "  tx <hash>                       muestra una transacción y su bloque")
+    fmt.Fprintln(os.Stderr, "  account <dirección>             muestra el saldo de una cuenta")
+    fmt.Fprintln(os.Stderr, "  verify [-checkpoints <archivo>] revisa hashes, firmas, enlaces, índices y saldos")
+    fmt.Fprintln(os.Stderr, "  reindex                         reconstruye los índices secundarios")
+    fmt.Fprintln(os.Stderr, "  export -out <archivo> [-gzip]   exporta la cadena por orden de altura")
+    fmt.Fprintln(os.Stderr, "  import -in <archivo> [-checkpoints <archivo>]  importa y valida una cadena exportada")
+    fmt.Fprintln(os.Stderr, "  checkpoints [-every N]          genera puntos de control para node.go -checkpoints")
+    fmt.Fprintln(os.Stderr, "  identity show|generate|rotate   muestra, genera o rota la identidad del nodo (-key <archivo>)")
+    fmt.Fprintln(os.Stderr, "")
+    fmt.Fprintln(os.Stderr, "Comandos sobre un nodo en ejecución (aceptan -api <url>, requieren node.go -admin):")
+    fmt.Fprintln(os.Stderr, "  peers                           muestra la tabla de pares")
+    fmt.Fprintln(os.Stderr, "  ban <id> [-duration 1h]         veta a un par")
+    fmt.Fprintln(os.Stderr, "  unban <id>                      levanta el veto de un par")
+}
+
+func main() {
+    if len(os.Args) < 2 {
+        usage()
+        os.Exit(2)
+    }
+
+    var err error
+    switch os.Args[1] {
+    case "head":
+        err = headCmd(os.Args[2:])
+    case "block":
+        err = blockCmd(os.Args[2:])
+    case "tx":
+        err = txCmd(os.Args[2:])
+    case "account":
+        err = accountCmd(os.Args[2:])
+    case "verify":
+        err = verifyCmd(os.Args[2:])
+    case "reindex":
+        err = reindexCmd(os.Args[2:])
+    case "export":
+        err = exportCmd(os.Args[2:])
+    case "import":
+        err = importCmd(os.Args[2:])
+    case "checkpoints":
+        err = checkpointsCmd(os.Args[2:])
+    case "identity":
+        err = identityCmd(os.Args[2:])
+    case "peers":
+        err = peersCmd(os.Args[2:])
+    case "ban", "unban":
+        err = banCmd(os.Args[1], os.Args[2:])
+    default:
+        usage()
+        os.Exit(2)
+    }
+
+    if err != nil {
+        log.Fatalf("Error: %v", err)
+    }
+}
+
+// queryFlags define las opciones comunes de los comandos de consulta.
+func queryFlags(name string) (*flag.FlagSet, *string, *bool) {
+    fs := flag.NewFlagSet(name, flag.ExitOnError)
+    dbPath := fs.String("db", "data/node/chain", "ruta de la base de datos")
+    asJSON := fs.Bool("json", false, "salida en formato JSON")
+    return fs, dbPath, asJSON
+}
+
+// legacyFlag define la opción -checkpoints de los comandos que validan bloques. Hasta el
+// último punto de control se aceptan transacciones sin firma, como en node.go.
+func legacyFlag(fs *flag.FlagSet) func() error {
+    file := fs.String("checkpoints", "", "archivo de puntos de control; hasta el último se aceptan transacciones sin firma")
+    return func() error {
+        if *file == "" {
+            return nil
+        }
+        checkpoints, err := core.LoadCheckpoints(*file)
+        if err != nil {
+            return err
+        }
+        core.LegacyHeight = checkpoints.Last()
+        return nil
+    }
+}
+
+// printResult escribe v como JSON o, si no se pidió JSON, llama a human.
+func printResult(asJSON bool, v interface{}, human func()) error {
+    if !asJSON {
+        human()
+        return nil
+    }
+    enc := json.NewEncoder(os.Stdout)
+    enc.SetIndent("", "  ")
+    return enc.Encode(v)
+}
+
+func printBlock(block *common.Block) {
+    fmt.Printf("Altura:         %d\n", block.Index)
+    fmt.Printf("Hash:           %s\n", block.Hash)
+    fmt.Printf("Bloque previo:  %s\n", block.PrevBlock)
+    fmt.Printf("Fecha:          %d\n", block.TimeStamp)
+    fmt.Printf("Nonce:          %d\n", block.Nonce)
+    fmt.Printf("Transacciones:  %d\n", len(block.Transactions))
+    for _, transaction := range block.Transactions {
+        fmt.Printf("  %s  %s -> %s  %f\n", transaction.Hash, transaction.Sender, transaction.Recipient, transaction.Ammount)
+    }
+}
+
+func headCmd(args []string) error {
+    fs, dbPath, asJSON := queryFlags("head")
+    fs.Parse(args)
+
+    db, err := leveldb.OpenFile(*dbPath, nil)
+    if err != nil {
+        return fmt.Errorf("error al abrir la base de datos: %v", err)
+    }
+    defer db.Close()
+
+    head, err := database.LastBlockIndex(db)
+    if err != nil {
+        return err
+    }
+    block, err := core.LoadBlock(db, head)
+    if err != nil {
+        return fmt.Errorf("error al cargar el bloque %d: %v", head, err)
+    }
+
+    return printResult(*asJSON, block.Header, func() {
+        fmt.Printf("Altura: %d\nHash:   %s\nFecha:  %d\n", block.Index, block.Hash, block.TimeStamp)
+    })
+}
+
+func blockCmd(args []string) error {
+    fs, dbPath, asJSON := queryFlags("block")
+    fs.Parse(args)
+    if fs.NArg() != 1 {
+        return fmt.Errorf("uso: block [opciones] <altura|hash>")
+    }
+
+    db, err := leveldb.OpenFile(*dbPath, nil)
+    if err != nil {
+        return fmt.Errorf("error al abrir la base de datos: %v", err)
+    }
+    defer db.Close()
+
+    var block *common.Block
+    height, perr := strconv.ParseInt(fs.Arg(0), 10, 64)
+    if perr == nil {
+        block, err = core.LoadBlock(db, height)
+    } else {
+        block, err = core.LoadBlockByHash(db, fs.Arg(0))
+    }
+    if err == core.ErrPruned {
+        if perr != nil {
+            value, _ := db.Get(database.BlockHashKey(fs.Arg(0)), nil)
+            height, _ = database.ParseHeight(value)
+        }
+        header, herr := core.LoadHeader(db, height)
+        if herr != nil {
+            return herr
+        }
+        fmt.Fprintln(os.Stderr, "Bloque podado: solo se conserva la cabecera")
+        return printResult(*asJSON, header, func() { printBlock(&common.Block{Header: *header}) })
+    }
+    if err == leveldb.ErrNotFound {
+        return fmt.Errorf("bloque no encontrado: %s", fs.Arg(0))
+    }
+    if err != nil {
+        return err
+    }
+
+    return printResult(*asJSON, block, func() { printBlock(block) })
+}
+
+func txCmd(args []string) error {
+    fs, dbPath, asJSON := queryFlags("tx")
+    fs.Parse(args)
+    if fs.NArg() != 1 {
+        return fmt.Errorf("uso: tx [opciones] <hash>")
+    }
+
+    db, err := leveldb.OpenFile(*dbPath, nil)
+    if err != nil {
+        return fmt.Errorf("error al abrir la base de datos: %v", err)
+    }
+    defer db.Close()
+
+    transaction, height, err := core.FindTransaction(db, fs.Arg(0))
+    if err == core.ErrPruned {
+        return fmt.Errorf("la transacción pertenece al bloque podado %d", height)
+    }
+    if err == leveldb.ErrNotFound {
+        return fmt.Errorf("transacción no encontrada: %s", fs.Arg(0))
+    }
+    if err != nil {
+        return err
+    }
+
+    result := struct {
+        Transaction *common.Transaction
+        Height      int64
+    }{transaction, height}
+
+    return printResult(*asJSON, result, func() {
+        fmt.Printf("Hash:          %s\n", transaction.Hash)
+        fmt.Printf("Bloque:        %d\n", height)
+        fmt.Printf("Remitente:     %s\n", transaction.Sender)
+        fmt.Printf("Destinatario:  %s\n", transaction.Recipient)
+        fmt.Printf("Monto:         %f\n", transaction.Ammount)
+        fmt.Printf("Fecha:         %d\n", transaction.TimeStamp)
+    })
+}
+
+func accountCmd(args []string) error {
+    fs, dbPath, asJSON := queryFlags("account")
+    fs.Parse(args)
+    if fs.NArg() != 1 {
+        return fmt.Errorf("uso: account [opciones] <dirección>")
+    }
+
+    db, err := leveldb.OpenFile(*dbPath, nil)
+    if err != nil {
+        return fmt.Errorf("error al abrir la base de datos: %v", err)
+    }
+    defer db.Close()
+
+    users, err := core.LoadUsers(db)
+    if err != nil {
+        return fmt.Errorf("error al leer usuarios: %v", err)
+    }
+
+    for _, user := range users {
+        if user.Address != common.AddressKey(fs.Arg(0)) {
+            continue
+        }
+        // Las claves nunca se muestran
+        result := struct {
+            Address string
+            Balance float64
+        }{user.Address, user.Balance}
+
+        return printResult(*asJSON, result, func() {
+            fmt.Printf("Dirección:  %s\nSaldo:      %f\n", user.Address, user.Balance)
+        })
+    }
+
+    return fmt.Errorf("cuenta no encontrada: %s", fs.Arg(0))
+}
+
+func verifyCmd(args []string) error {
+    fs, dbPath, asJSON := queryFlags("verify")
+    loadCheckpoints := legacyFlag(fs)
+    fs.Parse(args)
+    if err := loadCheckpoints(); err != nil {
+        return err
+    }
+
+    db, err := leveldb.OpenFile(*dbPath, nil)
+    if err != nil {
+        return fmt.Errorf("error al abrir la base de datos: %v", err)
+    }
+    defer db.Close()
+
+    checked, issues, err := core.VerifyChain(db)
+    if err != nil {
+        return err
+    }
+
+    result := struct {
+        Blocks int64
+        Issues []string
+    }{checked, issues}
+
+    err = printResult(*asJSON, result, func() {
+        fmt.Printf("Bloques revisados: %d\n", checked)
+        for _, issue := range issues {
+            fmt.Println("  -", issue)
+        }
+    })
+    if err != nil {
+        return err
+    }
+
+    if len(issues) > 0 {
+        return fmt.Errorf("se encontraron %d problemas", len(issues))
+    }
+    return nil
+}
+
+func reindexCmd(args []string) error {
+    fs, dbPath, asJSON := queryFlags("reindex")
+    fs.Parse(args)
+
+    db, err := leveldb.OpenFile(*dbPath, nil)
+    if err != nil {
+        return fmt.Errorf("error al abrir la base de datos: %v", err)
+    }
+    defer db.Close()
+
+    blocks, transactions, err := core.Reindex(db)
+    if err != nil {
+        return err
+    }
+
+    result := struct {
+        Blocks       int64
+        Transactions int64
+    }{blocks, transactions}
+
+    return printResult(*asJSON, result, func() {
+        fmt.Printf("Índices reconstruidos: %d bloques, %d transacciones\n", blocks, transactions)
+    })
+}
+
+func exportCmd(args []string) error {
+    fs := flag.NewFlagSet("export", flag.ExitOnError)
+    dbPath := fs.String("db", "data/node/chain", "ruta de la base de datos")
+    out := fs.String("out", "", "archivo de salida")
+    compress := fs.Bool("gzip", false, "comprimir el archivo con gzip")
+    fs.Parse(args)
+
+    if *out == "" {
+        return fmt.Errorf("se requiere -out")
+    }
+
+    count, err := core.ExportChain(*dbPath, *out, *compress)
+    if err != nil {
+        return err
+    }
+
+    log.Printf("Exportados %d bloques a %s\n", count, *out)
+    return nil
+}
+
+func importCmd(args []string) error {
+    fs := flag.NewFlagSet("import", flag.ExitOnError)
+    dbPath := fs.String("db", "data/node/chain", "ruta de la base de datos")
+    in := fs.String("in", "", "archivo de entrada")
+    loadCheckpoints := legacyFlag(fs)
+    fs.Parse(args)
+
+    if *in == "" {
+        return fmt.Errorf("se requiere -in")
+    }
+    if err := loadCheckpoints(); err != nil {
+        return err
+    }
+
+    result, err := core.ImportChain(*dbPath, *in)
+    if err != nil {
+        return err
+    }
+
+    log.Printf("Importación completa: %d bloques nuevos, %d ya presentes, altura final %d\n", result.Imported, result.Skipped, result.Head)
+    return nil
+}
+
+// checkpointsCmd escribe en la salida estándar los hashes de bloque cada N alturas, en el
+// formato que espera la opción -checkpoints del nodo.
+func checkpointsCmd(args []string) error {
+    fs := flag.NewFlagSet("checkpoints", flag.ExitOnError)
+    dbPath := fs.String("db", "data/node/chain", "ruta de la base de datos")
+    every := fs.Int64("every", 1000, "distancia en bloques entre dos puntos de control")
+    fs.Parse(args)
+
+    if *every < 1 {
+        return fmt.Errorf("-every debe ser mayor que cero")
+    }
+
+    db, err := leveldb.OpenFile(*dbPath, nil)
+    if err != nil {
+        return fmt.Errorf("error al abrir la base de datos: %v", err)
+    }
+    defer db.Close()
+
+    head, err := database.LastBlockIndex(db)
+    if err != nil {
+        return err
+    }
+
+    checkpoints := make(core.Checkpoints)
+    for height := int64(0); height <= head; height += *every {
+        header, err := core.LoadHeader(db, height)
+        if err != nil {
+            return fmt.Errorf("error al cargar la cabecera %d: %v", height, err)
+        }
+        checkpoints[height] = header.Hash
+    }
+
+    return printResult(true, checkpoints, nil)
+}
+
+// identityCmd gestiona el archivo de clave que da al nodo su identidad libp2p. Rotar la
+// identidad cambia el ID del nodo, así que sus pares deben actualizar su dirección.
+func identityCmd(args []string) error {
+    if len(args) < 1 {
+        return fmt.Errorf("se requiere una acción: show, generate o rotate")
+    }
+    action := args[0]
+
+    fs := flag.NewFlagSet("identity", flag.ExitOnError)
+    keyFile := fs.String("key", "data/node/"+network.KeyFileName, "archivo con la clave privada del nodo")
+    fs.Parse(args[1:])
+
+    var id peer.ID
+    switch action {
+    case "show":
+        key, err := network.LoadIdentity(*keyFile)
+        if err != nil {
+            return fmt.Errorf("error al leer la identidad: %v", err)
+        }
+        id, err = peer.IDFromPrivateKey(key)
+        if err != nil {
+            return err
+        }
+
+    case "generate":
+        if _, err := os.Stat(*keyFile); err == nil {
+            return fmt.Errorf("ya existe una identidad en %s; use rotate para reemplazarla", *keyFile)
+        }
+        key, err := network.LoadOrCreateIdentity(*keyFile)
+        if err != nil {
+            return err
+        }
+        id, err = peer.IDFromPrivateKey(key)
+        if err != nil {
+            return err
+        }
+
+    case "rotate":
+        var err error
+        id, err = network.RotateIdentity(*keyFile)
+        if err != nil {
+            return err
+        }
+
+    default:
+        return fmt.Errorf("acción desconocida: %s", action)
+    }
+
+    fmt.Printf("ID del nodo: %s\n", id)
+    return nil
+}
+
+// adminRequest llama a la API de administración de un nodo en ejecución y decodifica la respuesta en v.
+func adminRequest(method, api, path string, v interface{}) error {
+    req, err := http.NewRequest(method, api+path, nil)
+    if err != nil {
+        return err
+    }
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        return fmt.Errorf("no se pudo contactar con la API de administración: %v", err)
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode != http.StatusOK {
+        var apiErr struct {
+            Error string `json:"error"`
+        }
+        json.NewDecoder(resp.Body).Decode(&apiErr)
+        return fmt.Errorf("la API respondió %s: %s", resp.Status, apiErr.Error)
+    }
+    return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func peersCmd(args []string) error {
+    fs := flag.NewFlagSet("peers", flag.ExitOnError)
+    api := fs.String("api", "http://"+network.DefaultAdminAddr, "URL de la API de administración del nodo")
+    asJSON := fs.Bool("json", false, "salida en formato JSON")
+    fs.Parse(args)
+
+    var table []network.PeerStatus
+    if err := adminRequest("GET", *api, "/peers", &table); err != nil {
+        return err
+    }
+
+    return printResult(*asJSON, table, func() {
+        for _, status := range table {
+            state := "desconectado"
+            if status.Connected {
+                state = "conectado"
+            }
+            if status.BannedUntil != nil {
+                state = "vetado hasta " + status.BannedUntil.Format("2006-01-02 15:04:05")
+            }
+            fmt.Printf("%s  %5d  %s", status.ID, status.Score, state)
+            if status.LastReason != "" {
+                fmt.Printf("  (última falta: %s)", status.LastReason)
+            }
+            fmt.Println()
+        }
+    })
+}
+
+func banCmd(action string, args []string) error {
+    if len(args) < 1 {
+        return fmt.Errorf("se requiere el ID del par")
+    }
+    id := args[0]
+
+    fs := flag.NewFlagSet(action, flag.ExitOnError)
+    api := fs.String("api", "http://"+network.DefaultAdminAddr, "URL de la API de administración del nodo")
+    duration := fs.String("duration", "", "duración del veto, por ejemplo 30m (por defecto la del nodo)")
+    fs.Parse(args[1:])
+
+    method, path := "POST", "/peers/"+url.PathEscape(id)+"/ban"
+    if action == "unban" {
+        method = "DELETE"
+    } else if *duration != "" {
+        path += "?duration=" + url.QueryEscape(*duration)
+    }
+
+    var result map[string]string
+    if err := adminRequest(method, *api, path, &result); err != nil {
+        return err
+    }
+
+    if action == "unban" {
+        fmt.Printf("Veto levantado: %s\n", id)
+    } else {
+        fmt.Printf("Par vetado: %s durante %s\n", id, result["duration"])
+    }
+    return nil
+}
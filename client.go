@@ -1,18 +1,21 @@
 package main
 
 import (
+    "flag"
     "fmt"
     "os"
-	"bufio"
+    "crypto/sha256"
+    "encoding/hex"
 	"context"
     "log"
     "strings"
-    "strconv"
     "github.com/libp2p/go-libp2p"
 	"github.com/libp2p/go-libp2p/core/peer"
     "github.com/libp2p/go-libp2p/core/host"
     "github.com/multiformats/go-multiaddr"
     "blockchain/common"
+    "blockchain/network"
+    "blockchain/wallet"
     "time"
     "encoding/json"
     "net/http"
@@ -22,12 +25,19 @@ import (
 const SeedNodesEnvVar = "LIBP2P_SEED_NODES"
 var h host.Host
 var peerInfo *peer.AddrInfo
+var keystore *wallet.Keystore
+var unlockTimeout time.Duration
 
-func ConnectToRandomNode() (host.Host, *peer.AddrInfo, bool, error) {
+// ConnectToRandomNode se conecta al nodo indicado o, si no se indica ninguno, a un nodo
+// semilla elegido al azar del archivo .env.
+func ConnectToRandomNode(randomNode string) (host.Host, *peer.AddrInfo, bool, error) {
     // Cargar nodos semilla del archivo .env
-    randomNode, err := common.ChooseRandomNodeFromEnv("")
-    if err != nil {
-        return nil, nil, false, err
+    var err error
+    if randomNode == "" {
+        randomNode, err = common.ChooseRandomNodeFromEnv("")
+        if err != nil {
+            return nil, nil, false, err
+        }
     }
 
     // Iniciar un host libp2p
@@ -93,221 +103,897 @@ func showMenu(h host.Host, peerInfo *peer.AddrInfo) {
     }
 }*/
 
-func sendBalance(h host.Host, peerInfo *peer.AddrInfo, senderAddress string, recipientAddress string, amount float64, privateKey string) string {
+// sendBalance firma la transacción con la cuenta y la envía al nodo.
+func sendBalance(account *wallet.Account, transaction *common.Transaction) (*network.SendResponse, error) {
     log.Println("Intentando enviar saldo...")
 
-    // Abrir un stream al nodo conectado
-    s, err := h.NewStream(context.Background(), peerInfo.ID, "/send-balance")
-    if err != nil {
-        fmt.Println("Error al abrir stream:", err)
-        return ""
+    // La transacción se firma aquí; la clave privada no se envía al nodo
+    if err := account.Sign(transaction); err != nil {
+        return nil, newAPIError(http.StatusBadRequest, "invalid_transaction", err)
     }
-    defer s.Close()
 
-    // Crear la transacción y convertirla a JSON
-    transaction := common.Transaction{
-        Sender:    senderAddress,
-        Recipient: recipientAddress,
-        Ammount:   amount,
-        Signature: privateKey, // Enviar la clave privada de forma plana
-        TimeStamp: time.Now().Unix(),
+    return submitTransaction(*transaction)
+}
+
+// accountInfo consulta al nodo el saldo, el nonce y la comisión mínima de una cuenta.
+func accountInfo(address string) (*network.BalanceResponse, error) {
+    var response network.BalanceResponse
+    err := network.SendRequest(context.Background(), h, peerInfo.ID, "/get-balance", network.MsgGetBalance, &network.BalanceRequest{Address: address}, &response)
+    if protocolErr, ok := err.(*network.ProtocolError); ok && protocolErr.Code == network.CodeNotFound {
+        return nil, apiErrorf(http.StatusNotFound, "account_not_found", "la cuenta %s no existe en la cadena", address)
+    }
+    if err != nil {
+        return nil, nodeError(err)
     }
+    return &response, nil
+}
 
-    transaction.Hash = common.GenerateTransactionHash(transaction)
+// transactionFee devuelve la comisión de la solicitud o, si no se indica, la mínima del nodo.
+func transactionFee(fee *float64, info *network.BalanceResponse) (float64, error) {
+    if fee == nil {
+        return info.MinFee, nil
+    }
+    if *fee < 0 {
+        return 0, apiErrorf(http.StatusBadRequest, "invalid_fee", "comisión inválida: %f", *fee)
+    }
+    return *fee, nil
+}
 
-    transactionData, err := json.Marshal(transaction)
+// buildTransaction crea una transacción sin firmar con el nonce siguiente de la cuenta según el
+// nodo y la comisión indicada, o la mínima del nodo. Comprueba el saldo antes de firmar.
+func buildTransaction(sender, recipient string, amount float64, fee *float64) (*common.Transaction, error) {
+    if err := common.ValidateAddress(sender); err != nil {
+        return nil, newAPIError(http.StatusBadRequest, "invalid_address", err)
+    }
+    recipient, err := parseRecipient(recipient)
     if err != nil {
-        fmt.Println("Error al codificar la transacción:", err)
-        return ""
+        return nil, err
+    }
+    if amount <= 0 {
+        return nil, apiErrorf(http.StatusBadRequest, "invalid_amount", "monto inválido: %f", amount)
     }
 
-    transactionData = append(transactionData, '\n')
+    // Una cuenta que la cadena no conoce nunca recibió fondos
+    info, err := accountInfo(sender)
+    if apiErr, ok := err.(*apiError); ok && apiErr.Code == "account_not_found" {
+        return nil, apiErrorf(http.StatusUnprocessableEntity, "insufficient_funds", "la cuenta %s no tiene fondos", sender)
+    }
+    if err != nil {
+        return nil, err
+    }
+    transactionFee, err := transactionFee(fee, info)
+    if err != nil {
+        return nil, err
+    }
+    if info.Balance < amount+transactionFee {
+        return nil, apiErrorf(http.StatusUnprocessableEntity, "insufficient_funds", "saldo insuficiente: la cuenta tiene %f y la transacción requiere %f", info.Balance, amount+transactionFee)
+    }
 
-    // Enviar la transacción al nodo
-    _, err = s.Write(transactionData)
+    transaction, err := wallet.NewTransaction(sender, recipient, amount, transactionFee, info.Nonce+1)
     if err != nil {
-        fmt.Println("Error al enviar la transacción:", err)
-        return ""
+        return nil, newAPIError(http.StatusBadRequest, "invalid_transaction", err)
     }
+    return transaction, nil
+}
 
-    // Leer la respuesta del nodo
-    buf := bufio.NewReader(s)
-    response, err := buf.ReadString('\n')
+// parseRecipient valida la dirección de destino. La suma de verificación detecta los errores
+// al copiarla antes de firmar; las direcciones hexadecimales anteriores no la tienen, así que
+// se aceptan con un aviso y se envían en Base58Check.
+func parseRecipient(recipient string) (string, error) {
+    recipient = strings.TrimSpace(recipient)
+    if err := common.ValidateAddress(recipient); err != nil {
+        return "", newAPIError(http.StatusBadRequest, "invalid_address", err)
+    }
+    if common.IsLegacyAddress(recipient) {
+        log.Printf("Aviso: la dirección %s no tiene suma de verificación; se envía como %s\n", recipient, common.FormatAddress(recipient))
+        recipient = common.FormatAddress(recipient)
+    }
+    return recipient, nil
+}
+
+// submitTransaction envía al nodo una transacción ya firmada.
+func submitTransaction(transaction common.Transaction) (*network.SendResponse, error) {
+    var response network.SendResponse
+    err := network.SendRequest(context.Background(), h, peerInfo.ID, "/send-balance", network.MsgSendTransaction, &transaction, &response)
     if err != nil {
-        fmt.Println("Error al leer la respuesta:", err)
-        return ""
+        return nil, nodeError(err)
     }
 
-    log.Println("Respuesta del nodo:", response)
+    log.Printf("Transacción %s incluida en el bloque %d\n", response.Hash, response.Block)
+    return &response, nil
+}
+
+// addressUsed indica si el nodo conoce la dirección, es decir, si se registró o recibió fondos.
+// Una búsqueda hace muchas consultas seguidas, así que se reintenta si el nodo las limita.
+func addressUsed(address string) (bool, error) {
+    var response network.BalanceResponse
+    request := &network.BalanceRequest{Address: address}
+    err := network.SendRequest(context.Background(), h, peerInfo.ID, "/get-balance", network.MsgGetBalance, request, &response)
+    for retry := 1; retry <= network.MaxRateLimitRetries; retry++ {
+        protocolErr, ok := err.(*network.ProtocolError)
+        if !ok || protocolErr.Code != network.CodeRateLimited {
+            break
+        }
+        time.Sleep(time.Duration(retry) * time.Second)
+        err = network.SendRequest(context.Background(), h, peerInfo.ID, "/get-balance", network.MsgGetBalance, request, &response)
+    }
+    if protocolErr, ok := err.(*network.ProtocolError); ok && protocolErr.Code == network.CodeNotFound {
+        return false, nil
+    }
+    if err != nil {
+        return false, nodeError(err)
+    }
+    return true, nil
+}
 
-    response = fmt.Sprintf("Transacción enviada con éxito. Hash: %s", transaction)
+// lookupTransaction consulta al nodo el estado de una transacción.
+func lookupTransaction(hash string) (*network.TransactionInfo, error) {
+    var info network.TransactionInfo
+    err := network.SendRequest(context.Background(), h, peerInfo.ID, "/get-trans", network.MsgGetTransaction, &network.TransactionRequest{Hash: hash}, &info)
+    if err != nil {
+        return nil, nodeError(err)
+    }
+    return &info, nil
+}
 
-    return response
+// accountResponse es lo que se devuelve al crear una cuenta. La frase solo existe en el cliente,
+// cifrada en el almacén de claves; el nodo recibe la dirección y la clave pública. Esta es la
+// única vez que se muestra la frase.
+type accountResponse struct {
+    Address   string
+    PublicKey string
+    Mnemonic  string
+    Notice    string
+    Balance   float64
 }
 
-func getBalanceByAddress(h host.Host, peerInfo *peer.AddrInfo, address string) string {
-    // Abrir un stream al nodo conectado
-    s, err := h.NewStream(context.Background(), peerInfo.ID, "/get-balance")
+// registerAccount da a conocer la cuenta al nodo enviándole su dirección y su clave pública.
+func registerAccount(h host.Host, peerInfo *peer.AddrInfo, account *wallet.Account) (common.User, error) {
+    user := account.User()
+
+    var registered common.User
+    err := network.SendRequest(context.Background(), h, peerInfo.ID, "/create-account", network.MsgCreateAccount, &user, &registered)
     if err != nil {
-        fmt.Println("Error al abrir stream:", err)
-        return ""
+        return common.User{}, err
     }
-    defer s.Close()
 
-    // Enviar la dirección al nodo
-    _, err = s.Write([]byte(address + "\n"))
+    log.Println("Cuenta registrada en el nodo:", registered.Address)
+    return registered, nil
+}
+
+func createAccount(password, passphrase string) (*accountResponse, error) {
+    log.Println("Intentando crear cuenta...")
+
+    account, mnemonic, err := keystore.Create(password, passphrase)
     if err != nil {
-        fmt.Println("Error al enviar la dirección:", err)
-        return ""
+        return nil, keystoreError(err)
     }
 
-    // Leer la respuesta del nodo
-    buf := bufio.NewReader(s)
-    response, err := buf.ReadString('\n')
+    registered, err := registerAccount(h, peerInfo, account)
     if err != nil {
-        fmt.Println("Error al leer la respuesta:", err)
-        return ""
+        return nil, nodeError(err)
     }
 
-    // Convertir la respuesta a float64
-    balance, err := strconv.ParseFloat(strings.TrimSpace(response), 64)
+    return &accountResponse{
+        Address:   account.Address,
+        PublicKey: account.PublicKey.String(),
+        Mnemonic:  mnemonic,
+        Notice:    "Anote la frase: no se volverá a mostrar. Confírmela en /wallet/confirm para poder usar la cuenta.",
+        Balance:   registered.Balance,
+    }, nil
+}
+
+func main() {
+    node := flag.String("node", "", "dirección multiaddr del nodo al que conectarse (por defecto uno del archivo .env)")
+    keystoreDir := flag.String("keystore", "data/wallet", "directorio del almacén de claves cifradas")
+    flag.DurationVar(&unlockTimeout, "unlock-timeout", 5*time.Minute, "tiempo que una cuenta queda desbloqueada si no se indica otro")
+    chainID := flag.String("chain-id", network.DefaultChainID, "identificador de la red del nodo; define el prefijo de las direcciones")
+    offline := flag.Bool("offline", false, "no conectarse a ningún nodo, para firmar transacciones en una máquina aislada")
+    // La API desbloquea y usa las cuentas del almacén, así que por defecto solo escucha en la máquina local
+    listen := flag.String("listen", "127.0.0.1:8080", "dirección en la que escucha la API del cliente")
+    flag.Parse()
+
+    common.AddressVersion, common.MultisigAddressVersion = network.AddressVersions(*chainID)
+
+    var err error
+    keystore, err = wallet.OpenKeystore(*keystoreDir)
     if err != nil {
-        fmt.Println("Error al convertir la respuesta:", err)
-        return ""
+        fmt.Println(err)
+        os.Exit(1)
+    }
+
+    if *offline {
+        log.Println("Modo sin conexión: solo se pueden firmar transacciones y administrar el almacén")
+    } else {
+        h, peerInfo, _, err = ConnectToRandomNode(*node)
+        if err != nil {
+            fmt.Println(err)
+            os.Exit(1)
+        }
     }
 
-    log.Println("Saldo de la cuenta", address + ":", balance)
-    response = fmt.Sprintf("Saldo de la cuenta %s: %f", address, balance)
-    return response
+    r := mux.NewRouter()
+    r.HandleFunc("/create_account", online(createAccountHandler)).Methods("POST")
+    r.HandleFunc("/get_balance", online(getBalanceHandler)).Methods("GET")
+    r.HandleFunc("/send_balance", online(sendBalanceHandler)).Methods("POST")
+    r.HandleFunc("/tx/build", online(buildTransactionHandler)).Methods("POST")
+    r.HandleFunc("/tx/sign", signTransactionHandler).Methods("POST")
+    r.HandleFunc("/tx/submit", online(submitTransactionHandler)).Methods("POST")
+    r.HandleFunc("/transactions/{hash}", online(getTransactionHandler)).Methods("GET")
+    r.HandleFunc("/wallet/accounts", listAccountsHandler).Methods("GET")
+    r.HandleFunc("/wallet/import", importAccountHandler).Methods("POST")
+    r.HandleFunc("/wallet/export", exportAccountHandler).Methods("GET")
+    r.HandleFunc("/wallet/unlock", unlockAccountHandler).Methods("POST")
+    r.HandleFunc("/wallet/lock", lockAccountHandler).Methods("POST")
+    r.HandleFunc("/wallet/confirm", confirmBackupHandler).Methods("POST")
+    r.HandleFunc("/wallet/password", changePasswordHandler).Methods("POST")
+    r.HandleFunc("/wallet/next", nextAddressHandler).Methods("POST")
+    r.HandleFunc("/wallet/scan", online(scanHandler)).Methods("POST")
+    r.HandleFunc("/wallet/recover", online(recoverHandler)).Methods("POST")
+    r.HandleFunc("/multisig/create", createMultisigHandler).Methods("POST")
+    r.HandleFunc("/multisig/new", online(newMultisigTransactionHandler)).Methods("POST")
+    r.HandleFunc("/multisig/sign", signMultisigHandler).Methods("POST")
+    r.HandleFunc("/multisig/combine", combineMultisigHandler).Methods("POST")
+    r.HandleFunc("/multisig/submit", online(submitMultisigHandler)).Methods("POST")
+    
+    log.Printf("Starting server on %s\n", *listen)
+    log.Fatal(http.ListenAndServe(*listen, r))
 }
 
-func getTrans(h host.Host, peerInfo *peer.AddrInfo) {
-    log.Println("Intentando obtener transacción...")
+// Las solicitudes POST llevan sus parámetros en un cuerpo JSON, así que las contraseñas no
+// quedan en la URL. Las transacciones y las transacciones parciales se envían como cuerpo tal
+// como las devuelve la API, para poder pasar el archivo guardado con curl --data @archivo.
+
+// transferRequest es el cuerpo de /send_balance y /tx/build. Sin Fee se usa la comisión mínima
+// del nodo.
+type transferRequest struct {
+    Sender    string   `json:"sender"`
+    Recipient string   `json:"recipient"`
+    Amount    float64  `json:"amount"`
+    Fee       *float64 `json:"fee"`
+}
 
-    // Abrir un stream al nodo seleccionado
-    s, err := h.NewStream(context.Background(), peerInfo.ID, "/get-trans")
+// multisigRequest es el cuerpo de /multisig/create y /multisig/new. Las claves se indican como
+// claves públicas BIP32 o comprimidas en hexadecimal.
+type multisigRequest struct {
+    Threshold int      `json:"threshold"`
+    Keys      []string `json:"keys"`
+    Recipient string   `json:"recipient"`
+    Amount    float64  `json:"amount"`
+    Fee       *float64 `json:"fee"`
+}
+
+func createAccountHandler(w http.ResponseWriter, r *http.Request) {
+    var request struct {
+        Password   string `json:"password"`
+        Passphrase string `json:"passphrase"`
+    }
+    if err := decodeBody(w, r, &request); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    response, err := createAccount(request.Password, request.Passphrase)
     if err != nil {
-        fmt.Println("Error al abrir stream:", err)
+        writeError(w, err)
         return
     }
-    defer s.Close()
+    writeJSON(w, http.StatusCreated, response)
+}
 
-    log.Println("Stream abierto con éxito. Enviando solicitud de obtener transacción...")
+func getBalanceHandler(w http.ResponseWriter, r *http.Request) {
+    address := strings.TrimSpace(r.URL.Query().Get("address"))
+    if err := common.ValidateAddress(address); err != nil {
+        writeError(w, newAPIError(http.StatusBadRequest, "invalid_address", err))
+        return
+    }
 
-    scanner := bufio.NewScanner(os.Stdin)
+    info, err := accountInfo(address)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    log.Println("Saldo de la cuenta", address+":", info.Balance)
+    writeJSON(w, http.StatusOK, info)
+}
 
-    fmt.Print("Ingrese el hash de la transacción: ")
-    scanner.Scan()
-    hash := scanner.Text()
+func sendBalanceHandler(w http.ResponseWriter, r *http.Request) {
+    var request transferRequest
+    if err := decodeBody(w, r, &request); err != nil {
+        writeError(w, err)
+        return
+    }
 
-    // Enviar el hash al nodo
-    _, err = s.Write([]byte(hash + "\n"))
+    // Se firma con la cuenta del almacén, que debe estar desbloqueada
+    account, err := keystore.Account(request.Sender)
     if err != nil {
-        fmt.Println("Error al enviar el hash:", err)
+        writeError(w, keystoreError(err))
         return
     }
 
-    // Leer la respuesta del nodo
-    buf := bufio.NewReader(s)
-    response, err := buf.ReadString('\n')
+    transaction, err := buildTransaction(account.Address, request.Recipient, request.Amount, request.Fee)
     if err != nil {
-        fmt.Println("Error al leer la respuesta:", err)
+        writeError(w, err)
         return
     }
 
-    // Convertir la respuesta a una estructura de transacción
-    var transaction common.Transaction
-    err = json.Unmarshal([]byte(response), &transaction)
+    response, err := sendBalance(account, transaction)
     if err != nil {
-        fmt.Println("Error al decodificar la transacción:", err)
+        writeError(w, err)
         return
     }
+    writeJSON(w, http.StatusOK, response)
+}
 
-    // Imprimir la transacción
-    fmt.Printf("Transacción obtenida: %+v\n", transaction)
+// online responde con un error a las solicitudes que necesitan un nodo si el cliente se inició
+// sin conexión.
+func online(handler http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if h == nil {
+            writeError(w, apiErrorf(http.StatusServiceUnavailable, "offline", "el cliente se inició sin conexión a un nodo"))
+            return
+        }
+        handler(w, r)
+    }
 }
 
+// buildTransactionHandler crea una transacción sin firmar con el nonce y la comisión que
+// informa el nodo. Se guarda en un archivo para firmarla en otra máquina con /tx/sign.
+func buildTransactionHandler(w http.ResponseWriter, r *http.Request) {
+    var request transferRequest
+    if err := decodeBody(w, r, &request); err != nil {
+        writeError(w, err)
+        return
+    }
 
+    transaction, err := buildTransaction(strings.TrimSpace(request.Sender), request.Recipient, request.Amount, request.Fee)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusOK, transaction)
+}
 
-func createAccount(h host.Host, peerInfo *peer.AddrInfo) string {
-    log.Println("Intentando crear cuenta...")
-    // Abrir un stream al nodo seleccionado
-    s, err := h.NewStream(context.Background(), peerInfo.ID, "/create-account")
+// signTransactionHandler firma una transacción sin firmar con la cuenta desbloqueada de su
+// remitente. No consulta al nodo, así que funciona con -offline.
+func signTransactionHandler(w http.ResponseWriter, r *http.Request) {
+    transaction, err := readTransaction(w, r)
     if err != nil {
-        fmt.Println("Error al abrir stream:", err)
-        return ""
+        writeError(w, err)
+        return
+    }
+    account, err := keystore.Account(transaction.Sender)
+    if err != nil {
+        writeError(w, keystoreError(err))
+        return
     }
-    defer s.Close()
+    if err := account.Sign(transaction); err != nil {
+        writeError(w, newAPIError(http.StatusBadRequest, "invalid_transaction", err))
+        return
+    }
+    writeJSON(w, http.StatusOK, transaction)
+}
 
-    log.Println("Stream abierto con éxito. Enviando solicitud de creación de cuenta...")
-    // Enviar una solicitud de creación de cuenta
-    _, err = s.Write([]byte("create_account\n"))
+// submitTransactionHandler envía al nodo una transacción ya firmada, sin necesitar las claves
+// de su remitente.
+func submitTransactionHandler(w http.ResponseWriter, r *http.Request) {
+    transaction, err := readTransaction(w, r)
     if err != nil {
-        fmt.Println("Error al enviar solicitud:", err)
-        return ""
+        writeError(w, err)
+        return
+    }
+    if !wallet.Signed(*transaction) {
+        writeError(w, apiErrorf(http.StatusBadRequest, "unsigned_transaction", "la transacción no está firmada"))
+        return
+    }
+
+    response, err := submitTransaction(*transaction)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusOK, response)
+}
+
+// getTransactionHandler devuelve la transacción con su estado: confirmed con la altura, el
+// hash del bloque y las confirmaciones, pending si está en el mempool del nodo, o unknown, con
+// estado 404, si el nodo no la conoce.
+func getTransactionHandler(w http.ResponseWriter, r *http.Request) {
+    hash := strings.ToLower(strings.TrimSpace(mux.Vars(r)["hash"]))
+    if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != sha256.Size {
+        writeError(w, apiErrorf(http.StatusBadRequest, "invalid_hash", "hash de transacción inválido: %s", hash))
+        return
     }
 
-    log.Println("Solicitud enviada. Esperando respuesta...")
-    // Esperar y recibir la respuesta del nodo
-    buf := bufio.NewReader(s)
-    response, err := buf.ReadString('\n')
+    info, err := lookupTransaction(hash)
     if err != nil {
-        fmt.Println("Error al leer la respuesta:", err)
-        return ""
+        writeError(w, err)
+        return
     }
+    writeJSON(w, network.TransactionStatusCode(info), info)
+}
 
-    log.Println("Respuesta del nodo:", response)
-    return response
+func listAccountsHandler(w http.ResponseWriter, r *http.Request) {
+    accounts, err := keystore.List()
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusOK, accounts)
 }
 
-func main() {
+// importAccountHandler agrega una cuenta al almacén a partir de su frase mnemónica o clave
+// privada (secret) o de un archivo exportado de otro almacén (file), y la registra en el nodo.
+func importAccountHandler(w http.ResponseWriter, r *http.Request) {
+    var request struct {
+        Password   string          `json:"password"`
+        Secret     string          `json:"secret"`
+        Passphrase string          `json:"passphrase"`
+        File       json.RawMessage `json:"file"`
+    }
+    if err := decodeBody(w, r, &request); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    var account *wallet.Account
     var err error
-    h, peerInfo, _, err = ConnectToRandomNode()
+    if len(request.File) > 0 {
+        account, err = keystore.ImportFile(request.File, request.Password)
+    } else {
+        account, err = keystore.Import(request.Secret, request.Passphrase, request.Password)
+    }
     if err != nil {
-        fmt.Println(err)
-        os.Exit(1)
+        writeError(w, keystoreError(err))
+        return
     }
 
-    r := mux.NewRouter()
-    r.HandleFunc("/create_account", createAccountHandler).Methods("POST")
-    r.HandleFunc("/get_balance", getBalanceHandler).Methods("GET")
-    r.HandleFunc("/send_balance", sendBalanceHandler).Methods("POST")
-    r.HandleFunc("/get_transaction", getTransactionHandler).Methods("GET")
-    
-    log.Println("Starting server on :8080")
-    log.Fatal(http.ListenAndServe(":8080", r))
+    if h != nil {
+        if _, err := registerAccount(h, peerInfo, account); err != nil {
+            log.Printf("Error al registrar la cuenta importada: %v\n", err)
+        }
+    }
+    writeJSON(w, http.StatusCreated, wallet.AccountInfo{Address: account.Address, PublicKey: account.PublicKey.String()})
 }
 
-func createAccountHandler(w http.ResponseWriter, r *http.Request) {
-    response := createAccount(h, peerInfo)
-    fmt.Fprintf(w, response)
+func exportAccountHandler(w http.ResponseWriter, r *http.Request) {
+    data, err := keystore.Export(r.URL.Query().Get("address"))
+    if err != nil {
+        writeError(w, keystoreError(err))
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    w.Write(data)
 }
 
-func getBalanceHandler(w http.ResponseWriter, r *http.Request) {
-    data := r.URL.Query()
-    address := data.Get("address")
-    response := getBalanceByAddress(h, peerInfo, address)
-    fmt.Fprintf(w, response)
+func unlockAccountHandler(w http.ResponseWriter, r *http.Request) {
+    var request struct {
+        Address  string `json:"address"`
+        Password string `json:"password"`
+        Timeout  string `json:"timeout"`
+    }
+    if err := decodeBody(w, r, &request); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    timeout := unlockTimeout
+    if request.Timeout != "" {
+        var err error
+        timeout, err = time.ParseDuration(request.Timeout)
+        if err != nil || timeout < 0 {
+            writeError(w, apiErrorf(http.StatusBadRequest, "invalid_timeout", "plazo inválido: %s", request.Timeout))
+            return
+        }
+    }
+
+    if err := keystore.Unlock(request.Address, request.Password, timeout); err != nil {
+        writeError(w, keystoreError(err))
+        return
+    }
+    writeJSON(w, http.StatusOK, map[string]string{"unlocked": request.Address, "timeout": timeout.String()})
 }
 
-func sendBalanceHandler(w http.ResponseWriter, r *http.Request) {
-    data := r.URL.Query()
-    sender := data.Get("sender")
-    recipient := data.Get("recipient")
-    amountStr := data.Get("amount")
-    privateKey := data.Get("privateKey")
+// confirmBackupHandler comprueba que el usuario anotó la frase de una billetera recién creada,
+// y su passphrase si la tiene, y habilita desbloquearla.
+func confirmBackupHandler(w http.ResponseWriter, r *http.Request) {
+    var request struct {
+        Address    string `json:"address"`
+        Mnemonic   string `json:"mnemonic"`
+        Passphrase string `json:"passphrase"`
+    }
+    if err := decodeBody(w, r, &request); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    if err := keystore.ConfirmBackup(request.Address, request.Mnemonic, request.Passphrase); err != nil {
+        writeError(w, keystoreError(err))
+        return
+    }
+    writeJSON(w, http.StatusOK, map[string]string{"confirmed": request.Address})
+}
+
+func lockAccountHandler(w http.ResponseWriter, r *http.Request) {
+    var request struct {
+        Address string `json:"address"`
+    }
+    if err := decodeBody(w, r, &request); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    keystore.Lock(request.Address)
+    writeJSON(w, http.StatusOK, map[string]string{"locked": request.Address})
+}
+
+func changePasswordHandler(w http.ResponseWriter, r *http.Request) {
+    var request struct {
+        Address     string `json:"address"`
+        Password    string `json:"password"`
+        NewPassword string `json:"new_password"`
+    }
+    if err := decodeBody(w, r, &request); err != nil {
+        writeError(w, err)
+        return
+    }
+    if request.NewPassword == "" {
+        writeError(w, apiErrorf(http.StatusBadRequest, "missing_password", "se requiere la contraseña nueva"))
+        return
+    }
 
-    amount, err := strconv.ParseFloat(amountStr, 64)
+    if err := keystore.ChangePassword(request.Address, request.Password, request.NewPassword); err != nil {
+        writeError(w, keystoreError(err))
+        return
+    }
+    writeJSON(w, http.StatusOK, map[string]string{"address": request.Address})
+}
+
+// nextAddressHandler deriva una dirección de recepción nueva de la billetera que contiene
+// address. account elige la cuenta BIP44 (por defecto 0); empezar una cuenta nueva requiere
+// password. No consulta al nodo: la cuenta se crea en la cadena al recibir fondos.
+func nextAddressHandler(w http.ResponseWriter, r *http.Request) {
+    var request struct {
+        Address  string `json:"address"`
+        Account  uint32 `json:"account"`
+        Password string `json:"password"`
+    }
+    if err := decodeBody(w, r, &request); err != nil {
+        writeError(w, err)
+        return
+    }
+    if request.Account >= 1<<31 {
+        writeError(w, apiErrorf(http.StatusBadRequest, "invalid_account", "cuenta inválida: %d", request.Account))
+        return
+    }
+
+    derived, err := keystore.NextAddress(request.Address, request.Account, request.Password)
     if err != nil {
-        fmt.Println("Error al convertir la cantidad:", err)
+        writeError(w, keystoreError(err))
         return
     }
+    writeJSON(w, http.StatusOK, derived)
+}
 
-    response := sendBalance(h, peerInfo, sender, recipient, amount, privateKey)
+// scanHandler busca en la cadena las direcciones usadas de la billetera que contiene address.
+func scanHandler(w http.ResponseWriter, r *http.Request) {
+    var request struct {
+        Address string `json:"address"`
+    }
+    if err := decodeBody(w, r, &request); err != nil {
+        writeError(w, err)
+        return
+    }
 
-    fmt.Fprintf(w, response)
+    added, err := keystore.Scan(request.Address, addressUsed)
+    if err != nil {
+        writeError(w, keystoreError(err))
+        return
+    }
+    writeJSON(w, http.StatusOK, map[string]interface{}{"added": added})
 }
 
-func getTransactionHandler(w http.ResponseWriter, r *http.Request) {
-    //getTrans(h, peerInfo)
-}
\ No newline at end of file
+// recoverHandler guarda una frase mnemónica con todas las direcciones usadas que se encuentran
+// en la cadena.
+func recoverHandler(w http.ResponseWriter, r *http.Request) {
+    var request struct {
+        Mnemonic   string `json:"mnemonic"`
+        Passphrase string `json:"passphrase"`
+        Password   string `json:"password"`
+    }
+    if err := decodeBody(w, r, &request); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    account, found, err := keystore.Recover(request.Mnemonic, request.Passphrase, request.Password, addressUsed)
+    if err != nil {
+        writeError(w, keystoreError(err))
+        return
+    }
+    writeJSON(w, http.StatusCreated, map[string]interface{}{"wallet": account.Address, "addresses": found})
+}
+
+// parseMultisig arma la descripción de una multifirma a partir del umbral y las claves
+// públicas de la solicitud.
+func parseMultisig(request multisigRequest) (*common.Multisig, error) {
+    var keys []string
+    for _, value := range request.Keys {
+        key, err := wallet.ParsePublicKey(value)
+        if err != nil {
+            return nil, newAPIError(http.StatusBadRequest, "invalid_multisig", err)
+        }
+        keys = append(keys, key)
+    }
+    multisig, err := common.NewMultisig(request.Threshold, keys)
+    if err != nil {
+        return nil, newAPIError(http.StatusBadRequest, "invalid_multisig", err)
+    }
+    return multisig, nil
+}
+
+// createMultisigHandler calcula la dirección de una cuenta multifirma. No hace falta
+// registrarla: se crea en la cadena al recibir fondos.
+func createMultisigHandler(w http.ResponseWriter, r *http.Request) {
+    var request multisigRequest
+    if err := decodeBody(w, r, &request); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    multisig, err := parseMultisig(request)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusOK, map[string]interface{}{"address": multisig.Address(), "multisig": multisig})
+}
+
+// newMultisigTransactionHandler crea una transacción sin firmas desde una cuenta multifirma,
+// para pasarla a los firmantes.
+func newMultisigTransactionHandler(w http.ResponseWriter, r *http.Request) {
+    var request multisigRequest
+    if err := decodeBody(w, r, &request); err != nil {
+        writeError(w, err)
+        return
+    }
+
+    multisig, err := parseMultisig(request)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    recipient, err := parseRecipient(request.Recipient)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    if request.Amount <= 0 {
+        writeError(w, apiErrorf(http.StatusBadRequest, "invalid_amount", "monto inválido: %f", request.Amount))
+        return
+    }
+
+    info, err := accountInfo(multisig.Address())
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    fee, err := transactionFee(request.Fee, info)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    if info.Balance < request.Amount+fee {
+        writeError(w, apiErrorf(http.StatusUnprocessableEntity, "insufficient_funds", "saldo insuficiente: la cuenta tiene %f y la transacción requiere %f", info.Balance, request.Amount+fee))
+        return
+    }
+
+    partial, err := wallet.NewMultisigTransaction(multisig, recipient, request.Amount, fee, info.Nonce+1)
+    if err != nil {
+        writeError(w, newAPIError(http.StatusBadRequest, "invalid_transaction", err))
+        return
+    }
+    writeJSON(w, http.StatusOK, partial)
+}
+
+// signMultisigHandler firma la transacción parcial del cuerpo con la cuenta desbloqueada
+// address, indicada en la URL. No consulta al nodo, así que puede hacerlo un firmante sin
+// conexión.
+func signMultisigHandler(w http.ResponseWriter, r *http.Request) {
+    partial, err := readPartial(w, r)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    account, err := keystore.Account(r.URL.Query().Get("address"))
+    if err != nil {
+        writeError(w, keystoreError(err))
+        return
+    }
+    if err := account.SignMultisig(partial); err != nil {
+        writeError(w, newAPIError(http.StatusBadRequest, "invalid_transaction", err))
+        return
+    }
+    writeJSON(w, http.StatusOK, partial)
+}
+
+// combineMultisigHandler reúne las firmas de varias copias de una transacción parcial,
+// firmadas por separado y enviadas en una lista JSON.
+func combineMultisigHandler(w http.ResponseWriter, r *http.Request) {
+    var copies []json.RawMessage
+    if err := decodeBody(w, r, &copies); err != nil {
+        writeError(w, err)
+        return
+    }
+    if len(copies) == 0 {
+        writeError(w, apiErrorf(http.StatusBadRequest, "invalid_transaction", "no se recibió ninguna transacción"))
+        return
+    }
+
+    var combined *wallet.PartialTransaction
+    for _, data := range copies {
+        partial, err := wallet.ParsePartial(data)
+        if err == nil && combined != nil {
+            err = combined.Combine(partial)
+        }
+        if err != nil {
+            writeError(w, newAPIError(http.StatusBadRequest, "invalid_transaction", err))
+            return
+        }
+        if combined == nil {
+            combined = partial
+        }
+    }
+    writeJSON(w, http.StatusOK, combined)
+}
+
+// submitMultisigHandler envía al nodo una transacción parcial que ya reunió el umbral.
+func submitMultisigHandler(w http.ResponseWriter, r *http.Request) {
+    partial, err := readPartial(w, r)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    if !partial.Complete() {
+        writeError(w, apiErrorf(http.StatusUnprocessableEntity, "missing_signatures", "faltan firmas: tiene %d de las %d requeridas", partial.Signed, partial.Required))
+        return
+    }
+
+    response, err := submitTransaction(partial.Transaction)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusOK, response)
+}
+
+// apiError es el cuerpo de las respuestas con error, dentro de {"error": ...}. Code es un
+// identificador estable para los programas y Message la descripción para las personas;
+// Details agrega datos propios del error, como las palabras sugeridas de una frase.
+type apiError struct {
+    Status  int         `json:"-"`
+    Code    string      `json:"code"`
+    Message string      `json:"message"`
+    Details interface{} `json:"details,omitempty"`
+}
+
+func (e *apiError) Error() string {
+    return e.Message
+}
+
+func newAPIError(status int, code string, err error) *apiError {
+    return &apiError{Status: status, Code: code, Message: err.Error()}
+}
+
+func apiErrorf(status int, code, format string, args ...interface{}) *apiError {
+    return &apiError{Status: status, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// nodeError traduce un error del nodo. Los errores que no vienen del nodo, como no poder abrir
+// el stream, indican que no se pudo comunicar con él.
+func nodeError(err error) error {
+    protocolErr, ok := err.(*network.ProtocolError)
+    if !ok {
+        if _, ok := err.(*apiError); ok {
+            return err
+        }
+        return apiErrorf(http.StatusBadGateway, "node_unreachable", "no se pudo comunicar con el nodo: %v", err)
+    }
+
+    switch protocolErr.Code {
+    case network.CodeBadRequest:
+        return newAPIError(http.StatusBadRequest, "bad_request", protocolErr)
+    case network.CodeNotFound:
+        return newAPIError(http.StatusNotFound, "not_found", protocolErr)
+    case network.CodePruned:
+        return newAPIError(http.StatusGone, "pruned", protocolErr)
+    case network.CodeRejected:
+        return newAPIError(http.StatusUnprocessableEntity, "rejected", protocolErr)
+    case network.CodeRateLimited:
+        return newAPIError(http.StatusTooManyRequests, "rate_limited", protocolErr)
+    case network.CodeUnavailable, network.CodeTimeout:
+        return newAPIError(http.StatusBadGateway, "node_unreachable", protocolErr)
+    }
+    return newAPIError(http.StatusBadGateway, "node_error", protocolErr)
+}
+
+// keystoreError traduce los errores del almacén de claves.
+func keystoreError(err error) error {
+    switch err {
+    case wallet.ErrNoAccount:
+        return newAPIError(http.StatusNotFound, "account_not_found", err)
+    case wallet.ErrExists:
+        return newAPIError(http.StatusConflict, "account_exists", err)
+    case wallet.ErrLocked:
+        return newAPIError(http.StatusForbidden, "account_locked", err)
+    case wallet.ErrWrongPassword:
+        return newAPIError(http.StatusForbidden, "wrong_password", err)
+    case wallet.ErrBackupPending:
+        return newAPIError(http.StatusForbidden, "backup_pending", err)
+    case wallet.ErrBackupMismatch:
+        return newAPIError(http.StatusBadRequest, "backup_mismatch", err)
+    }
+    if mnemonicErr, ok := err.(*wallet.MnemonicError); ok {
+        apiErr := newAPIError(http.StatusBadRequest, "invalid_mnemonic", err)
+        apiErr.Details = mnemonicErr
+        return apiErr
+    }
+    if _, ok := err.(*apiError); ok {
+        return err
+    }
+    return newAPIError(http.StatusBadRequest, "bad_request", err)
+}
+
+// maxRequestBody limita el tamaño de los cuerpos de las solicitudes.
+const maxRequestBody = 1 << 20
+
+// decodeBody lee el cuerpo JSON de la solicitud en v.
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) error {
+    decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody))
+    decoder.DisallowUnknownFields()
+    if err := decoder.Decode(v); err != nil {
+        return apiErrorf(http.StatusBadRequest, "invalid_json", "cuerpo JSON inválido: %v", err)
+    }
+    return nil
+}
+
+// readTransaction lee la transacción del cuerpo de la solicitud.
+func readTransaction(w http.ResponseWriter, r *http.Request) (*common.Transaction, error) {
+    var data json.RawMessage
+    if err := decodeBody(w, r, &data); err != nil {
+        return nil, err
+    }
+    transaction, err := wallet.ParseTransaction(data)
+    if err != nil {
+        return nil, newAPIError(http.StatusBadRequest, "invalid_transaction", err)
+    }
+    return transaction, nil
+}
+
+// readPartial lee la transacción parcialmente firmada del cuerpo de la solicitud.
+func readPartial(w http.ResponseWriter, r *http.Request) (*wallet.PartialTransaction, error) {
+    var data json.RawMessage
+    if err := decodeBody(w, r, &data); err != nil {
+        return nil, err
+    }
+    partial, err := wallet.ParsePartial(data)
+    if err != nil {
+        return nil, newAPIError(http.StatusBadRequest, "invalid_transaction", err)
+    }
+    return partial, nil
+}
+
+// writeError responde con el sobre de error. Los errores sin clasificar son fallos internos.
+func writeError(w http.ResponseWriter, err error) {
+    apiErr, ok := err.(*apiError)
+    if !ok {
+        apiErr = newAPIError(http.StatusInternalServerError, "internal", err)
+    }
+    writeJSON(w, apiErr.Status, map[string]*apiError{"error": apiErr})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(v)
+}
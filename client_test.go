@@ -0,0 +1,39 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "testing"
+    "blockchain/network"
+)
+
+// Las pruebas del cliente se ejecutan con: go test client.go client_test.go
+func TestNodeError(t *testing.T) {
+    tests := []struct {
+        name   string
+        err    error
+        status int
+        code   string
+    }{
+        {"solicitud inválida", &network.ProtocolError{Code: network.CodeBadRequest}, http.StatusBadRequest, "bad_request"},
+        {"no encontrado", &network.ProtocolError{Code: network.CodeNotFound}, http.StatusNotFound, "not_found"},
+        {"podado", &network.ProtocolError{Code: network.CodePruned}, http.StatusGone, "pruned"},
+        {"rechazada", &network.ProtocolError{Code: network.CodeRejected}, http.StatusUnprocessableEntity, "rejected"},
+        {"límite de solicitudes", &network.ProtocolError{Code: network.CodeRateLimited}, http.StatusTooManyRequests, "rate_limited"},
+        {"sin respuesta a tiempo", &network.ProtocolError{Code: network.CodeTimeout}, http.StatusBadGateway, "node_unreachable"},
+        {"fallo interno del nodo", &network.ProtocolError{Code: network.CodeInternal}, http.StatusBadGateway, "node_error"},
+        {"sin conexión", fmt.Errorf("no se pudo abrir el stream"), http.StatusBadGateway, "node_unreachable"},
+        {"error del cliente", apiErrorf(http.StatusBadRequest, "invalid_hash", "hash inválido"), http.StatusBadRequest, "invalid_hash"},
+    }
+
+    for _, test := range tests {
+        err, ok := nodeError(test.err).(*apiError)
+        if !ok {
+            t.Errorf("%s: no es un error de la API", test.name)
+            continue
+        }
+        if err.Status != test.status || err.Code != test.code {
+            t.Errorf("%s: %d %s, se esperaba %d %s", test.name, err.Status, err.Code, test.status, test.code)
+        }
+    }
+}
@@ -0,0 +1,149 @@
+package common
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "github.com/mr-tron/base58"
+    "golang.org/x/crypto/ripemd160"
+)
+
+// Bytes de versión de las direcciones. Las de la red principal empiezan con C y las de las
+// demás redes con m o n, así que una dirección de prueba no pasa por una de la red principal.
+// Las direcciones multifirma tienen su propia versión: empiezan con D en la red principal y
+// con 2 en las demás.
+const (
+    MainNetAddressVersion  byte = 0x1c
+    TestNetAddressVersion  byte = 0x6f
+    MainNetMultisigVersion byte = 0x1e
+    TestNetMultisigVersion byte = 0xc4
+)
+
+// AddressVersion y MultisigAddressVersion son los bytes de versión de la red en la que trabaja
+// el proceso. El nodo y el cliente los fijan al arrancar según su ChainID.
+var (
+    AddressVersion         = MainNetAddressVersion
+    MultisigAddressVersion = MainNetMultisigVersion
+)
+
+// addressHashSize es el tamaño de RIPEMD160(SHA256(clave)).
+const addressHashSize = ripemd160.Size
+
+// AddressHash calcula el hash de una clave pública secp256k1 comprimida que identifica a su
+// cuenta: RIPEMD160(SHA256(clave)).
+func AddressHash(publicKey []byte) []byte {
+    hash := sha256.Sum256(publicKey)
+
+    ripemd := ripemd160.New()
+    ripemd.Write(hash[:])
+    return ripemd.Sum(nil)
+}
+
+// DeriveAddress calcula la dirección de una clave pública secp256k1 comprimida en Base58Check.
+func DeriveAddress(publicKey []byte) string {
+    return EncodeAddress(AddressHash(publicKey))
+}
+
+// EncodeAddress codifica el hash de una cuenta en Base58Check: el byte de versión de la red,
+// el hash y los primeros 4 bytes de SHA256(SHA256(versión y hash)) como suma de verificación.
+func EncodeAddress(hash []byte) string {
+    return encodeAddress(AddressVersion, hash)
+}
+
+func encodeAddress(version byte, hash []byte) string {
+    payload := append([]byte{version}, hash...)
+    return base58.Encode(append(payload, addressChecksum(payload)...))
+}
+
+func addressChecksum(payload []byte) []byte {
+    first := sha256.Sum256(payload)
+    second := sha256.Sum256(first[:])
+    return second[:4]
+}
+
+// ParseAddress devuelve el hash de una dirección en Base58Check de la red del proceso, de una
+// clave o multifirma. Durante la transición también acepta las direcciones hexadecimales
+// anteriores, que no tienen suma de verificación.
+func ParseAddress(address string) ([]byte, error) {
+    _, hash, err := decodeAddress(address)
+    return hash, err
+}
+
+// decodeAddress devuelve el byte de versión y el hash de una dirección. Las direcciones
+// hexadecimales, que son todas de una clave, se devuelven con AddressVersion.
+func decodeAddress(address string) (byte, []byte, error) {
+    if address == "" {
+        return 0, nil, fmt.Errorf("dirección vacía")
+    }
+
+    if len(address) == 2*addressHashSize {
+        if hash, err := hex.DecodeString(address); err == nil {
+            return AddressVersion, hash, nil
+        }
+    }
+
+    decoded, err := base58.Decode(address)
+    if err != nil {
+        return 0, nil, fmt.Errorf("dirección inválida %s: contiene caracteres que no son Base58", address)
+    }
+    if len(decoded) != 1+addressHashSize+4 {
+        return 0, nil, fmt.Errorf("dirección inválida %s: longitud incorrecta", address)
+    }
+
+    payload, checksum := decoded[:1+addressHashSize], decoded[1+addressHashSize:]
+    if !bytes.Equal(checksum, addressChecksum(payload)) {
+        return 0, nil, fmt.Errorf("dirección inválida %s: la suma de verificación no coincide; revise que esté bien copiada", address)
+    }
+    if payload[0] != AddressVersion && payload[0] != MultisigAddressVersion {
+        return 0, nil, fmt.Errorf("la dirección %s es de otra red", address)
+    }
+    return payload[0], payload[1:], nil
+}
+
+// ValidateAddress comprueba que la dirección sea válida en la red del proceso.
+func ValidateAddress(address string) error {
+    _, err := ParseAddress(address)
+    return err
+}
+
+// IsMultisigAddress indica si la dirección es de una cuenta multifirma de la red del proceso.
+func IsMultisigAddress(address string) bool {
+    version, _, err := decodeAddress(address)
+    return err == nil && version == MultisigAddressVersion
+}
+
+// IsLegacyAddress indica si la dirección está en el formato hexadecimal anterior.
+func IsLegacyAddress(address string) bool {
+    _, err := hex.DecodeString(address)
+    return len(address) == 2*addressHashSize && err == nil
+}
+
+// AddressKey devuelve la forma canónica de una dirección, su hash en hexadecimal en minúsculas,
+// con la que se guardan las cuentas y el estado. Así las dos codificaciones de una misma
+// dirección llegan a la misma cuenta, y el estado de los bloques anteriores, que solo tienen
+// direcciones hexadecimales, no cambia. Lo que no es una dirección, como el remitente de las
+// emisiones, se devuelve sin cambios.
+func AddressKey(address string) string {
+    hash, err := ParseAddress(address)
+    if err != nil {
+        return address
+    }
+    return hex.EncodeToString(hash)
+}
+
+// FormatAddress devuelve una dirección hexadecimal en Base58Check, para mostrarla. Como las
+// direcciones hexadecimales son todas de una clave, la forma canónica de una multifirma no se
+// puede volver a codificar; lo que no es una dirección hexadecimal se devuelve sin cambios.
+func FormatAddress(address string) string {
+    if !IsLegacyAddress(address) {
+        return address
+    }
+    hash, _ := hex.DecodeString(address)
+    return EncodeAddress(hash)
+}
+
+// SameAddress indica si dos direcciones, en cualquiera de las codificaciones, son la misma.
+func SameAddress(a, b string) bool {
+    return AddressKey(a) == AddressKey(b)
+}
@@ -0,0 +1,59 @@
+package common
+
+import (
+    "encoding/hex"
+    "strings"
+    "testing"
+)
+
+const testPublicKey = "0339a36013301597daef41fbe593a02cc513d0b55527ec2df1050e2e8ff49c85c2"
+
+func TestAddressEncoding(t *testing.T) {
+    key, _ := hex.DecodeString(testPublicKey)
+    address := DeriveAddress(key)
+    legacy := hex.EncodeToString(AddressHash(key))
+
+    if !strings.HasPrefix(address, "C") {
+        t.Fatalf("la dirección de la red principal no empieza con C: %s", address)
+    }
+    if err := ValidateAddress(address); err != nil {
+        t.Fatal(err)
+    }
+    if err := ValidateAddress(legacy); err != nil {
+        t.Fatalf("se rechazó una dirección hexadecimal: %v", err)
+    }
+    if !SameAddress(address, legacy) || !SameAddress(address, strings.ToUpper(legacy)) {
+        t.Fatal("las dos codificaciones no son la misma dirección")
+    }
+    if AddressKey(address) != legacy || FormatAddress(legacy) != address {
+        t.Fatal("conversión incorrecta entre codificaciones")
+    }
+
+    // Cualquier carácter cambiado invalida la suma de verificación
+    for i := range address {
+        c := byte('2')
+        if address[i] == c {
+            c = '3'
+        }
+        typo := address[:i] + string(c) + address[i+1:]
+        if err := ValidateAddress(typo); err == nil {
+            t.Fatalf("se aceptó la dirección con una errata en la posición %d: %s", i, typo)
+        }
+    }
+
+    // Una dirección de otra red no es válida en esta
+    defer func(version byte) { AddressVersion = version }(AddressVersion)
+    AddressVersion = TestNetAddressVersion
+    test := DeriveAddress(key)
+    if test == address || (test[0] != 'm' && test[0] != 'n') {
+        t.Fatalf("prefijo inesperado en la red de prueba: %s", test)
+    }
+    if err := ValidateAddress(address); err == nil {
+        t.Fatal("se aceptó una dirección de la red principal en la de prueba")
+    }
+
+    // Lo que no es una dirección, como el remitente de las emisiones, no se modifica
+    if AddressKey("0") != "0" {
+        t.Fatal("se modificó un remitente que no es una dirección")
+    }
+}
@@ -0,0 +1,145 @@
+package common
+
+import (
+    "bytes"
+    "encoding/hex"
+    "fmt"
+    "sort"
+    "strconv"
+    "strings"
+    "github.com/decred/dcrd/dcrec/secp256k1/v4"
+    "github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+)
+
+// MaxMultisigKeys es la cantidad máxima de claves de una cuenta multifirma.
+const MaxMultisigKeys = 15
+
+// NewMultisig describe la cuenta que requiere threshold firmas de las claves indicadas, en
+// hexadecimal comprimido. Las claves se ordenan, así que la dirección no depende del orden en
+// que se den.
+func NewMultisig(threshold int, publicKeys []string) (*Multisig, error) {
+    keys := make([]string, len(publicKeys))
+    for i, key := range publicKeys {
+        keys[i] = strings.ToLower(strings.TrimSpace(key))
+    }
+    sort.Strings(keys)
+
+    multisig := &Multisig{Threshold: threshold, PublicKeys: keys}
+    if err := multisig.Validate(); err != nil {
+        return nil, err
+    }
+    return multisig, nil
+}
+
+// Validate comprueba el umbral y las claves de la descripción.
+func (m *Multisig) Validate() error {
+    n := len(m.PublicKeys)
+    if n == 0 || n > MaxMultisigKeys {
+        return fmt.Errorf("una multifirma debe tener entre 1 y %d claves, tiene %d", MaxMultisigKeys, n)
+    }
+    if m.Threshold < 1 || m.Threshold > n {
+        return fmt.Errorf("umbral inválido: %d de %d claves", m.Threshold, n)
+    }
+
+    for i, key := range m.PublicKeys {
+        keyBytes, err := hex.DecodeString(key)
+        if err != nil || hex.EncodeToString(keyBytes) != key {
+            return fmt.Errorf("clave %d de la multifirma no hexadecimal", i+1)
+        }
+        if _, err := secp256k1.ParsePubKey(keyBytes); err != nil || len(keyBytes) != secp256k1.PubKeyBytesLenCompressed {
+            return fmt.Errorf("clave %d de la multifirma inválida: se espera una clave comprimida", i+1)
+        }
+        if i > 0 && key <= m.PublicKeys[i-1] {
+            return fmt.Errorf("las claves de la multifirma deben estar ordenadas y no repetirse")
+        }
+    }
+    return nil
+}
+
+// String es la forma de la descripción que cubren la dirección, las firmas y el hash de las
+// transacciones: "M-de-N:clave,clave,...".
+func (m *Multisig) String() string {
+    return strconv.Itoa(m.Threshold) + "-de-" + strconv.Itoa(len(m.PublicKeys)) + ":" + strings.Join(m.PublicKeys, ",")
+}
+
+// Address es la dirección de la cuenta: el hash de la descripción con la versión multifirma.
+func (m *Multisig) Address() string {
+    return encodeAddress(MultisigAddressVersion, AddressHash([]byte(m.String())))
+}
+
+// Index devuelve la posición de una clave en la descripción, o -1 si no es de la cuenta.
+func (m *Multisig) Index(publicKey []byte) int {
+    for i, key := range m.PublicKeys {
+        if keyBytes, err := hex.DecodeString(key); err == nil && bytes.Equal(keyBytes, publicKey) {
+            return i
+        }
+    }
+    return -1
+}
+
+// CountSignatures devuelve cuántas firmas válidas tiene una transacción multifirma. Una firma
+// presente pero inválida es un error: no se aceptan transacciones con firmas falsas aunque
+// las demás alcancen el umbral.
+func CountSignatures(transaction Transaction) (int, error) {
+    m := transaction.Multisig
+    if m == nil {
+        return 0, fmt.Errorf("la transacción no es de una cuenta multifirma")
+    }
+    if err := m.Validate(); err != nil {
+        return 0, err
+    }
+    if len(transaction.Signatures) != len(m.PublicKeys) {
+        return 0, fmt.Errorf("se esperaban %d posiciones de firma y hay %d", len(m.PublicKeys), len(transaction.Signatures))
+    }
+
+    hash := TransactionSigningHash(transaction)
+    valid := 0
+    for i, encoded := range transaction.Signatures {
+        if encoded == "" {
+            continue
+        }
+        keyBytes, _ := hex.DecodeString(m.PublicKeys[i])
+        publicKey, _ := secp256k1.ParsePubKey(keyBytes)
+
+        signatureBytes, err := hex.DecodeString(encoded)
+        if err != nil {
+            return 0, fmt.Errorf("firma %d no hexadecimal", i+1)
+        }
+        signature, err := ecdsa.ParseDERSignature(signatureBytes)
+        if err != nil {
+            return 0, fmt.Errorf("firma %d mal formada: %v", i+1, err)
+        }
+        if !signature.Verify(hash, publicKey) {
+            return 0, fmt.Errorf("firma %d inválida", i+1)
+        }
+        valid++
+    }
+    return valid, nil
+}
+
+// VerifyMultisig comprueba que la descripción de la transacción corresponda al remitente y que
+// tenga al menos el umbral de firmas válidas.
+func VerifyMultisig(transaction Transaction) error {
+    m := transaction.Multisig
+    if m == nil {
+        return fmt.Errorf("la transacción no es de una cuenta multifirma")
+    }
+    if transaction.PublicKey != "" || transaction.Signature != "" {
+        return fmt.Errorf("una transacción multifirma no lleva clave pública ni firma individual")
+    }
+    if err := m.Validate(); err != nil {
+        return err
+    }
+    if !IsMultisigAddress(transaction.Sender) || !SameAddress(m.Address(), transaction.Sender) {
+        return fmt.Errorf("la multifirma no corresponde al remitente %s", transaction.Sender)
+    }
+
+    valid, err := CountSignatures(transaction)
+    if err != nil {
+        return err
+    }
+    if valid < m.Threshold {
+        return fmt.Errorf("faltan firmas: tiene %d de las %d requeridas", valid, m.Threshold)
+    }
+    return nil
+}
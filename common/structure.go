@@ -10,6 +10,8 @@ type Header struct {
     Hash        string
     TimeStamp   int64
     Nonce       int64
+    StateHash   string `json:",omitempty"`
+    TxRoot      string `json:",omitempty"`
 }
 
 type Block struct {
@@ -25,11 +27,42 @@ type Transaction struct {
     Signature   string
     TimeStamp   int64
     Hash        string
+    PublicKey   string `json:",omitempty"`
+    // Las transacciones de una cuenta multifirma llevan su descripción en lugar de PublicKey
+    // y una firma por clave, en el mismo orden, vacía si esa clave no firmó
+    Multisig    *Multisig `json:",omitempty"`
+    Signatures  []string  `json:",omitempty"`
+    // Nonce es el número de la transacción entre las enviadas por el remitente, empezando en
+    // 1, y Fee la comisión que paga además del monto. Las transacciones anteriores no los llevan
+    Nonce       int64   `json:",omitempty"`
+    Fee         float64 `json:",omitempty"`
 }
 
+// Multisig describe una cuenta que requiere Threshold firmas de las claves PublicKeys,
+// comprimidas en hexadecimal y ordenadas. La dirección de la cuenta sale de esta descripción.
+type Multisig struct {
+    Threshold   int
+    PublicKeys  []string
+}
+
+// User es una cuenta tal como la conoce el nodo: la clave privada solo existe en la billetera
+// del cliente.
 type User struct {
-    PrivateKey         *bip32.Key
     PublicKey          *bip32.Key
     Address            string
     Balance            float64
+    Nonce              int64
+}
+
+type Account struct {
+    Address     string
+    Balance     float64
+    Nonce       int64
+}
+
+type Snapshot struct {
+    Height      int64
+    BlockHash   string
+    StateHash   string
+    Accounts    []Account
 }
\ No newline at end of file
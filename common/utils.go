@@ -10,6 +10,8 @@ import (
     "encoding/hex"
     "strconv"
     "github.com/joho/godotenv"
+    "github.com/decred/dcrd/dcrec/secp256k1/v4"
+    "github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
 )
 
 const SeedNodesEnvVar = "LIBP2P_SEED_NODES"
@@ -53,11 +55,76 @@ func ChooseRandomNodeFromEnv(excludeNodeID string) (string, error) {
 
 func GenerateTransactionHash(transaction Transaction) string {
     // Concatenar los campos de la transacción para formar una cadena única
-    data := transaction.Sender + transaction.Recipient + fmt.Sprintf("%f", transaction.Ammount) + transaction.Signature + strconv.FormatInt(transaction.TimeStamp, 10)
+    // La clave pública se agrega al final para que las transacciones sin firmar conserven su hash
+    data := transaction.Sender + transaction.Recipient + fmt.Sprintf("%f", transaction.Ammount) + transaction.Signature + strconv.FormatInt(transaction.TimeStamp, 10) + transaction.PublicKey
+    // Lo mismo con la descripción y las firmas de las multifirma
+    if transaction.Multisig != nil {
+        data += transaction.Multisig.String() + strings.Join(transaction.Signatures, ",")
+    }
+    data += sequenceData(transaction)
 
     // Calcular el hash SHA-256 de la cadena
     hash := sha256.Sum256([]byte(data))
 
     // Convertir el hash a una cadena hexadecimal
     return hex.EncodeToString(hash[:])
+}
+
+// TransactionSigningHash es el hash que firma el remitente. Cubre los mismos campos que
+// GenerateTransactionHash salvo la firma.
+func TransactionSigningHash(transaction Transaction) []byte {
+    data := transaction.Sender + transaction.Recipient + fmt.Sprintf("%f", transaction.Ammount) + strconv.FormatInt(transaction.TimeStamp, 10) + transaction.PublicKey
+    if transaction.Multisig != nil {
+        data += transaction.Multisig.String()
+    }
+    data += sequenceData(transaction)
+    hash := sha256.Sum256([]byte(data))
+    return hash[:]
+}
+
+// sequenceData agrega el nonce y la comisión a los hashes solo si la transacción los lleva,
+// para que las transacciones anteriores conserven su hash.
+func sequenceData(transaction Transaction) string {
+    if transaction.Nonce == 0 && transaction.Fee == 0 {
+        return ""
+    }
+    return fmt.Sprintf("#%d#%f", transaction.Nonce, transaction.Fee)
+}
+
+// VerifyTransactionSignature comprueba que la clave pública de la transacción corresponda al
+// remitente y que la firma, en DER hexadecimal, sea de esa clave. Las de cuentas multifirma se
+// comprueban con VerifyMultisig.
+func VerifyTransactionSignature(transaction Transaction) error {
+    if transaction.Multisig != nil {
+        return VerifyMultisig(transaction)
+    }
+    if transaction.PublicKey == "" || transaction.Signature == "" {
+        return fmt.Errorf("la transacción no está firmada")
+    }
+
+    keyBytes, err := hex.DecodeString(transaction.PublicKey)
+    if err != nil {
+        return fmt.Errorf("clave pública no hexadecimal")
+    }
+    publicKey, err := secp256k1.ParsePubKey(keyBytes)
+    if err != nil {
+        return fmt.Errorf("clave pública inválida: %v", err)
+    }
+    if !SameAddress(DeriveAddress(keyBytes), transaction.Sender) {
+        return fmt.Errorf("la clave pública no corresponde al remitente %s", transaction.Sender)
+    }
+
+    signatureBytes, err := hex.DecodeString(transaction.Signature)
+    if err != nil {
+        return fmt.Errorf("firma no hexadecimal")
+    }
+    signature, err := ecdsa.ParseDERSignature(signatureBytes)
+    if err != nil {
+        return fmt.Errorf("firma mal formada: %v", err)
+    }
+    if !signature.Verify(TransactionSigningHash(transaction), publicKey) {
+        return fmt.Errorf("firma inválida")
+    }
+
+    return nil
 }
\ No newline at end of file
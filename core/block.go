@@ -8,37 +8,77 @@ import (
     "encoding/json"
     "github.com/syndtr/goleveldb/leveldb"
     "blockchain/common"
+    "blockchain/database"
 )
 
 func CalculateHash(block common.Block) string {
+    // Con raíz de transacciones la cabecera se puede verificar sin el cuerpo del bloque
+    if block.Header.TxRoot != "" {
+        return HeaderHash(block.Header)
+    }
+
     data := fmt.Sprintf("%d%d%s%d", block.Header.Index, block.Header.TimeStamp, block.Header.PrevBlock, block.Header.Nonce)
+    // El compromiso de estado solo forma parte del hash cuando existe, así los bloques antiguos conservan su hash
+    if block.Header.StateHash != "" {
+        data += block.Header.StateHash
+    }
+    // La firma siempre se formateó con %d, que fmt escribe como %!d(string=...); se conserva
+    // ese texto para no cambiar el hash de los bloques antiguos
     for _, transaction := range block.Transactions {
-        data += fmt.Sprintf("%d%s%s%f%d", transaction.Index, transaction.Sender, transaction.Recipient, transaction.Ammount, transaction.Signature)
+        data += fmt.Sprintf("%d%s%s%f%%!d(string=%s)", transaction.Index, transaction.Sender, transaction.Recipient, transaction.Ammount, transaction.Signature)
     }
     h := sha256.New()
     h.Write([]byte(data))
     return hex.EncodeToString(h.Sum(nil))
 }
 
+// HeaderHash calcula el hash de una cabecera que incluye la raíz de sus transacciones.
+func HeaderHash(header common.Header) string {
+    data := fmt.Sprintf("%d%d%s%d%s%s", header.Index, header.TimeStamp, header.PrevBlock, header.Nonce, header.StateHash, header.TxRoot)
+    h := sha256.New()
+    h.Write([]byte(data))
+    return hex.EncodeToString(h.Sum(nil))
+}
+
+// TransactionsRoot resume los hashes de las transacciones de un bloque en un único hash.
+func TransactionsRoot(transactions []common.Transaction) string {
+    h := sha256.New()
+    for _, transaction := range transactions {
+        h.Write([]byte(transaction.Hash))
+    }
+    return hex.EncodeToString(h.Sum(nil))
+}
+
+// SealBlock recalcula la raíz de transacciones y el hash del bloque. Se debe llamar cada vez
+// que cambian sus transacciones o su cabecera.
+func SealBlock(block *common.Block) {
+    block.Header.TxRoot = TransactionsRoot(block.Transactions)
+    block.Header.Hash = CalculateHash(*block)
+}
+
 func GenerateBlock(index int64, PrevBlock string, transactions []common.Transaction, nonce int64) common.Block {
-    header := common.Header{index, PrevBlock, "", time.Now().Unix(), nonce}
-    block := common.Block{header, transactions}
-    block.Header.Hash = CalculateHash(block)
+    header := common.Header{Index: index, PrevBlock: PrevBlock, TimeStamp: time.Now().Unix(), Nonce: nonce}
+    block := common.Block{Header: header, Transactions: transactions}
+    SealBlock(&block)
     return block
 }
 
-func CreateGenesisBlock() (common.Block, float64) {
+// GenesisSupply es la cantidad total emitida en el bloque génesis.
+const GenesisSupply = 5 * 1000000.0
+
+// CreateGenesisBlock crea el bloque génesis acreditando toda la emisión inicial a recipient.
+func CreateGenesisBlock(recipient string) (common.Block, float64) {
     var transactions []common.Transaction
     var totalAmmount float64 = 0
 
     // Crear transacciones iniciales para el bloque génesis
     for i := 0; i < 5; i++ {
-        ammount := 1000000.0 // Cantidad inicial de moneda en la red
+        ammount := GenesisSupply / 5 // Cantidad inicial de moneda en la red
         totalAmmount += ammount
         genesisTransaction := common.Transaction{
             Index:     int64(i),
-            Sender:    "0",
-            Recipient: "OSCURT",
+            Sender:    GenesisSender,
+            Recipient: recipient,
             Ammount:   ammount,
             Signature: "OSCURT",
             TimeStamp: time.Now().Unix(),
@@ -65,26 +105,85 @@ func CreateGenesisBlock() (common.Block, float64) {
     }
 
     // Calcular el hash del bloque génesis
-    genesisBlock.Header.Hash = CalculateHash(genesisBlock)
+    SealBlock(&genesisBlock)
 
     return genesisBlock, totalAmmount
 }
 
 
+// SaveBlock guarda el bloque bajo su altura y actualiza los índices de hash de bloque y de transacciones.
 func SaveBlock(db *leveldb.DB, block common.Block) error {
+    batch := new(leveldb.Batch)
+    if err := putBlock(db, batch, block); err != nil {
+        return err
+    }
+    return db.Write(batch, nil)
+}
+
+// SaveBlocks guarda bloques consecutivos junto con el estado tras el último en una sola
+// escritura, así que la lista USER nunca queda adelantada ni atrasada respecto de los bloques.
+// Si los bloques reemplazan a los de otra rama se descartan también las instantáneas de esa rama.
+func SaveBlocks(db *leveldb.DB, blocks []common.Block, state State) error {
+    if len(blocks) == 0 {
+        return nil
+    }
+
+    batch := new(leveldb.Batch)
+    if err := deleteSnapshotsFrom(db, batch, blocks[0].Index); err != nil {
+        return err
+    }
+    for _, block := range blocks {
+        if err := putBlock(db, batch, block); err != nil {
+            return err
+        }
+    }
+    if err := putState(db, batch, state); err != nil {
+        return err
+    }
+
+    return db.Write(batch, nil)
+}
+
+// putBlock agrega al lote el bloque y sus índices.
+func putBlock(db *leveldb.DB, batch *leveldb.Batch, block common.Block) error {
     blockData, err := json.Marshal(block)
     if err != nil {
         return err
     }
-    err = db.Put([]byte(fmt.Sprintf("%d", block.Index)), blockData, nil)
-    if err != nil {
+
+    // Si el bloque ya existía con otro hash se retiran sus entradas antiguas; las transacciones
+    // que también estén en el bloque nuevo se vuelven a indexar a continuación
+    previous, err := LoadBlock(db, block.Index)
+    if err != nil && err != leveldb.ErrNotFound {
         return err
     }
+    if previous != nil && previous.Hash != block.Hash {
+        batch.Delete(database.BlockHashKey(previous.Hash))
+        for _, transaction := range previous.Transactions {
+            batch.Delete(database.TxIndexKey(transaction.Hash))
+        }
+    }
+
+    height := database.BlockKey(block.Index)
+    batch.Put(height, blockData)
+    batch.Put(database.BlockHashKey(block.Hash), height)
+    for _, transaction := range block.Transactions {
+        batch.Put(database.TxIndexKey(transaction.Hash), height)
+    }
+
     return nil
 }
 
 func LoadBlock(db *leveldb.DB, index int64) (*common.Block, error) {
-    blockData, err := db.Get([]byte(fmt.Sprintf("%d", index)), nil)
+    pruned, err := PrunedHeight(db)
+    if err != nil {
+        return nil, err
+    }
+    if index < pruned {
+        return nil, ErrPruned
+    }
+
+    blockData, err := db.Get(database.BlockKey(index), nil)
     if err != nil {
         return nil, err
     }
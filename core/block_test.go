@@ -0,0 +1,121 @@
+package core
+
+import (
+    "path/filepath"
+    "testing"
+    "github.com/syndtr/goleveldb/leveldb"
+    "blockchain/common"
+    "blockchain/database"
+)
+
+func TestSaveBlockReplacesIndexes(t *testing.T) {
+    founder := testAccount(t, 0)
+    recipient := testAccount(t, 1)
+    genesis, validator := testChain(t, founder.Address)
+
+    kept := signedTransaction(t, founder, recipient.Address, 1, 1)
+    dropped := signedTransaction(t, founder, recipient.Address, 2, 2)
+    original := nextBlock(genesis, validator.State(), kept, dropped)
+    replacement := nextBlock(genesis, validator.State(), kept)
+
+    db, err := leveldb.OpenFile(filepath.Join(t.TempDir(), "chain"), nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer db.Close()
+
+    for _, block := range []common.Block{genesis, original, replacement} {
+        if err := SaveBlock(db, block); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    tests := []struct {
+        name   string
+        key    []byte
+        exists bool
+    }{
+        {"hash del bloque nuevo", database.BlockHashKey(replacement.Hash), true},
+        {"hash del bloque reemplazado", database.BlockHashKey(original.Hash), false},
+        {"transacción de los dos", database.TxIndexKey(kept.Hash), true},
+        {"transacción solo del reemplazado", database.TxIndexKey(dropped.Hash), false},
+    }
+    for _, test := range tests {
+        if _, err := db.Get(test.key, nil); (err == nil) != test.exists {
+            t.Errorf("%s: se esperaba que existiera: %v, error: %v", test.name, test.exists, err)
+        }
+    }
+}
+
+func TestSaveBlocksReplacesBranch(t *testing.T) {
+    founder := testAccount(t, 0)
+    first := testAccount(t, 1)
+    second := testAccount(t, 2)
+    genesis, validator := testChain(t, founder.Address)
+
+    db, err := leveldb.OpenFile(filepath.Join(t.TempDir(), "chain"), nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer db.Close()
+
+    // Rama local de dos bloques hacia first, con una instantánea en su cabeza
+    local := NewChainValidator(&genesis, validator.State().Copy())
+    blocks := []common.Block{genesis}
+    for nonce := int64(1); nonce <= 2; nonce++ {
+        block := nextBlock(blocks[len(blocks)-1], local.State(), signedTransaction(t, founder, first.Address, 1, nonce))
+        if err := local.Add(block); err != nil {
+            t.Fatal(err)
+        }
+        blocks = append(blocks, block)
+    }
+    if err := SaveBlocks(db, blocks, local.State()); err != nil {
+        t.Fatal(err)
+    }
+    if err := SaveSnapshot(db, snapshotFromState(2, blocks[2].Hash, local.State())); err != nil {
+        t.Fatal(err)
+    }
+
+    // Rama más larga desde el génesis hacia second
+    other := NewChainValidator(&genesis, validator.State().Copy())
+    branch := []common.Block{genesis}
+    for nonce := int64(1); nonce <= 3; nonce++ {
+        block := nextBlock(branch[len(branch)-1], other.State(), signedTransaction(t, founder, second.Address, 1, nonce))
+        if err := other.Add(block); err != nil {
+            t.Fatal(err)
+        }
+        branch = append(branch, block)
+    }
+    if err := SaveBlocks(db, branch[1:], other.State()); err != nil {
+        t.Fatal(err)
+    }
+
+    for height, block := range branch {
+        if stored, err := LoadBlock(db, int64(height)); err != nil || stored.Hash != block.Hash {
+            t.Errorf("el bloque %d no es el de la rama nueva", height)
+        }
+    }
+    if _, err := db.Get(database.TxIndexKey(blocks[1].Transactions[0].Hash), nil); err != leveldb.ErrNotFound {
+        t.Errorf("sigue indexada una transacción de la rama reemplazada")
+    }
+    if _, err := LatestSnapshot(db, 10); err != leveldb.ErrNotFound {
+        t.Errorf("se conservó la instantánea de la rama reemplazada: %v", err)
+    }
+
+    state, err := StateAt(db, 3)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if StateHash(state) != StateHash(other.State()) {
+        t.Errorf("el estado de la cadena no es el de la rama nueva")
+    }
+    users, err := LoadUsers(db)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, user := range users {
+        if expected := other.State()[user.Address]; expected == nil && user.Balance != 0 || expected != nil && user.Balance != expected.Balance {
+            t.Errorf("saldo de %s en la lista de usuarios: %f", user.Address, user.Balance)
+        }
+    }
+}
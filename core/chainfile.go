@@ -0,0 +1,232 @@
+package core
+
+import (
+    "bufio"
+    "bytes"
+    "compress/gzip"
+    "encoding/binary"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "os"
+    "github.com/syndtr/goleveldb/leveldb"
+    "blockchain/common"
+    "blockchain/database"
+)
+
+// Formato del archivo de cadena: la cabecera ChainFileMagic seguida de un registro por bloque,
+// en orden de altura. Cada registro es la longitud del bloque en JSON (uint32 big endian)
+// seguida del propio JSON. El archivo completo puede ir comprimido con gzip.
+const ChainFileMagic = "CHAINBLK1"
+
+// MaxChainRecordSize limita el tamaño de un bloque dentro del archivo para no reservar memoria sin control.
+const MaxChainRecordSize = 32 << 20
+
+// ImportResult resume una importación de cadena.
+type ImportResult struct {
+    Imported int64
+    Skipped  int64
+    Head     int64
+}
+
+// ExportChain escribe todos los bloques de la base de datos en el archivo indicado, por orden de altura.
+func ExportChain(dbPath, filePath string, compress bool) (int64, error) {
+    db, err := leveldb.OpenFile(dbPath, nil)
+    if err != nil {
+        return 0, fmt.Errorf("error al abrir la base de datos: %v", err)
+    }
+    defer db.Close()
+
+    file, err := os.Create(filePath)
+    if err != nil {
+        return 0, fmt.Errorf("error al crear el archivo de exportación: %v", err)
+    }
+    defer file.Close()
+
+    count, err := WriteChain(db, file, compress)
+    if err != nil {
+        return count, err
+    }
+
+    return count, file.Sync()
+}
+
+// WriteChain escribe los bloques de db en w con el formato de archivo de cadena.
+func WriteChain(db *leveldb.DB, w io.Writer, compress bool) (int64, error) {
+    head, err := database.LastBlockIndex(db)
+    if err != nil {
+        return 0, err
+    }
+
+    var gz *gzip.Writer
+    if compress {
+        gz = gzip.NewWriter(w)
+        w = gz
+    }
+    out := bufio.NewWriter(w)
+
+    if _, err := out.WriteString(ChainFileMagic); err != nil {
+        return 0, fmt.Errorf("error al escribir la cabecera: %v", err)
+    }
+
+    var count int64
+    for height := int64(0); height <= head; height++ {
+        block, err := LoadBlock(db, height)
+        if err != nil {
+            return count, fmt.Errorf("error al cargar el bloque %d: %v", height, err)
+        }
+        if err := writeChainRecord(out, block); err != nil {
+            return count, fmt.Errorf("error al escribir el bloque %d: %v", height, err)
+        }
+        count++
+    }
+
+    if err := out.Flush(); err != nil {
+        return count, err
+    }
+    if gz != nil {
+        if err := gz.Close(); err != nil {
+            return count, err
+        }
+    }
+
+    return count, nil
+}
+
+func writeChainRecord(w io.Writer, block *common.Block) error {
+    data, err := json.Marshal(block)
+    if err != nil {
+        return err
+    }
+
+    var size [4]byte
+    binary.BigEndian.PutUint32(size[:], uint32(len(data)))
+    if _, err := w.Write(size[:]); err != nil {
+        return err
+    }
+    _, err = w.Write(data)
+    return err
+}
+
+// ImportChain carga los bloques del archivo en la base de datos, validando cada uno y
+// reconstruyendo los saldos al terminar. Si la base de datos ya contiene parte de la cadena,
+// los bloques presentes se comprueban contra el archivo y la importación continúa desde ahí.
+func ImportChain(dbPath, filePath string) (ImportResult, error) {
+    db, err := leveldb.OpenFile(dbPath, nil)
+    if err != nil {
+        return ImportResult{}, fmt.Errorf("error al abrir la base de datos: %v", err)
+    }
+    defer db.Close()
+
+    file, err := os.Open(filePath)
+    if err != nil {
+        return ImportResult{}, fmt.Errorf("error al abrir el archivo de importación: %v", err)
+    }
+    defer file.Close()
+
+    return ReadChain(db, file)
+}
+
+// ReadChain importa en db los bloques leídos de r. Detecta automáticamente si r está comprimido.
+func ReadChain(db *leveldb.DB, r io.Reader) (ImportResult, error) {
+    result := ImportResult{Head: -1}
+
+    in := bufio.NewReader(r)
+    prefix, err := in.Peek(2)
+    if err != nil {
+        return result, fmt.Errorf("error al leer el archivo: %v", err)
+    }
+    if bytes.Equal(prefix, []byte{0x1f, 0x8b}) {
+        gz, err := gzip.NewReader(in)
+        if err != nil {
+            return result, fmt.Errorf("error al descomprimir el archivo: %v", err)
+        }
+        defer gz.Close()
+        in = bufio.NewReader(gz)
+    }
+
+    magic := make([]byte, len(ChainFileMagic))
+    if _, err := io.ReadFull(in, magic); err != nil || string(magic) != ChainFileMagic {
+        return result, fmt.Errorf("el archivo no tiene el formato de cadena esperado")
+    }
+
+    localHead, err := database.LastBlockIndex(db)
+    if err != nil && err != database.ErrNoBlocks {
+        return result, err
+    }
+
+    validator := NewChainValidator(nil, nil)
+    for {
+        block, err := readChainRecord(in)
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return result, fmt.Errorf("error al leer el bloque %d: %v", result.Head+1, err)
+        }
+
+        // Bloques ya presentes: solo se comprueba que la cadena local sea la misma
+        if block.Index <= localHead {
+            local, err := LoadBlock(db, block.Index)
+            if err != nil {
+                return result, fmt.Errorf("error al cargar el bloque local %d: %v", block.Index, err)
+            }
+            if local.Hash != block.Hash {
+                return result, fmt.Errorf("la cadena local diverge del archivo en la altura %d", block.Index)
+            }
+            if err := validator.Add(*local); err != nil {
+                return result, err
+            }
+            result.Skipped++
+            result.Head = block.Index
+            continue
+        }
+
+        if err := validator.Add(*block); err != nil {
+            return result, err
+        }
+        if err := SaveBlock(db, *block); err != nil {
+            return result, fmt.Errorf("error al guardar el bloque %d: %v", block.Index, err)
+        }
+
+        result.Imported++
+        result.Head = block.Index
+    }
+
+    if result.Head < localHead {
+        log.Printf("El archivo termina en la altura %d y la base de datos local llega a %d\n", result.Head, localHead)
+    }
+
+    if err := RebuildState(db); err != nil {
+        return result, fmt.Errorf("error al reconstruir el estado: %v", err)
+    }
+
+    return result, nil
+}
+
+func readChainRecord(r io.Reader) (*common.Block, error) {
+    var size [4]byte
+    if _, err := io.ReadFull(r, size[:]); err != nil {
+        if err == io.ErrUnexpectedEOF {
+            return nil, fmt.Errorf("registro truncado")
+        }
+        return nil, err
+    }
+
+    length := binary.BigEndian.Uint32(size[:])
+    if length > MaxChainRecordSize {
+        return nil, fmt.Errorf("registro demasiado grande: %d bytes", length)
+    }
+
+    data := make([]byte, length)
+    if _, err := io.ReadFull(r, data); err != nil {
+        return nil, fmt.Errorf("registro truncado")
+    }
+
+    var block common.Block
+    if err := json.Unmarshal(data, &block); err != nil {
+        return nil, err
+    }
+    return &block, nil
+}
@@ -0,0 +1,166 @@
+package core
+
+import (
+    "bytes"
+    "encoding/binary"
+    "path/filepath"
+    "strings"
+    "testing"
+    "github.com/syndtr/goleveldb/leveldb"
+    "blockchain/common"
+)
+
+// chainFile devuelve el contenido de un archivo de cadena con los bloques indicados.
+func chainFile(t *testing.T, blocks []common.Block) []byte {
+    var buf bytes.Buffer
+    buf.WriteString(ChainFileMagic)
+    for i := range blocks {
+        if err := writeChainRecord(&buf, &blocks[i]); err != nil {
+            t.Fatal(err)
+        }
+    }
+    return buf.Bytes()
+}
+
+func TestReadChain(t *testing.T) {
+    founder := testAccount(t, 0)
+    recipient := testAccount(t, 1)
+
+    // Génesis y cinco bloques con una transferencia cada uno
+    genesis, validator := testChain(t, founder.Address)
+    blocks := []common.Block{genesis}
+    for nonce := int64(1); nonce <= 5; nonce++ {
+        block := nextBlock(blocks[len(blocks)-1], validator.State(), signedTransaction(t, founder, recipient.Address, 1, nonce))
+        if err := validator.Add(block); err != nil {
+            t.Fatal(err)
+        }
+        blocks = append(blocks, block)
+    }
+    full := chainFile(t, blocks)
+
+    // El mismo archivo comprimido, escrito desde una base de datos con la cadena completa
+    source, err := leveldb.OpenFile(filepath.Join(t.TempDir(), "source"), nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, block := range blocks {
+        if err := SaveBlock(source, block); err != nil {
+            t.Fatal(err)
+        }
+    }
+    var compressed bytes.Buffer
+    if _, err := WriteChain(source, &compressed, true); err != nil {
+        t.Fatal(err)
+    }
+    source.Close()
+
+    // Un bloque cuyo contenido cambió sin que cambie su hash
+    tampered := append([]common.Block(nil), blocks...)
+    tampered[3].Transactions = append([]common.Transaction(nil), tampered[3].Transactions...)
+    tampered[3].Transactions[0].Ammount = 1000
+
+    // Un registro con una longitud válida y un contenido que no es un bloque
+    garbage := append([]byte(nil), chainFile(t, blocks[:3])...)
+    garbage = append(garbage, 0, 0, 0, 4)
+    garbage = append(garbage, "xxxx"...)
+
+    // Un registro que anuncia más bytes de los permitidos
+    oversized := append([]byte(nil), chainFile(t, blocks[:3])...)
+    var size [4]byte
+    binary.BigEndian.PutUint32(size[:], MaxChainRecordSize+1)
+    oversized = append(oversized, size[:]...)
+
+    // Otra cadena con otro génesis
+    other, _ := testChain(t, recipient.Address)
+
+    tests := []struct {
+        name     string
+        local    []common.Block
+        file     []byte
+        head     int64
+        imported int64
+        skipped  int64
+        fails    string
+    }{
+        {"completo", nil, full, 5, 6, 0, ""},
+        {"comprimido", nil, compressed.Bytes(), 5, 6, 0, ""},
+        {"continúa un archivo parcial", blocks[:3], full, 5, 3, 3, ""},
+        {"archivo más corto que la cadena local", blocks, chainFile(t, blocks[:3]), 5, 0, 3, ""},
+        {"registro truncado", nil, full[:len(full)-10], 4, 0, 0, "truncado"},
+        {"longitud truncada", nil, append(chainFile(t, blocks[:3]), 0, 0), 2, 0, 0, "truncado"},
+        {"bloque alterado", nil, chainFile(t, tampered), 2, 0, 0, "bloque 3"},
+        {"registro ilegible", nil, garbage, 2, 0, 0, "bloque 3"},
+        {"registro demasiado grande", nil, oversized, 2, 0, 0, "demasiado grande"},
+        {"sin cabecera", nil, full[len(ChainFileMagic):], -1, 0, 0, "formato"},
+        {"otra cadena local", []common.Block{other}, full, 0, 0, 0, "diverge"},
+    }
+
+    for _, test := range tests {
+        db, err := leveldb.OpenFile(filepath.Join(t.TempDir(), "chain"), nil)
+        if err != nil {
+            t.Fatal(err)
+        }
+        for _, block := range test.local {
+            if err := SaveBlock(db, block); err != nil {
+                t.Fatal(err)
+            }
+        }
+
+        result, err := ReadChain(db, bytes.NewReader(test.file))
+        if test.fails == "" && err != nil {
+            t.Errorf("%s: %v", test.name, err)
+        }
+        if test.fails != "" && (err == nil || !strings.Contains(err.Error(), test.fails)) {
+            t.Errorf("%s: se esperaba un error con %q y se recibió %v", test.name, test.fails, err)
+        }
+
+        // Los bloques válidos anteriores al error quedan guardados y el resto no
+        for height := int64(0); height < int64(len(blocks)); height++ {
+            block, err := LoadBlock(db, height)
+            if height > test.head {
+                if err == nil && block.Hash == blocks[height].Hash {
+                    t.Errorf("%s: se guardó el bloque %d", test.name, height)
+                }
+                continue
+            }
+            if test.fails == "diverge" {
+                continue
+            }
+            if err != nil || block.Hash != blocks[height].Hash {
+                t.Errorf("%s: falta el bloque %d", test.name, height)
+            }
+        }
+
+        if test.fails == "" {
+            if result.Imported != test.imported || result.Skipped != test.skipped {
+                t.Errorf("%s: se importaron %d y se omitieron %d bloques", test.name, result.Imported, result.Skipped)
+            }
+
+            // Al terminar, el estado corresponde a la cadena completa
+            state, err := StateAt(db, 5)
+            if err != nil {
+                t.Errorf("%s: %v", test.name, err)
+            } else if balance := state[common.AddressKey(recipient.Address)].Balance; balance != 5 {
+                t.Errorf("%s: saldo del destinatario: se esperaba 5 y es %f", test.name, balance)
+            }
+        }
+        db.Close()
+    }
+
+    // Una importación cortada por un archivo truncado se retoma con el archivo completo
+    db, err := leveldb.OpenFile(filepath.Join(t.TempDir(), "chain"), nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer db.Close()
+    if _, err := ReadChain(db, bytes.NewReader(full[:len(full)-10])); err == nil {
+        t.Fatal("se aceptó un archivo truncado")
+    }
+    result, err := ReadChain(db, bytes.NewReader(full))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if result.Skipped != 5 || result.Imported != 1 || result.Head != 5 {
+        t.Fatalf("al retomar se omitieron %d, se importaron %d y la cabeza es %d", result.Skipped, result.Imported, result.Head)
+    }
+}
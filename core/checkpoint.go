@@ -0,0 +1,52 @@
+package core
+
+import (
+    "encoding/json"
+    "fmt"
+    "os"
+)
+
+// Checkpoints asocia alturas con el hash de bloque que la cadena debe tener en ellas.
+// Durante la sincronización se rechaza cualquier cadena de cabeceras que no pase por ellos.
+type Checkpoints map[int64]string
+
+// LegacyHeight es la altura hasta la que se aceptan transacciones sin firma ni nonce, las
+// anteriores a ellos. Por encima solo las transacciones de emisión del génesis van sin firmar.
+// Los nodos la fijan en el último de sus puntos de control, que son los que garantizan que
+// esos bloques antiguos no se pueden reemplazar.
+var LegacyHeight int64
+
+// LoadCheckpoints lee los puntos de control de un archivo JSON de la forma {"altura": "hash"}.
+func LoadCheckpoints(path string) (Checkpoints, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, fmt.Errorf("error al leer los puntos de control: %v", err)
+    }
+
+    var checkpoints Checkpoints
+    if err := json.Unmarshal(data, &checkpoints); err != nil {
+        return nil, fmt.Errorf("archivo de puntos de control inválido: %v", err)
+    }
+    return checkpoints, nil
+}
+
+// Check comprueba una cabecera contra el punto de control de su altura, si lo hay.
+func (checkpoints Checkpoints) Check(index int64, hash string) error {
+    expected, ok := checkpoints[index]
+    if ok && expected != hash {
+        return fmt.Errorf("el bloque %d no coincide con el punto de control: se esperaba %s y se recibió %s", index, expected, hash)
+    }
+    return nil
+}
+
+
+// Last devuelve la altura del último punto de control, o 0 si no hay ninguno.
+func (checkpoints Checkpoints) Last() int64 {
+    var last int64
+    for height := range checkpoints {
+        if height > last {
+            last = height
+        }
+    }
+    return last
+}
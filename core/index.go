@@ -0,0 +1,118 @@
+package core
+
+import (
+    "fmt"
+    "github.com/syndtr/goleveldb/leveldb"
+    "github.com/syndtr/goleveldb/leveldb/util"
+    "blockchain/common"
+    "blockchain/database"
+)
+
+// LoadBlockByHash busca un bloque a través del índice de hashes.
+func LoadBlockByHash(db *leveldb.DB, hash string) (*common.Block, error) {
+    value, err := db.Get(database.BlockHashKey(hash), nil)
+    if err != nil {
+        return nil, err
+    }
+
+    height, err := database.ParseHeight(value)
+    if err != nil {
+        return nil, fmt.Errorf("índice de bloque corrupto para %s: %v", hash, err)
+    }
+
+    return LoadBlock(db, height)
+}
+
+// FindTransaction busca una transacción a través del índice y devuelve también la altura de su bloque.
+func FindTransaction(db *leveldb.DB, hash string) (*common.Transaction, int64, error) {
+    value, err := db.Get(database.TxIndexKey(hash), nil)
+    if err != nil {
+        return nil, -1, err
+    }
+
+    height, err := database.ParseHeight(value)
+    if err != nil {
+        return nil, -1, fmt.Errorf("índice de transacción corrupto para %s: %v", hash, err)
+    }
+
+    block, err := LoadBlock(db, height)
+    if err == ErrPruned {
+        return nil, height, ErrPruned
+    }
+    if err != nil {
+        return nil, -1, fmt.Errorf("error al cargar el bloque %d: %v", height, err)
+    }
+
+    for _, transaction := range block.Transactions {
+        if transaction.Hash == hash {
+            return &transaction, height, nil
+        }
+    }
+
+    return nil, -1, fmt.Errorf("el índice apunta al bloque %d pero la transacción %s no está en él", height, hash)
+}
+
+// Reindex borra los índices secundarios y los reconstruye recorriendo todos los bloques.
+// En un nodo podado las entradas de transacciones de los bloques podados no se pueden
+// reconstruir, así que ese índice se conserva y solo se completa con los bloques retenidos.
+// Devuelve la cantidad de bloques y transacciones indexados.
+func Reindex(db *leveldb.DB) (int64, int64, error) {
+    pruned, err := PrunedHeight(db)
+    if err != nil {
+        return 0, 0, err
+    }
+
+    prefixes := []string{database.BlockHashPrefix}
+    if pruned == 0 {
+        prefixes = append(prefixes, database.TxIndexPrefix)
+    }
+
+    batch := new(leveldb.Batch)
+    for _, prefix := range prefixes {
+        iter := db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+        for iter.Next() {
+            batch.Delete(append([]byte{}, iter.Key()...))
+        }
+        iter.Release()
+        if err := iter.Error(); err != nil {
+            return 0, 0, fmt.Errorf("error al recorrer el índice %s: %v", prefix, err)
+        }
+    }
+
+    head, err := database.LastBlockIndex(db)
+    if err != nil && err != database.ErrNoBlocks {
+        return 0, 0, err
+    }
+
+    var blocks, transactions int64
+    for height := int64(0); height <= head; height++ {
+        key := database.BlockKey(height)
+
+        if height < pruned {
+            header, err := LoadHeader(db, height)
+            if err == nil {
+                batch.Put(database.BlockHashKey(header.Hash), key)
+                blocks++
+            }
+            continue
+        }
+
+        block, err := LoadBlock(db, height)
+        if err == leveldb.ErrNotFound {
+            // Nodo iniciado desde una instantánea: no tiene los bloques anteriores
+            continue
+        }
+        if err != nil {
+            return blocks, transactions, fmt.Errorf("error al cargar el bloque %d: %v", height, err)
+        }
+
+        batch.Put(database.BlockHashKey(block.Hash), key)
+        for _, transaction := range block.Transactions {
+            batch.Put(database.TxIndexKey(transaction.Hash), key)
+            transactions++
+        }
+        blocks++
+    }
+
+    return blocks, transactions, db.Write(batch, nil)
+}
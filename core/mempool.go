@@ -0,0 +1,114 @@
+package core
+
+import (
+    "fmt"
+    "sort"
+    "sync"
+    "time"
+    "blockchain/common"
+)
+
+// MaxMempoolSize es la cantidad máxima de transacciones pendientes que guarda un nodo.
+const MaxMempoolSize = 10000
+
+// MaxMempoolAge es el tiempo que una transacción espera en el mempool el bloque que la incluye.
+const MaxMempoolAge = time.Hour
+
+// Mempool guarda las transacciones válidas recibidas por gossip que todavía no están en un
+// bloque. Es solo un búfer de retransmisión: ningún nodo produce bloques a partir de él, porque
+// el nodo que recibe una transacción de un cliente la incluye enseguida en un bloque propio.
+// Las transacciones salen al llegar ese bloque o tras MaxMempoolAge. Es seguro usarlo desde
+// varias goroutines.
+type Mempool struct {
+    mu           sync.Mutex
+    transactions map[string]mempoolEntry
+}
+
+type mempoolEntry struct {
+    transaction common.Transaction
+    added       time.Time
+}
+
+func NewMempool() *Mempool {
+    return &Mempool{transactions: make(map[string]mempoolEntry)}
+}
+
+// Add valida la transacción y la agrega. Devuelve false si ya estaba pendiente.
+func (m *Mempool) Add(transaction common.Transaction) (bool, error) {
+    if err := ValidateTransaction(transaction, Pending); err != nil {
+        return false, err
+    }
+
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    if _, ok := m.transactions[transaction.Hash]; ok {
+        return false, nil
+    }
+    if len(m.transactions) >= MaxMempoolSize {
+        m.expire(time.Now())
+    }
+    if len(m.transactions) >= MaxMempoolSize {
+        return false, fmt.Errorf("el mempool está lleno")
+    }
+
+    m.transactions[transaction.Hash] = mempoolEntry{transaction: transaction, added: time.Now()}
+    return true, nil
+}
+
+// Expire retira las transacciones que llevan más de MaxMempoolAge esperando.
+func (m *Mempool) Expire() {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    m.expire(time.Now())
+}
+
+func (m *Mempool) expire(now time.Time) {
+    for hash, entry := range m.transactions {
+        if now.Sub(entry.added) > MaxMempoolAge {
+            delete(m.transactions, hash)
+        }
+    }
+}
+
+// Has indica si la transacción está pendiente.
+func (m *Mempool) Has(hash string) bool {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    _, ok := m.transactions[hash]
+    return ok
+}
+
+// Get devuelve la transacción pendiente con el hash indicado.
+func (m *Mempool) Get(hash string) (common.Transaction, bool) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    entry, ok := m.transactions[hash]
+    return entry.transaction, ok
+}
+
+// RemoveBlock retira del mempool las transacciones incluidas en el bloque.
+func (m *Mempool) RemoveBlock(block common.Block) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    for _, transaction := range block.Transactions {
+        delete(m.transactions, transaction.Hash)
+    }
+}
+
+// Pending devuelve las transacciones pendientes ordenadas por fecha.
+func (m *Mempool) Pending() []common.Transaction {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    pending := make([]common.Transaction, 0, len(m.transactions))
+    for _, entry := range m.transactions {
+        pending = append(pending, entry.transaction)
+    }
+    sort.Slice(pending, func(i, j int) bool { return pending[i].TimeStamp < pending[j].TimeStamp })
+    return pending
+}
@@ -0,0 +1,41 @@
+package core
+
+import (
+    "testing"
+    "time"
+    "blockchain/common"
+)
+
+func TestMempoolExpire(t *testing.T) {
+    founder := testAccount(t, 0)
+    recipient := testAccount(t, 1)
+    genesis, validator := testChain(t, founder.Address)
+
+    confirmed := signedTransaction(t, founder, recipient.Address, 1, 1)
+    waiting := signedTransaction(t, founder, recipient.Address, 2, 2)
+    block := nextBlock(genesis, validator.State(), confirmed)
+
+    m := NewMempool()
+    for _, transaction := range []common.Transaction{confirmed, waiting} {
+        if added, err := m.Add(transaction); err != nil || !added {
+            t.Fatalf("no se agregó la transacción %s: %v", transaction.Hash, err)
+        }
+    }
+    if added, _ := m.Add(waiting); added {
+        t.Fatal("se agregó dos veces la misma transacción")
+    }
+
+    // El bloque que la incluye la retira; la otra espera hasta MaxMempoolAge
+    m.RemoveBlock(block)
+    if m.Has(confirmed.Hash) || !m.Has(waiting.Hash) {
+        t.Fatal("el bloque no retiró solo su transacción")
+    }
+    m.expire(time.Now().Add(MaxMempoolAge / 2))
+    if !m.Has(waiting.Hash) {
+        t.Fatal("se retiró una transacción antes de MaxMempoolAge")
+    }
+    m.expire(time.Now().Add(MaxMempoolAge + time.Minute))
+    if m.Has(waiting.Hash) || len(m.Pending()) != 0 {
+        t.Fatal("una transacción sigue en el mempool tras MaxMempoolAge")
+    }
+}
@@ -0,0 +1,151 @@
+package core
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "sync"
+    "time"
+    "github.com/syndtr/goleveldb/leveldb"
+    "blockchain/common"
+    "blockchain/database"
+)
+
+// PruneInterval es el tiempo entre dos pasadas del podador en segundo plano.
+const PruneInterval = time.Minute
+
+// ErrPruned indica que el cuerpo del bloque pedido ya fue eliminado por el podador.
+var ErrPruned = errors.New("el bloque fue podado y este nodo solo conserva su cabecera")
+
+// PrunedHeight devuelve la altura del primer bloque cuyo cuerpo se conserva. Es 0 en un nodo sin podar.
+func PrunedHeight(db *leveldb.DB) (int64, error) {
+    value, err := db.Get([]byte(database.PrunedKey), nil)
+    if err == leveldb.ErrNotFound {
+        return 0, nil
+    }
+    if err != nil {
+        return 0, err
+    }
+    return database.ParseHeight(value)
+}
+
+// FirstFullBlock devuelve la altura del primer bloque completo que conserva la base de datos:
+// la altura de poda en un nodo podado, la de la instantánea en un nodo iniciado desde una, o 0.
+func FirstFullBlock(db *leveldb.DB) (int64, error) {
+    pruned, err := PrunedHeight(db)
+    if err != nil || pruned > 0 {
+        return pruned, err
+    }
+
+    if _, err := LoadHeader(db, 0); err != leveldb.ErrNotFound {
+        return 0, err
+    }
+
+    snapshot, err := EarliestSnapshot(db)
+    if err != nil {
+        return 0, err
+    }
+    return snapshot.Height, nil
+}
+
+// LoadHeader devuelve la cabecera de un bloque, esté podado o no.
+func LoadHeader(db *leveldb.DB, height int64) (*common.Header, error) {
+    data, err := db.Get(database.BlockKey(height), nil)
+    if err != nil {
+        return nil, err
+    }
+    var block common.Block
+    if err := json.Unmarshal(data, &block); err != nil {
+        return nil, err
+    }
+    return &block.Header, nil
+}
+
+// PruneBlocks elimina los cuerpos de los bloques anteriores a los últimos keep, conservando
+// sus cabeceras y los índices. Antes de podar guarda una instantánea en la nueva altura de
+// poda para que el estado se pueda seguir calculando sin los bloques eliminados. Esa altura
+// queda siempre por debajo de la cabeza: una instantánea de la cabeza no reflejaría las
+// transacciones que se le agregaran después.
+// Devuelve la cantidad de bloques podados en esta pasada.
+func PruneBlocks(db *leveldb.DB, keep int64) (int64, error) {
+    if keep < 1 {
+        return 0, fmt.Errorf("se debe conservar al menos un bloque")
+    }
+
+    head, err := database.LastBlockIndex(db)
+    if err != nil {
+        return 0, err
+    }
+
+    from, err := PrunedHeight(db)
+    if err != nil {
+        return 0, err
+    }
+
+    target := head - keep
+    if target <= from {
+        return 0, nil
+    }
+
+    // Instantánea en la altura de poda: el estado se reproduce a partir de ella
+    anchor, err := LoadBlock(db, target)
+    if err != nil {
+        return 0, fmt.Errorf("error al cargar el bloque %d: %v", target, err)
+    }
+    state, err := StateAt(db, target)
+    if err != nil {
+        return 0, err
+    }
+    if err := SaveSnapshot(db, snapshotFromState(target, anchor.Hash, state)); err != nil {
+        return 0, fmt.Errorf("error al guardar la instantánea: %v", err)
+    }
+
+    batch := new(leveldb.Batch)
+    var pruned int64
+    for height := from; height < target; height++ {
+        header, err := LoadHeader(db, height)
+        if err == leveldb.ErrNotFound {
+            // Nodo iniciado desde una instantánea: no hay nada que podar por debajo de ella
+            continue
+        }
+        if err != nil {
+            return 0, fmt.Errorf("error al cargar el bloque %d: %v", height, err)
+        }
+
+        data, err := json.Marshal(common.Block{Header: *header})
+        if err != nil {
+            return 0, err
+        }
+        batch.Put(database.BlockKey(height), data)
+        pruned++
+    }
+    batch.Put([]byte(database.PrunedKey), database.BlockKey(target))
+
+    return pruned, db.Write(batch, nil)
+}
+
+// RunPruner poda periódicamente la base de datos del nodo hasta que se cancela ctx. Cada poda
+// toma lock, el mismo que protege las escrituras en la cadena.
+func RunPruner(ctx context.Context, db *leveldb.DB, keep int64, lock sync.Locker) {
+    ticker := time.NewTicker(PruneInterval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+
+        lock.Lock()
+        pruned, err := PruneBlocks(db, keep)
+        lock.Unlock()
+        if err != nil {
+            log.Printf("Podador: error al podar: %v\n", err)
+        } else if pruned > 0 {
+            log.Printf("Podador: %d bloques podados\n", pruned)
+        }
+    }
+}
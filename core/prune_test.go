@@ -0,0 +1,70 @@
+package core
+
+import (
+    "path/filepath"
+    "testing"
+    "github.com/syndtr/goleveldb/leveldb"
+    "blockchain/common"
+)
+
+func TestPruneKeepsHead(t *testing.T) {
+    founder := testAccount(t, 0)
+    recipient := testAccount(t, 1)
+
+    tests := []struct {
+        name   string
+        keep   int64
+        pruned int64
+    }{
+        {"un bloque", 1, 4},
+        {"dos bloques", 2, 3},
+        {"más bloques que la cadena", 10, 0},
+    }
+
+    for _, test := range tests {
+        // Génesis y cinco bloques con una transferencia cada uno
+        genesis, validator := testChain(t, founder.Address)
+        blocks := []common.Block{genesis}
+        for nonce := int64(1); nonce <= 5; nonce++ {
+            block := nextBlock(blocks[len(blocks)-1], validator.State(), signedTransaction(t, founder, recipient.Address, 1, nonce))
+            if err := validator.Add(block); err != nil {
+                t.Fatal(err)
+            }
+            blocks = append(blocks, block)
+        }
+
+        db, err := leveldb.OpenFile(filepath.Join(t.TempDir(), "chain"), nil)
+        if err != nil {
+            t.Fatal(err)
+        }
+        for _, block := range blocks {
+            if err := SaveBlock(db, block); err != nil {
+                t.Fatal(err)
+            }
+        }
+
+        if _, err := PruneBlocks(db, test.keep); err != nil {
+            t.Fatalf("%s: %v", test.name, err)
+        }
+        if pruned, err := PrunedHeight(db); err != nil || pruned != test.pruned {
+            t.Errorf("%s: altura de poda %d, se esperaba %d", test.name, pruned, test.pruned)
+        }
+
+        // Una transacción agregada a la cabeza después de podar debe seguir contando en el estado
+        head := blocks[len(blocks)-1]
+        head.Transactions = append(head.Transactions, signedTransaction(t, founder, recipient.Address, 1, 6))
+        SealBlock(&head)
+        if err := SaveBlock(db, head); err != nil {
+            t.Fatal(err)
+        }
+
+        state, err := StateAt(db, head.Index)
+        if err != nil {
+            t.Fatalf("%s: %v", test.name, err)
+        }
+        if balance := state[common.AddressKey(recipient.Address)].Balance; balance != 6 {
+            t.Errorf("%s: el estado de la cabeza da %f al destinatario, se esperaba 6", test.name, balance)
+        }
+        db.Close()
+    }
+}
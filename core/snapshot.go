@@ -0,0 +1,149 @@
+package core
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "github.com/syndtr/goleveldb/leveldb"
+    "github.com/syndtr/goleveldb/leveldb/util"
+    "blockchain/common"
+    "blockchain/database"
+)
+
+// SnapshotInterval es la cantidad de bloques entre dos instantáneas de estado.
+const SnapshotInterval = 100
+
+// StateHash calcula el hash del estado recorriendo las cuentas por orden de dirección.
+// Es el valor que compromete la cabecera del bloque siguiente.
+func StateHash(state State) string {
+    return accountsHash(state.Accounts())
+}
+
+func accountsHash(accounts []common.Account) string {
+    h := sha256.New()
+    for _, account := range accounts {
+        h.Write([]byte(fmt.Sprintf("%s%f%d;", account.Address, account.Balance, account.Nonce)))
+    }
+    return hex.EncodeToString(h.Sum(nil))
+}
+
+func snapshotFromState(height int64, blockHash string, state State) *common.Snapshot {
+    accounts := state.Accounts()
+    return &common.Snapshot{
+        Height:    height,
+        BlockHash: blockHash,
+        StateHash: accountsHash(accounts),
+        Accounts:  accounts,
+    }
+}
+
+// SaveSnapshot guarda una instantánea bajo su altura.
+func SaveSnapshot(db *leveldb.DB, snapshot *common.Snapshot) error {
+    data, err := json.Marshal(snapshot)
+    if err != nil {
+        return err
+    }
+    return db.Put(database.SnapshotKey(snapshot.Height), data, nil)
+}
+
+// deleteSnapshotsFrom agrega al lote el borrado de las instantáneas desde la altura indicada.
+func deleteSnapshotsFrom(db *leveldb.DB, batch *leveldb.Batch, height int64) error {
+    iter := db.NewIterator(&util.Range{
+        Start: database.SnapshotKey(height),
+        Limit: []byte(database.SnapshotPrefix + "~"),
+    }, nil)
+    defer iter.Release()
+
+    for iter.Next() {
+        batch.Delete(append([]byte{}, iter.Key()...))
+    }
+    return iter.Error()
+}
+
+// LatestSnapshot devuelve la instantánea más reciente cuya altura no supera maxHeight.
+// Devuelve leveldb.ErrNotFound si no hay ninguna.
+func LatestSnapshot(db *leveldb.DB, maxHeight int64) (*common.Snapshot, error) {
+    iter := db.NewIterator(&util.Range{
+        Start: []byte(database.SnapshotPrefix),
+        Limit: database.SnapshotKey(maxHeight + 1),
+    }, nil)
+    defer iter.Release()
+
+    if !iter.Last() {
+        if err := iter.Error(); err != nil {
+            return nil, err
+        }
+        return nil, leveldb.ErrNotFound
+    }
+
+    var snapshot common.Snapshot
+    if err := json.Unmarshal(iter.Value(), &snapshot); err != nil {
+        return nil, fmt.Errorf("instantánea corrupta en %s: %v", iter.Key(), err)
+    }
+    return &snapshot, nil
+}
+
+// EarliestSnapshot devuelve la instantánea más antigua guardada, o leveldb.ErrNotFound si no hay ninguna.
+func EarliestSnapshot(db *leveldb.DB) (*common.Snapshot, error) {
+    iter := db.NewIterator(util.BytesPrefix([]byte(database.SnapshotPrefix)), nil)
+    defer iter.Release()
+
+    if !iter.First() {
+        if err := iter.Error(); err != nil {
+            return nil, err
+        }
+        return nil, leveldb.ErrNotFound
+    }
+
+    var snapshot common.Snapshot
+    if err := json.Unmarshal(iter.Value(), &snapshot); err != nil {
+        return nil, fmt.Errorf("instantánea corrupta en %s: %v", iter.Key(), err)
+    }
+    return &snapshot, nil
+}
+
+// VerifySnapshot comprueba que la instantánea corresponde al bloque anchor y que el bloque
+// commit, sucesor de anchor, compromete exactamente su estado.
+func VerifySnapshot(snapshot *common.Snapshot, anchor, commit *common.Block) error {
+    if anchor.Index != snapshot.Height || anchor.Hash != snapshot.BlockHash {
+        return fmt.Errorf("la instantánea no corresponde al bloque %d", anchor.Index)
+    }
+    if err := ValidateBlockContents(*anchor); err != nil {
+        return err
+    }
+    if err := ValidateBlock(*commit, anchor); err != nil {
+        return err
+    }
+
+    if hash := accountsHash(snapshot.Accounts); hash != snapshot.StateHash {
+        return fmt.Errorf("el hash de la instantánea no coincide con sus cuentas")
+    }
+    if commit.StateHash != snapshot.StateHash {
+        return fmt.Errorf("el bloque %d no compromete el estado de la instantánea", commit.Index)
+    }
+
+    return nil
+}
+
+// CommitState calcula el compromiso de estado para un bloque nuevo que seguirá a la altura
+// parent. Cada SnapshotInterval bloques devuelve además la instantánea de esa altura para
+// que el llamador la guarde junto al bloque.
+func CommitState(db *leveldb.DB, parent int64) (string, *common.Snapshot, error) {
+    block, err := LoadBlock(db, parent)
+    if err != nil {
+        return "", nil, fmt.Errorf("error al cargar el bloque %d: %v", parent, err)
+    }
+
+    state, err := StateAt(db, parent)
+    if err != nil {
+        return "", nil, err
+    }
+
+    snapshot := snapshotFromState(parent, block.Hash, state)
+    if parent == 0 || parent%SnapshotInterval != 0 {
+        return snapshot.StateHash, nil, nil
+    }
+
+    return snapshot.StateHash, snapshot, nil
+}
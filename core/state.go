@@ -0,0 +1,222 @@
+package core
+
+import (
+    "encoding/json"
+    "fmt"
+    "sort"
+    "github.com/syndtr/goleveldb/leveldb"
+    "blockchain/common"
+    "blockchain/database"
+)
+
+// State es el estado de cuentas indexado por la forma canónica de la dirección, la de
+// common.AddressKey.
+type State map[string]*common.Account
+
+func (state State) account(address string) *common.Account {
+    key := common.AddressKey(address)
+    account, ok := state[key]
+    if !ok {
+        account = &common.Account{Address: key}
+        state[key] = account
+    }
+    return account
+}
+
+// Accounts devuelve las cuentas ordenadas por dirección.
+func (state State) Accounts() []common.Account {
+    accounts := make([]common.Account, 0, len(state))
+    for _, account := range state {
+        accounts = append(accounts, *account)
+    }
+    sort.Slice(accounts, func(i, j int) bool { return accounts[i].Address < accounts[j].Address })
+    return accounts
+}
+
+// Copy devuelve una copia del estado que se puede modificar sin cambiar el original.
+func (state State) Copy() State {
+    copied := make(State, len(state))
+    for key, account := range state {
+        account := *account
+        copied[key] = &account
+    }
+    return copied
+}
+
+// StateFromAccounts construye un estado a partir de una lista de cuentas.
+func StateFromAccounts(accounts []common.Account) State {
+    state := make(State)
+    for _, account := range accounts {
+        copied := account
+        state[account.Address] = &copied
+    }
+    return state
+}
+
+// ApplyBlock aplica las transacciones de un bloque sobre el estado. Las transacciones deben
+// llevar el nonce siguiente de su remitente, así que no se pueden repetir; la comisión se
+// descuenta del remitente y no se acredita a nadie.
+func ApplyBlock(state State, block common.Block) error {
+    for _, transaction := range block.Transactions {
+        if transaction.Sender != GenesisSender {
+            sender := state.account(transaction.Sender)
+            if err := CheckNonce(sender.Nonce, transaction, block.Index); err != nil {
+                return err
+            }
+            if sender.Balance < transaction.Ammount+transaction.Fee {
+                return fmt.Errorf("saldo insuficiente para %s en la transacción %s", transaction.Sender, transaction.Hash)
+            }
+            sender.Balance -= transaction.Ammount + transaction.Fee
+            sender.Nonce++
+        }
+        state.account(transaction.Recipient).Balance += transaction.Ammount
+    }
+    return nil
+}
+
+// CheckNonce comprueba que la transacción lleve el nonce siguiente al de su remitente, que
+// cuenta las transacciones que ya envió. height es la altura del bloque que la incluye, o
+// Pending; solo hasta LegacyHeight se aceptan transacciones sin nonce, las anteriores a él.
+func CheckNonce(current int64, transaction common.Transaction, height int64) error {
+    if transaction.Nonce == 0 && legacy(height) {
+        return nil
+    }
+    if transaction.Nonce != current+1 {
+        return fmt.Errorf("nonce inválido en la transacción %s: se esperaba %d y se recibió %d", transaction.Hash, current+1, transaction.Nonce)
+    }
+    return nil
+}
+
+// StateAt devuelve el estado tras aplicar el bloque de la altura indicada. Parte de la
+// instantánea más reciente que no supere esa altura, o del génesis si no hay ninguna.
+func StateAt(db *leveldb.DB, height int64) (State, error) {
+    state := make(State)
+    start := int64(0)
+
+    snapshot, err := LatestSnapshot(db, height)
+    if err != nil && err != leveldb.ErrNotFound {
+        return nil, err
+    }
+    if snapshot != nil {
+        state = StateFromAccounts(snapshot.Accounts)
+        start = snapshot.Height + 1
+    }
+
+    for h := start; h <= height; h++ {
+        block, err := LoadBlock(db, h)
+        if err != nil {
+            return nil, fmt.Errorf("error al cargar el bloque %d: %v", h, err)
+        }
+        if err := ApplyBlock(state, *block); err != nil {
+            return nil, fmt.Errorf("error al aplicar el bloque %d: %v", h, err)
+        }
+    }
+
+    return state, nil
+}
+
+// ComputeState devuelve el estado tras el último bloque de la base de datos.
+func ComputeState(db *leveldb.DB) (State, error) {
+    head, err := database.LastBlockIndex(db)
+    if err == database.ErrNoBlocks {
+        return make(State), nil
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    return StateAt(db, head)
+}
+
+// RebuildState recalcula los saldos a partir de los bloques y los escribe en la lista USER.
+// Los usuarios existentes conservan sus claves; las direcciones nuevas se agregan solo con su saldo.
+func RebuildState(db *leveldb.DB) error {
+    state, err := ComputeState(db)
+    if err != nil {
+        return err
+    }
+
+    return StoreState(db, state)
+}
+
+// StoreState escribe el estado en la lista USER, conservando las claves de los usuarios existentes.
+func StoreState(db *leveldb.DB, state State) error {
+    batch := new(leveldb.Batch)
+    if err := putState(db, batch, state); err != nil {
+        return err
+    }
+    return db.Write(batch, nil)
+}
+
+// putState agrega al lote la lista USER con el estado.
+func putState(db *leveldb.DB, batch *leveldb.Batch, state State) error {
+    users, err := LoadUsers(db)
+    if err != nil {
+        return fmt.Errorf("error al leer usuarios: %v", err)
+    }
+
+    known := make(map[string]bool)
+    for _, user := range users {
+        user.Balance, user.Nonce = 0, 0
+        if account, ok := state[user.Address]; ok {
+            user.Balance = account.Balance
+            user.Nonce = account.Nonce
+        }
+        known[user.Address] = true
+    }
+
+    for _, account := range state.Accounts() {
+        if !known[account.Address] {
+            users = append(users, &common.User{Address: account.Address, Balance: account.Balance, Nonce: account.Nonce})
+        }
+    }
+
+    data, err := json.Marshal(users)
+    if err != nil {
+        return err
+    }
+    batch.Put([]byte("USER"), data)
+    return nil
+}
+
+// ChainValidator valida bloques consecutivos y mantiene el estado resultante,
+// de modo que también puede comprobar el compromiso de estado de cada cabecera.
+type ChainValidator struct {
+    prev  *common.Block
+    state State
+}
+
+// NewChainValidator crea un validador que continúa a partir de prev con el estado tras prev.
+// Para validar desde el génesis prev debe ser nil y state vacío.
+func NewChainValidator(prev *common.Block, state State) *ChainValidator {
+    if state == nil {
+        state = make(State)
+    }
+    return &ChainValidator{prev: prev, state: state}
+}
+
+// Add valida el siguiente bloque de la cadena y lo aplica sobre el estado. Si el bloque no es
+// válido el estado no cambia, aunque alguna de sus transacciones se haya podido aplicar.
+func (v *ChainValidator) Add(block common.Block) error {
+    if err := ValidateBlock(block, v.prev); err != nil {
+        return err
+    }
+
+    if block.StateHash != "" && block.StateHash != StateHash(v.state) {
+        return fmt.Errorf("el bloque %d compromete un estado distinto al de la cadena", block.Index)
+    }
+
+    state := v.state.Copy()
+    if err := ApplyBlock(state, block); err != nil {
+        return fmt.Errorf("error al aplicar el bloque %d: %v", block.Index, err)
+    }
+
+    v.prev = &block
+    v.state = state
+    return nil
+}
+
+// State devuelve el estado tras el último bloque agregado.
+func (v *ChainValidator) State() State {
+    return v.state
+}
@@ -0,0 +1,122 @@
+package core
+
+import (
+    "testing"
+    "time"
+    "blockchain/common"
+)
+
+func TestNonceReplayRejected(t *testing.T) {
+    founder := testAccount(t, 0)
+    recipient := testAccount(t, 1)
+
+    // Firmada y válida salvo por el nonce: así eran las transacciones anteriores a los nonces
+    withoutNonce := common.Transaction{Sender: founder.Address, Recipient: recipient.Address, Ammount: 10, TimeStamp: time.Now().Unix()}
+    if err := founder.Sign(&withoutNonce); err != nil {
+        t.Fatal(err)
+    }
+    first := signedTransaction(t, founder, recipient.Address, 10, 1)
+
+    tests := []struct {
+        name        string
+        current     int64
+        transaction common.Transaction
+        height      int64
+        valid       bool
+    }{
+        {"siguiente", 0, first, 1, true},
+        {"siguiente pendiente", 0, first, Pending, true},
+        {"repetido", 1, first, 2, false},
+        {"adelantado", 2, first, 3, false},
+        {"sin nonce", 0, withoutNonce, 7, false},
+        {"sin nonce pendiente", 0, withoutNonce, Pending, false},
+        {"sin nonce antes del punto de control", 3, withoutNonce, 4, true},
+        {"sin nonce tras el punto de control", 3, withoutNonce, 6, false},
+    }
+
+    defer func(height int64) { LegacyHeight = height }(LegacyHeight)
+    LegacyHeight = 5
+    for _, test := range tests {
+        err := CheckNonce(test.current, test.transaction, test.height)
+        if test.valid && err != nil {
+            t.Errorf("%s: se rechazó: %v", test.name, err)
+        }
+        if !test.valid && err == nil {
+            t.Errorf("%s: se aceptó", test.name)
+        }
+    }
+
+    // Un par no puede hacer repetir una transacción firmada sin nonce incluyéndola en sus bloques
+    LegacyHeight = 0
+    genesis, validator := testChain(t, founder.Address)
+    if err := validator.Add(nextBlock(genesis, validator.State(), withoutNonce)); err == nil {
+        t.Fatal("se aceptó un bloque con una transacción sin nonce")
+    }
+    if err := validator.Add(nextBlock(genesis, validator.State(), withoutNonce, withoutNonce)); err == nil {
+        t.Fatal("se aceptó un bloque que repite una transacción sin nonce")
+    }
+
+    // Ni con nonce se puede repetir en un bloque posterior
+    block := nextBlock(genesis, validator.State(), first)
+    if err := validator.Add(block); err != nil {
+        t.Fatal(err)
+    }
+    if err := validator.Add(nextBlock(block, validator.State(), first)); err == nil {
+        t.Fatal("se aceptó un bloque que repite una transacción ya incluida")
+    }
+    if balance := validator.State()[common.AddressKey(recipient.Address)].Balance; balance != 10 {
+        t.Fatalf("saldo del destinatario: se esperaba 10 y es %f", balance)
+    }
+}
+
+func TestChainValidatorAdd(t *testing.T) {
+    founder := testAccount(t, 0)
+    recipient := testAccount(t, 1)
+    genesis, validator := testChain(t, founder.Address)
+    state := validator.State()
+
+    first := signedTransaction(t, founder, recipient.Address, 10, 1)
+    valid := nextBlock(genesis, state, first)
+
+    wrongState := common.Block{
+        Header:       common.Header{Index: 1, PrevBlock: genesis.Hash, TimeStamp: time.Now().Unix(), StateHash: "otro"},
+        Transactions: []common.Transaction{first},
+    }
+    SealBlock(&wrongState)
+
+    forged := valid
+    forged.Hash = genesis.Hash
+
+    unlinked := nextBlock(valid, state, first)
+
+    tests := []struct {
+        name  string
+        block common.Block
+    }{
+        {"segunda transacción con nonce repetido", nextBlock(genesis, state, first, first)},
+        {"segunda transacción sin saldo", nextBlock(genesis, state, first, signedTransaction(t, founder, recipient.Address, GenesisSupply, 2))},
+        {"segunda transacción desde una cuenta sin fondos", nextBlock(genesis, state, first, signedTransaction(t, recipient, founder.Address, 20, 1))},
+        {"compromiso de estado distinto", wrongState},
+        {"hash falso", forged},
+        {"no enlaza con la cabeza", unlinked},
+        {"génesis repetido", genesis},
+    }
+
+    before := StateHash(validator.State())
+    for _, test := range tests {
+        if err := validator.Add(test.block); err == nil {
+            t.Errorf("%s: se aceptó", test.name)
+        }
+        // Un bloque rechazado no deja aplicada ninguna de sus transacciones
+        if hash := StateHash(validator.State()); hash != before {
+            t.Errorf("%s: el estado cambió tras rechazar el bloque", test.name)
+        }
+    }
+
+    if err := validator.Add(valid); err != nil {
+        t.Fatalf("se rechazó el bloque válido tras los inválidos: %v", err)
+    }
+    if balance := validator.State()[common.AddressKey(recipient.Address)].Balance; balance != 10 {
+        t.Fatalf("saldo del destinatario: se esperaba 10 y es %f", balance)
+    }
+}
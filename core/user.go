@@ -2,65 +2,30 @@ package core
 
 import (
     "github.com/tyler-smith/go-bip32"
-    "github.com/tyler-smith/go-bip39"
-    "crypto/sha256"
-    "golang.org/x/crypto/ripemd160"
     "fmt"
     "log"
     "github.com/syndtr/goleveldb/leveldb"
-    "github.com/libp2p/go-libp2p/core/host"
     "encoding/json"
     "blockchain/common"
-    "blockchain/database"
 )
 
-func generateMnemonic() string {
-    entropy, _ := bip39.NewEntropy(128)
-    mnemonic, _ := bip39.NewMnemonic(entropy)
-    return mnemonic
-}
-
-func derivePrivateKey(mnemonic string) *bip32.Key {
-    seed := bip39.NewSeed(mnemonic, "")
-    masterKey, _ := bip32.NewMasterKey(seed)
-    return masterKey
-}
-
-func derivePublicKey(privateKey *bip32.Key) *bip32.Key {
-    return privateKey.PublicKey()
-}
-
-func deriveAddress(publicKey *bip32.Key) string {
-
-    sha256 := sha256.New()
-    sha256.Write(publicKey.Key)
-    hash := sha256.Sum(nil)
-
-    ripemd160 := ripemd160.New()
-    ripemd160.Write(hash)
-    hash = ripemd160.Sum(nil)
-
-    address := fmt.Sprintf("%x", hash)
-
-    return address
-}
-
-func NewUser(db string, h host.Host, master float64) (common.User, error){
-
-    mnemonic := generateMnemonic()
-    privateKey := derivePrivateKey(mnemonic)
-    publicKey := derivePublicKey(privateKey)
-    address := deriveAddress(publicKey)
+// RegisterUser agrega a la lista de usuarios la cuenta de una clave pública generada por una
+// billetera. El nodo calcula la dirección a partir de la clave y nunca conoce la clave privada.
+// La cuenta se guarda con la forma canónica de la dirección, como el estado. Si ya estaba
+// registrada devuelve la existente.
+func RegisterUser(db *leveldb.DB, publicKey *bip32.Key, balance float64) (common.User, error) {
+    if publicKey == nil || publicKey.IsPrivate {
+        return common.User{}, fmt.Errorf("se requiere la clave pública de la cuenta")
+    }
 
     user := &common.User{
-        PrivateKey: privateKey,
         PublicKey: publicKey,
-        Address: address,
-        Balance: master,
+        Address: common.AddressKey(common.DeriveAddress(publicKey.Key)),
+        Balance: balance,
     }
 
-    log.Println("Usuario generado:", user)
-    err := SaveUser(db, user)
+    log.Println("Registrando usuario:", common.FormatAddress(user.Address))
+    err := updateUserList(db, user)
     if err != nil {
         return common.User{}, err
     }
@@ -84,7 +49,20 @@ func updateUserList(db *leveldb.DB, user *common.User) error {
         }
     }
 
-    users = append(users, user)
+    // Una cuenta ya registrada conserva su saldo; solo se completa su clave pública
+    found := false
+    for _, existing := range users {
+        if existing.Address == user.Address {
+            existing.PublicKey = user.PublicKey
+            *user = *existing
+            found = true
+            break
+        }
+    }
+    if !found {
+        users = append(users, user)
+    }
+
     updatedData, err := json.Marshal(users)
     if err != nil {
         return err
@@ -93,30 +71,32 @@ func updateUserList(db *leveldb.DB, user *common.User) error {
     return db.Put([]byte("USER"), updatedData, nil)
 }
 
-func SaveUser(dbPath string, user *common.User) error {
-    // Abrir la base de datos maestra
-    masterDB, err := leveldb.OpenFile("data/master", nil)
+// LoadUsers lee la lista de usuarios guardada bajo la clave USER.
+// Si la clave no existe devuelve una lista vacía.
+func LoadUsers(db *leveldb.DB) ([]*common.User, error) {
+    data, err := db.Get([]byte("USER"), nil)
+    if err == leveldb.ErrNotFound {
+        return nil, nil
+    }
     if err != nil {
-        log.Fatalf("Failed to initialize master DB: %v", err)
+        return nil, err
     }
-    defer masterDB.Close()
 
-    // Leer y actualizar la lista de usuarios en la base de datos maestra
-    err = updateUserList(masterDB, user)
+    var users []*common.User
+    err = json.Unmarshal(data, &users)
     if err != nil {
-        return err
+        return nil, err
     }
+    return users, nil
+}
 
-    masterDB.Close()
-
-    // Sincronizar la base de datos local con la maestra
-    err = database.SyncNewEntriesToMasterDB(dbPath)
+// StoreUsers reemplaza la lista de usuarios guardada bajo la clave USER.
+func StoreUsers(db *leveldb.DB, users []*common.User) error {
+    data, err := json.Marshal(users)
     if err != nil {
-        log.Printf("Error al sincronizar con la base de datos maestra: %v", err)
         return err
     }
-
-    return nil
+    return db.Put([]byte("USER"), data, nil)
 }
 
 func LoadUser(db *leveldb.DB, address string) (*common.User, error) {
@@ -0,0 +1,274 @@
+package core
+
+import (
+    "fmt"
+    "github.com/syndtr/goleveldb/leveldb"
+    "blockchain/common"
+    "blockchain/database"
+)
+
+// GenesisSender es el remitente usado por las transacciones de emisión del bloque génesis.
+const GenesisSender = "0"
+
+// Pending es la altura con la que se validan las transacciones que todavía no están en un bloque.
+const Pending int64 = -1
+
+// legacy indica si un bloque de esa altura puede tener transacciones anteriores a las firmas
+// y a los nonces.
+func legacy(height int64) bool {
+    return height >= 0 && height <= LegacyHeight
+}
+
+// ValidateBlock verifica que un bloque esté bien formado y enlazado con su predecesor.
+// Para el bloque génesis prev debe ser nil.
+func ValidateBlock(block common.Block, prev *common.Block) error {
+    var prevHeader *common.Header
+    if prev != nil {
+        prevHeader = &prev.Header
+    }
+    if err := ValidateHeader(block.Header, prevHeader); err != nil {
+        return err
+    }
+
+    return ValidateBlockContents(block)
+}
+
+// ValidateHeader verifica el enlace de una cabecera con la anterior y, si lleva raíz de
+// transacciones, su propio hash. Para la cabecera del génesis prev debe ser nil.
+func ValidateHeader(header common.Header, prev *common.Header) error {
+    if prev == nil {
+        if header.Index != 0 {
+            return fmt.Errorf("se esperaba el bloque génesis y se recibió la altura %d", header.Index)
+        }
+        if header.PrevBlock != "" {
+            return fmt.Errorf("el bloque génesis no debe tener bloque previo")
+        }
+    } else {
+        if header.Index != prev.Index+1 {
+            return fmt.Errorf("altura inesperada: se esperaba %d y se recibió %d", prev.Index+1, header.Index)
+        }
+        if header.PrevBlock != prev.Hash {
+            return fmt.Errorf("el bloque %d no enlaza con el hash del bloque %d", header.Index, prev.Index)
+        }
+    }
+
+    // Las cabeceras antiguas, sin raíz de transacciones, solo se pueden comprobar con el cuerpo
+    if header.TxRoot != "" && HeaderHash(header) != header.Hash {
+        return fmt.Errorf("hash de cabecera inválido en el bloque %d", header.Index)
+    }
+
+    return nil
+}
+
+// ValidateBlockContents comprueba el hash del bloque y sus transacciones, sin mirar el enlace.
+func ValidateBlockContents(block common.Block) error {
+    if hash := CalculateHash(block); hash != block.Hash {
+        return fmt.Errorf("hash inválido en el bloque %d: se esperaba %s y se recibió %s", block.Index, hash, block.Hash)
+    }
+    if block.TxRoot != "" && block.TxRoot != TransactionsRoot(block.Transactions) {
+        return fmt.Errorf("las transacciones del bloque %d no coinciden con su cabecera", block.Index)
+    }
+
+    for _, transaction := range block.Transactions {
+        if err := ValidateTransaction(transaction, block.Index); err != nil {
+            return fmt.Errorf("transacción inválida en el bloque %d: %v", block.Index, err)
+        }
+    }
+
+    return nil
+}
+
+// ValidateTransaction comprueba los campos, el hash y la firma de una transacción incluida en
+// el bloque de la altura indicada, o Pending si todavía no está en ninguno. Solo el bloque
+// génesis puede contener transacciones de emisión.
+func ValidateTransaction(transaction common.Transaction, height int64) error {
+    if transaction.Hash != common.GenerateTransactionHash(transaction) {
+        return fmt.Errorf("hash de transacción inválido: %s", transaction.Hash)
+    }
+
+    genesis := height == 0 && transaction.Sender == GenesisSender
+    if transaction.Sender == GenesisSender && !genesis {
+        return fmt.Errorf("transacción de emisión fuera del bloque génesis: %s", transaction.Hash)
+    }
+
+    if transaction.Ammount <= 0 {
+        return fmt.Errorf("monto inválido en la transacción %s: %f", transaction.Hash, transaction.Ammount)
+    }
+
+    if transaction.Recipient == "" {
+        return fmt.Errorf("la transacción %s no tiene destinatario", transaction.Hash)
+    }
+
+    if transaction.Nonce < 0 || transaction.Fee < 0 {
+        return fmt.Errorf("nonce o comisión inválidos en la transacción %s", transaction.Hash)
+    }
+
+    // Una cuenta multifirma solo gasta con su descripción y el umbral de firmas: sin ellas la
+    // transacción no tendría ninguna firma que comprobar
+    if common.IsMultisigAddress(transaction.Sender) && transaction.Multisig == nil {
+        return fmt.Errorf("transacción %s: el remitente es una cuenta multifirma y la transacción no lleva su multifirma", transaction.Hash)
+    }
+
+    // Las emisiones del génesis y, hasta LegacyHeight, las transacciones anteriores a las firmas
+    // no llevan clave pública; las demás deben estar firmadas por el remitente, o por el umbral
+    // de claves de su multifirma, y tener un destinatario válido en la red
+    if genesis || (legacy(height) && transaction.PublicKey == "" && transaction.Multisig == nil) {
+        return nil
+    }
+    if err := common.ValidateAddress(transaction.Recipient); err != nil {
+        return fmt.Errorf("transacción %s: destinatario inválido: %v", transaction.Hash, err)
+    }
+    if err := common.VerifyTransactionSignature(transaction); err != nil {
+        return fmt.Errorf("transacción %s: %v", transaction.Hash, err)
+    }
+
+    return nil
+}
+
+// VerifyChain recorre la cadena completa comprobando hashes, enlaces, compromisos de estado,
+// índices y saldos. En un nodo iniciado desde una instantánea la revisión empieza en ella, y
+// de los bloques podados solo se comprueba el enlace de sus cabeceras.
+// Devuelve la cantidad de bloques revisados y la lista de problemas encontrados.
+func VerifyChain(db *leveldb.DB) (int64, []string, error) {
+    var issues []string
+
+    head, err := database.LastBlockIndex(db)
+    if err != nil {
+        return 0, nil, err
+    }
+
+    pruned, err := PrunedHeight(db)
+    if err != nil {
+        return 0, nil, err
+    }
+
+    // El estado se reproduce desde el génesis o, si faltan bloques, desde la instantánea base
+    var base *common.Snapshot
+    if _, err := LoadHeader(db, 0); err == leveldb.ErrNotFound || pruned > 0 {
+        base, err = LatestSnapshot(db, pruned)
+        if err == leveldb.ErrNotFound {
+            base, err = EarliestSnapshot(db)
+        }
+        if err != nil {
+            return 0, nil, fmt.Errorf("faltan bloques y no hay una instantánea desde la que revisar el estado: %v", err)
+        }
+    }
+
+    state := make(State)
+    stateOK := true
+    if base != nil {
+        state = StateFromAccounts(base.Accounts)
+        if accountsHash(base.Accounts) != base.StateHash {
+            issues = append(issues, fmt.Sprintf("altura %d: el hash de la instantánea no coincide con sus cuentas", base.Height))
+        }
+    }
+
+    var checked int64
+    var prev *common.Header
+    for height := int64(0); height <= head; height++ {
+        // Cabeceras de bloques podados: solo se comprueba el enlace
+        if height < pruned {
+            header, err := LoadHeader(db, height)
+            if err == leveldb.ErrNotFound && base != nil {
+                continue
+            }
+            if err != nil {
+                issues = append(issues, fmt.Sprintf("altura %d: no se pudo cargar la cabecera: %v", height, err))
+                prev = nil
+                continue
+            }
+            if prev != nil && (header.Index != height || header.PrevBlock != prev.Hash) {
+                issues = append(issues, fmt.Sprintf("altura %d: la cabecera no enlaza con la anterior", height))
+            }
+            if base != nil && height == base.Height && header.Hash != base.BlockHash {
+                issues = append(issues, fmt.Sprintf("altura %d: la instantánea no corresponde al bloque", height))
+            }
+            checked++
+            prev = header
+            continue
+        }
+
+        block, err := LoadBlock(db, height)
+        if err == leveldb.ErrNotFound && base != nil && height < base.Height {
+            continue
+        }
+        if err != nil {
+            issues = append(issues, fmt.Sprintf("altura %d: no se pudo cargar el bloque: %v", height, err))
+            prev = nil
+            stateOK = false
+            continue
+        }
+        checked++
+
+        if prev != nil {
+            prevBlock := common.Block{Header: *prev}
+            err = ValidateBlock(*block, &prevBlock)
+        } else if height == 0 {
+            err = ValidateBlock(*block, nil)
+        } else {
+            err = ValidateBlockContents(*block)
+        }
+        if err != nil {
+            issues = append(issues, fmt.Sprintf("altura %d: %v", height, err))
+        }
+
+        if base != nil && height == base.Height && block.Hash != base.BlockHash {
+            issues = append(issues, fmt.Sprintf("altura %d: la instantánea no corresponde al bloque", height))
+        }
+
+        // Los bloques hasta la instantánea base ya están reflejados en su estado
+        if stateOK && (base == nil || height > base.Height) {
+            if block.StateHash != "" && block.StateHash != StateHash(state) {
+                issues = append(issues, fmt.Sprintf("altura %d: el compromiso de estado no coincide", height))
+            }
+            if err := ApplyBlock(state, *block); err != nil {
+                issues = append(issues, fmt.Sprintf("estado: %v", err))
+                stateOK = false
+            }
+        }
+
+        issues = append(issues, verifyIndexes(db, block)...)
+        prev = &block.Header
+    }
+
+    if stateOK {
+        users, err := LoadUsers(db)
+        if err != nil {
+            return checked, issues, fmt.Errorf("error al leer usuarios: %v", err)
+        }
+        for _, user := range users {
+            var balance float64
+            if account, ok := state[user.Address]; ok {
+                balance = account.Balance
+            }
+            if user.Balance != balance {
+                issues = append(issues, fmt.Sprintf("estado: el saldo de %s es %f pero la cadena indica %f", user.Address, user.Balance, balance))
+            }
+        }
+    }
+
+    return checked, issues, nil
+}
+
+func verifyIndexes(db *leveldb.DB, block *common.Block) []string {
+    var issues []string
+
+    check := func(key []byte, what string) {
+        value, err := db.Get(key, nil)
+        if err != nil {
+            issues = append(issues, fmt.Sprintf("altura %d: falta el índice de %s", block.Index, what))
+            return
+        }
+        height, err := database.ParseHeight(value)
+        if err != nil || height != block.Index {
+            issues = append(issues, fmt.Sprintf("altura %d: el índice de %s apunta a %s", block.Index, what, value))
+        }
+    }
+
+    check(database.BlockHashKey(block.Hash), "bloque "+block.Hash)
+    for _, transaction := range block.Transactions {
+        check(database.TxIndexKey(transaction.Hash), "transacción "+transaction.Hash)
+    }
+
+    return issues
+}
@@ -0,0 +1,164 @@
+package core
+
+import (
+    "testing"
+    "time"
+    "blockchain/common"
+    "blockchain/wallet"
+)
+
+const testMnemonic = "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+
+func testAccount(t *testing.T, index uint32) *wallet.Account {
+    account, err := wallet.DeriveAccount(testMnemonic, "", wallet.AddressPath(0, index))
+    if err != nil {
+        t.Fatal(err)
+    }
+    return account
+}
+
+// signedTransaction devuelve una transacción de from a to firmada por from.
+func signedTransaction(t *testing.T, from *wallet.Account, to string, amount float64, nonce int64) common.Transaction {
+    transaction, err := wallet.NewTransaction(from.Address, to, amount, 0, nonce)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := from.Sign(transaction); err != nil {
+        t.Fatal(err)
+    }
+    return *transaction
+}
+
+// testChain devuelve el génesis, que acredita la emisión a founder, y un validador tras él.
+func testChain(t *testing.T, founder string) (common.Block, *ChainValidator) {
+    genesis, _ := CreateGenesisBlock(founder)
+    validator := NewChainValidator(nil, nil)
+    if err := validator.Add(genesis); err != nil {
+        t.Fatal(err)
+    }
+    return genesis, validator
+}
+
+// nextBlock sella un bloque con las transacciones indicadas a continuación de prev y con el
+// compromiso del estado tras prev.
+func nextBlock(prev common.Block, state State, transactions ...common.Transaction) common.Block {
+    block := common.Block{
+        Header:       common.Header{Index: prev.Index + 1, PrevBlock: prev.Hash, TimeStamp: time.Now().Unix(), StateHash: StateHash(state)},
+        Transactions: transactions,
+    }
+    SealBlock(&block)
+    return block
+}
+
+func TestUnsignedTransactionRejected(t *testing.T) {
+    founder := testAccount(t, 0)
+    thief := testAccount(t, 1)
+    genesis, validator := testChain(t, founder.Address)
+
+    // Sin clave pública ni firma, como las transacciones anteriores a las firmas
+    unsigned := common.Transaction{Sender: founder.Address, Recipient: thief.Address, Ammount: 100, TimeStamp: time.Now().Unix()}
+    unsigned.Hash = common.GenerateTransactionHash(unsigned)
+
+    forged := signedTransaction(t, founder, thief.Address, 100, 1)
+    forged.Ammount = 1000
+    forged.Hash = common.GenerateTransactionHash(forged)
+
+    emission := genesis.Transactions[0]
+
+    tests := []struct {
+        name        string
+        transaction common.Transaction
+        height      int64
+        legacy      int64
+        valid       bool
+    }{
+        {"firmada", signedTransaction(t, founder, thief.Address, 100, 1), 1, 0, true},
+        {"firmada pendiente", signedTransaction(t, founder, thief.Address, 100, 1), Pending, 0, true},
+        {"sin firma", unsigned, 1, 0, false},
+        {"sin firma pendiente", unsigned, Pending, 5, false},
+        {"sin firma antes del punto de control", unsigned, 3, 5, true},
+        {"sin firma tras el punto de control", unsigned, 6, 5, false},
+        {"firma de otro monto", forged, 1, 0, false},
+        {"firma de otro monto antes del punto de control", forged, 3, 5, false},
+        {"emisión en el génesis", emission, 0, 0, true},
+        {"emisión fuera del génesis", emission, 3, 5, false},
+    }
+
+    defer func(height int64) { LegacyHeight = height }(LegacyHeight)
+    for _, test := range tests {
+        LegacyHeight = test.legacy
+        err := ValidateTransaction(test.transaction, test.height)
+        if test.valid && err != nil {
+            t.Errorf("%s: se rechazó: %v", test.name, err)
+        }
+        if !test.valid && err == nil {
+            t.Errorf("%s: se aceptó", test.name)
+        }
+    }
+
+    // Un bloque recibido de otro nodo no puede mover fondos sin la clave del remitente
+    LegacyHeight = 0
+    if err := validator.Add(nextBlock(genesis, validator.State(), unsigned)); err == nil {
+        t.Fatal("se aceptó un bloque con una transacción sin firma")
+    }
+}
+
+func TestMultisigSenderRequiresMultisig(t *testing.T) {
+    var accounts []*wallet.Account
+    var keys []string
+    for i := uint32(0); i < 3; i++ {
+        account := testAccount(t, i)
+        key, err := wallet.ParsePublicKey(account.PublicKey.String())
+        if err != nil {
+            t.Fatal(err)
+        }
+        accounts = append(accounts, account)
+        keys = append(keys, key)
+    }
+    multisig, err := common.NewMultisig(2, keys)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    partial, err := wallet.NewMultisigTransaction(multisig, accounts[0].Address, 10, 0, 1)
+    if err != nil {
+        t.Fatal(err)
+    }
+    unsigned := partial.Transaction
+    unsigned.Signatures = append([]string(nil), unsigned.Signatures...)
+    for _, account := range accounts[:2] {
+        if err := account.SignMultisig(partial); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    // Sin la descripción, las claves ni las firmas la transacción parecería anterior a las firmas
+    stripped := partial.Transaction
+    stripped.Multisig, stripped.Signatures = nil, nil
+    stripped.Hash = common.GenerateTransactionHash(stripped)
+
+    tests := []struct {
+        name        string
+        transaction common.Transaction
+        valid       bool
+    }{
+        {"umbral de firmas", partial.Transaction, true},
+        {"sin firmas", unsigned, false},
+        {"sin multifirma", stripped, false},
+    }
+
+    // Ni siquiera en los bloques antiguos, que no podían tener cuentas multifirma
+    defer func(height int64) { LegacyHeight = height }(LegacyHeight)
+    LegacyHeight = 5
+    for _, test := range tests {
+        for _, height := range []int64{Pending, 3, 6} {
+            err := ValidateTransaction(test.transaction, height)
+            if test.valid && err != nil {
+                t.Errorf("%s en la altura %d: se rechazó: %v", test.name, height, err)
+            }
+            if !test.valid && err == nil {
+                t.Errorf("%s en la altura %d: se aceptó", test.name, height)
+            }
+        }
+    }
+}
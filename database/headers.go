@@ -0,0 +1,46 @@
+package database
+
+import (
+    "encoding/json"
+    "fmt"
+    "github.com/syndtr/goleveldb/leveldb"
+    "github.com/syndtr/goleveldb/leveldb/util"
+    "blockchain/common"
+)
+
+// HeaderPrefix agrupa el almacén de cabeceras verificadas durante la sincronización.
+// Las cabeceras se guardan antes que los cuerpos, así que pueden ir por delante de los bloques.
+const HeaderPrefix = "header-"
+
+// HeaderKey devuelve la clave de la cabecera de una altura, rellena con ceros para mantener el orden.
+func HeaderKey(height int64) []byte {
+    return []byte(fmt.Sprintf("%s%020d", HeaderPrefix, height))
+}
+
+// GetHeader lee la cabecera guardada para una altura.
+func GetHeader(db *leveldb.DB, height int64) (*common.Header, error) {
+    data, err := db.Get(HeaderKey(height), nil)
+    if err != nil {
+        return nil, err
+    }
+    var header common.Header
+    if err := json.Unmarshal(data, &header); err != nil {
+        return nil, err
+    }
+    return &header, nil
+}
+
+// DeleteHeadersFrom borra las cabeceras guardadas desde la altura indicada en adelante.
+func DeleteHeadersFrom(db *leveldb.DB, height int64) error {
+    iter := db.NewIterator(&util.Range{Start: HeaderKey(height), Limit: []byte(HeaderPrefix + "~")}, nil)
+    defer iter.Release()
+
+    batch := new(leveldb.Batch)
+    for iter.Next() {
+        batch.Delete(append([]byte{}, iter.Key()...))
+    }
+    if err := iter.Error(); err != nil {
+        return err
+    }
+    return db.Write(batch, nil)
+}
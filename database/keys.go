@@ -0,0 +1,41 @@
+package database
+
+import (
+    "fmt"
+    "strconv"
+)
+
+// Prefijos de los índices secundarios. Ninguna de estas claves es un número,
+// así que no se confunden con las alturas de bloque.
+const BlockHashPrefix = "blockhash-"
+const TxIndexPrefix = "tx-"
+const SnapshotPrefix = "snapshot-"
+
+// PrunedKey guarda la altura a partir de la cual el nodo conserva los cuerpos de los bloques.
+const PrunedKey = "PRUNED"
+
+// BlockKey devuelve la clave bajo la que se guarda el bloque de una altura.
+func BlockKey(height int64) []byte {
+    return []byte(strconv.FormatInt(height, 10))
+}
+
+// BlockHashKey devuelve la clave del índice hash de bloque -> altura.
+func BlockHashKey(hash string) []byte {
+    return []byte(BlockHashPrefix + hash)
+}
+
+// TxIndexKey devuelve la clave del índice hash de transacción -> altura del bloque.
+func TxIndexKey(hash string) []byte {
+    return []byte(TxIndexPrefix + hash)
+}
+
+// SnapshotKey devuelve la clave de la instantánea de estado de una altura.
+// La altura se rellena con ceros para que las claves queden ordenadas por altura.
+func SnapshotKey(height int64) []byte {
+    return []byte(fmt.Sprintf("%s%020d", SnapshotPrefix, height))
+}
+
+// ParseHeight interpreta el valor de un índice como altura de bloque.
+func ParseHeight(value []byte) (int64, error) {
+    return strconv.ParseInt(string(value), 10, 64)
+}
@@ -0,0 +1,61 @@
+package network
+
+import (
+    "encoding/json"
+    "log"
+    "net/http"
+    "time"
+    "github.com/gorilla/mux"
+    "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// DefaultAdminAddr es la dirección por defecto de la API de administración del nodo. Solo
+// escucha en la interfaz local porque no tiene autenticación.
+const DefaultAdminAddr = "127.0.0.1:8090"
+
+// ServeAdminAPI atiende la API de administración del nodo en addr:
+//
+//  GET    /peers            tabla de pares con su puntuación y vetos
+//  POST   /peers/{id}/ban   veta al par (parámetro opcional duration, por ejemplo 30m)
+//  DELETE /peers/{id}/ban   levanta el veto del par
+func ServeAdminAPI(addr string, pm *PeerManager) error {
+    r := mux.NewRouter()
+
+    r.HandleFunc("/peers", func(w http.ResponseWriter, r *http.Request) {
+        writeAdminJSON(w, http.StatusOK, pm.Table())
+    }).Methods("GET")
+
+    r.HandleFunc("/peers/{id}/ban", func(w http.ResponseWriter, r *http.Request) {
+        id, err := peer.Decode(mux.Vars(r)["id"])
+        if err != nil {
+            writeAdminJSON(w, http.StatusBadRequest, map[string]string{"error": "ID de par inválido"})
+            return
+        }
+
+        if r.Method == "DELETE" {
+            pm.Unban(id)
+            writeAdminJSON(w, http.StatusOK, map[string]string{"unbanned": id.String()})
+            return
+        }
+
+        duration := BanDuration
+        if value := r.URL.Query().Get("duration"); value != "" {
+            duration, err = time.ParseDuration(value)
+            if err != nil || duration <= 0 {
+                writeAdminJSON(w, http.StatusBadRequest, map[string]string{"error": "duración inválida"})
+                return
+            }
+        }
+        pm.Ban(id, duration)
+        writeAdminJSON(w, http.StatusOK, map[string]string{"banned": id.String(), "duration": duration.String()})
+    }).Methods("POST", "DELETE")
+
+    log.Printf("API de administración escuchando en %s\n", addr)
+    return http.ListenAndServe(addr, r)
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, v interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(v)
+}
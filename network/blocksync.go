@@ -0,0 +1,771 @@
+package network
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "sort"
+    "sync"
+    "time"
+    "github.com/libp2p/go-libp2p/core/host"
+    "github.com/libp2p/go-libp2p/core/network"
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/syndtr/goleveldb/leveldb"
+    "blockchain/common"
+    "blockchain/core"
+    "blockchain/database"
+)
+
+const SyncProtocolID = "/blockchain/sync/2.0.0"
+
+// Tipos de solicitud del protocolo de sincronización.
+const (
+    SyncRequestHead    = "head"
+    SyncRequestHeaders = "headers"
+    SyncRequestBlocks  = "blocks"
+)
+
+// MaxHeadersPerRequest es la cantidad máxima de cabeceras que un nodo entrega por solicitud.
+const MaxHeadersPerRequest = 1024
+
+// MaxBlocksPerRequest es la cantidad máxima de bloques que un nodo entrega por solicitud.
+const MaxBlocksPerRequest = 128
+
+// SyncBatchSize es la cantidad de bloques que se piden en cada lote.
+const SyncBatchSize = 64
+
+// MaxBatchAttempts limita los reintentos de un mismo lote antes de abandonar la sincronización.
+const MaxBatchAttempts = 5
+
+// MaxRateLimitRetries es la cantidad de veces que se reintenta una solicitud que el par rechazó
+// por superar su límite.
+const MaxRateLimitRetries = 3
+
+// MaxPeerFailures es la cantidad de fallos tras la que un par deja de usarse en la sincronización.
+const MaxPeerFailures = 3
+
+// errOtherBranch indica que un par entregó un bloque que no coincide con la cabecera verificada
+// de su altura. El par puede seguir honestamente otra rama de la cadena, así que no se le penaliza.
+var errOtherBranch = errors.New("el bloque no coincide con su cabecera verificada: el par sigue otra rama")
+
+// SyncRequest es una solicitud del protocolo de sincronización. Cada solicitud usa su propio stream.
+type SyncRequest struct {
+    Type  string
+    From  int64 `json:",omitempty"`
+    Count int64 `json:",omitempty"`
+}
+
+// SyncResponse es la respuesta a una SyncRequest. Head, Hash y Base describen la cadena del
+// nodo que responde: Base es la altura del primer bloque completo que puede entregar.
+type SyncResponse struct {
+    Head   int64
+    Hash   string
+    Base    int64
+    Headers []common.Header `json:",omitempty"`
+    Blocks  []common.Block  `json:",omitempty"`
+}
+
+func SetupSyncHandler(ctx context.Context, h host.Host, db *leveldb.DB) {
+    h.SetStreamHandler(SyncProtocolID, func(s network.Stream) {
+        serveStream(ctx, s, MsgSync, func(ctx context.Context, request *Message) (interface{}, error) {
+            var query SyncRequest
+            if err := request.Decode(&query); err != nil {
+                return nil, protocolErrorf(CodeBadRequest, "%v", err)
+            }
+            return serveSyncRequest(db, query)
+        })
+    })
+}
+
+func serveSyncRequest(db *leveldb.DB, request SyncRequest) (*SyncResponse, error) {
+    head, err := database.LastBlockIndex(db)
+    if err != nil {
+        return nil, err
+    }
+    header, err := core.LoadHeader(db, head)
+    if err != nil {
+        return nil, fmt.Errorf("error al cargar el bloque %d: %v", head, err)
+    }
+    base, err := core.FirstFullBlock(db)
+    if err != nil {
+        return nil, err
+    }
+
+    response := &SyncResponse{Head: head, Hash: header.Hash, Base: base}
+
+    switch request.Type {
+    case SyncRequestHead:
+        return response, nil
+
+    case SyncRequestHeaders:
+        count := request.Count
+        if count <= 0 || count > MaxHeadersPerRequest {
+            count = MaxHeadersPerRequest
+        }
+        for height := request.From; height < request.From+count && height <= head; height++ {
+            header, err := core.LoadHeader(db, height)
+            // Un nodo iniciado desde una instantánea solo tiene las cabeceras anteriores en su almacén
+            if err == leveldb.ErrNotFound {
+                header, err = database.GetHeader(db, height)
+            }
+            if err != nil {
+                return nil, fmt.Errorf("error al cargar la cabecera %d: %v", height, err)
+            }
+            response.Headers = append(response.Headers, *header)
+        }
+        return response, nil
+
+    case SyncRequestBlocks:
+        if request.From < base {
+            return nil, protocolErrorf(CodePruned, "bloques podados: este nodo solo conserva bloques completos desde la altura %d", base)
+        }
+        count := request.Count
+        if count <= 0 || count > MaxBlocksPerRequest {
+            count = MaxBlocksPerRequest
+        }
+        for height := request.From; height < request.From+count && height <= head; height++ {
+            block, err := core.LoadBlock(db, height)
+            if err != nil {
+                return nil, fmt.Errorf("error al cargar el bloque %d: %v", height, err)
+            }
+            response.Blocks = append(response.Blocks, *block)
+        }
+        return response, nil
+    }
+
+    return nil, protocolErrorf(CodeBadRequest, "tipo de solicitud desconocido: %s", request.Type)
+}
+
+// requestSync envía una solicitud de sincronización a un par y espera su respuesta.
+// Si el par responde que se superó su límite de solicitudes se reintenta tras una pausa.
+func requestSync(ctx context.Context, h host.Host, id peer.ID, request SyncRequest) (*SyncResponse, error) {
+    var response SyncResponse
+    err := SendRequest(ctx, h, id, SyncProtocolID, MsgSync, &request, &response)
+    for retry := 1; retry <= MaxRateLimitRetries && errorCode(err) == CodeRateLimited; retry++ {
+        select {
+        case <-ctx.Done():
+            return nil, ctx.Err()
+        case <-time.After(time.Duration(retry) * time.Second):
+        }
+        err = SendRequest(ctx, h, id, SyncProtocolID, MsgSync, &request, &response)
+    }
+    if ctx.Err() != nil {
+        // La sincronización se canceló; el par no tiene la culpa
+        return nil, ctx.Err()
+    }
+    if perr, ok := err.(*ProtocolError); ok && perr.Code != CodeTimeout {
+        return nil, fmt.Errorf("%s respondió: %v", id, perr)
+    }
+    if err != nil {
+        // El par no respondió a tiempo o lo hizo con un mensaje ilegible
+        reportPeer(id, Timeout)
+        return nil, err
+    }
+
+    return &response, nil
+}
+
+// queryHeads pide en paralelo la cabeza de cadena de cada par. Los pares que fallan se omiten.
+func queryHeads(ctx context.Context, h host.Host, peers []peer.ID) map[peer.ID]*SyncResponse {
+    heads := make(map[peer.ID]*SyncResponse)
+    var mu sync.Mutex
+    var wg sync.WaitGroup
+
+    for _, id := range peers {
+        wg.Add(1)
+        go func(id peer.ID) {
+            defer wg.Done()
+            response, err := requestSync(ctx, h, id, SyncRequest{Type: SyncRequestHead})
+            if err != nil {
+                log.Printf("No se pudo obtener la cabeza de %s: %v\n", id, err)
+                return
+            }
+            mu.Lock()
+            heads[id] = response
+            mu.Unlock()
+            noteHead(id, response.Head)
+        }(id)
+    }
+
+    wg.Wait()
+    return heads
+}
+
+// SyncBlocks pone al día la base de datos local con la cadena más alta de los pares. Primero
+// intercambia las cabezas de cadena, después descarga y verifica la cadena de cabeceras contra
+// los puntos de control y por último pide los bloques que faltan en lotes, repartidos entre
+// varios pares en paralelo. Cada bloque debe coincidir con su cabecera verificada y se valida
+// antes de guardarse, así que si la sincronización se corta basta con volver a llamarla para
+// continuar desde el último bloque. Si se cancela ctx la sincronización se detiene en el último
+// bloque guardado.
+//
+// Las cabeceras solo se comprueban por su enlace y su hash, así que nada impide a un par
+// inventar una cadena de cabeceras más allá del último punto de control. Hasta él la cadena de
+// cabeceras se descarga entera; después se pide de a un lote de MaxHeadersPerRequest y los
+// bloques de cada lote se validan antes de pedir el siguiente.
+//
+// La cadena que vale es la más larga. Si la de un par se separa de la local por encima del
+// último punto de control, la rama del par reemplaza a los bloques locales desde la separación,
+// pero solo una vez validada hasta superar la altura de la cadena local.
+func SyncBlocks(ctx context.Context, h host.Host, db *leveldb.DB, peers []peer.ID, checkpoints core.Checkpoints) error {
+    localHead, err := localChainHead(db)
+    if err != nil {
+        return err
+    }
+
+    // Los pares que en el handshake anunciaron una cadena que no supera la local no se consultan
+    var ahead []peer.ID
+    for _, id := range peers {
+        if status, ok := advertisedStatus(id); ok && status.Head <= localHead {
+            continue
+        }
+        ahead = append(ahead, id)
+    }
+    if len(ahead) == 0 {
+        log.Printf("La cadena local ya está al día en la altura %d\n", localHead)
+        return nil
+    }
+
+    heads := queryHeads(ctx, h, ahead)
+    if len(heads) == 0 {
+        return fmt.Errorf("ningún par respondió a la solicitud de cabeza de cadena")
+    }
+
+    for localHead < bestHead(heads) {
+        limit, checkpointed := headersLimit(localHead, checkpoints)
+        fork, target, err := syncHeaders(ctx, h, db, heads, localHead, limit, checkpoints)
+        if err != nil {
+            return err
+        }
+
+        // Un nodo vacío intenta arrancar desde una instantánea antes de pedir la cadena entera.
+        // Su estado no se puede comprobar con los bloques, así que solo se acepta dentro de los
+        // puntos de control
+        if localHead == -1 && checkpointed {
+            for _, id := range peersByHead(heads) {
+                if ctx.Err() != nil {
+                    return ctx.Err()
+                }
+                if status, ok := advertisedStatus(id); ok && !status.Has(CapabilitySnapshots) {
+                    continue
+                }
+                if err := SyncFromSnapshot(ctx, h, db, id); err != nil {
+                    log.Printf("No se pudo usar la instantánea de %s: %v\n", id, err)
+                    continue
+                }
+                break
+            }
+            localHead, err = localChainHead(db)
+            if err != nil {
+                return err
+            }
+            fork = localHead
+        }
+
+        if localHead < target {
+            if err := syncBodies(ctx, h, db, heads, fork, localHead, target); err != nil {
+                return err
+            }
+        }
+        localHead = target
+    }
+
+    log.Printf("La cadena local ya está al día en la altura %d\n", localHead)
+    return nil
+}
+
+// headersLimit devuelve hasta qué altura se piden cabeceras antes de descargar sus bloques: el
+// último punto de control si la cadena local no llegó a él, que es el único tramo en el que la
+// cadena de cabeceras está garantizada, o un lote más allá de la cabeza local.
+func headersLimit(localHead int64, checkpoints core.Checkpoints) (int64, bool) {
+    if last := checkpoints.Last(); len(checkpoints) > 0 && last > localHead {
+        return last, true
+    }
+    return localHead + MaxHeadersPerRequest, false
+}
+
+// bestHead devuelve la altura más alta anunciada por los pares.
+func bestHead(heads map[peer.ID]*SyncResponse) int64 {
+    best := int64(-1)
+    for _, head := range heads {
+        if head.Head > best {
+            best = head.Head
+        }
+    }
+    return best
+}
+
+// syncBodies descarga, valida y guarda los bloques que siguen a fork hasta target, cuyas
+// cabeceras ya están verificadas. Si fork está por debajo de localHead, los bloques de la rama
+// nueva se guardan juntos, en una sola escritura, cuando la rama supera a la cadena local; si
+// la sincronización se corta antes, la cadena local no cambia.
+func syncBodies(ctx context.Context, h host.Host, db *leveldb.DB, heads map[peer.ID]*SyncResponse, fork, localHead, target int64) error {
+    validator := core.NewChainValidator(nil, nil)
+    if fork >= 0 {
+        prev, err := core.LoadBlock(db, fork)
+        if err != nil {
+            return fmt.Errorf("error al cargar el bloque %d: %v", fork, err)
+        }
+        state, err := core.StateAt(db, fork)
+        if err != nil {
+            return err
+        }
+        validator = core.NewChainValidator(prev, state)
+    }
+
+    log.Printf("Sincronizando bloques %d a %d desde %d pares\n", fork+1, target, len(heads))
+
+    // Solo se guarda el estado tras el último bloque guardado, nunca el de un bloque rechazado
+    var saved core.State
+    var branch []common.Block
+    reorg := fork < localHead
+    err := fetchBlocks(ctx, h, heads, fork+1, target, func(block common.Block) error {
+        if err := matchesHeader(db, block); err != nil {
+            return err
+        }
+        if err := validator.Add(block); err != nil {
+            return err
+        }
+
+        if reorg {
+            branch = append(branch, block)
+            if block.Index <= localHead {
+                return nil
+            }
+            if err := core.SaveBlocks(db, branch, validator.State()); err != nil {
+                return err
+            }
+            log.Printf("Cadena reorganizada: los bloques %d a %d se reemplazaron por la rama de los pares\n", fork+1, localHead)
+            reorg, branch = false, nil
+            return nil
+        }
+
+        if err := core.SaveBlock(db, block); err != nil {
+            return err
+        }
+        saved = validator.State()
+        return nil
+    })
+
+    // Si la sincronización quedó a medias el estado se guarda igual, para que sea coherente con
+    // los bloques que sí se guardaron
+    if saved != nil {
+        if serr := core.StoreState(db, saved); serr != nil && err == nil {
+            err = fmt.Errorf("error al guardar el estado: %v", serr)
+        }
+    }
+    return err
+}
+
+// syncHeaders busca el último bloque que la cadena local comparte con el par más alto y
+// descarga las cabeceras que le siguen, hasta limit, tras comprobar su enlace, su hash y los
+// puntos de control. Por debajo del último punto de control la cadena no se reorganiza: un par
+// cuya cadena se separa de la local antes se descarta. Si un par entrega una cadena inválida se
+// descarta, también para la descarga de bloques, y se prueba con el siguiente. Devuelve la
+// altura del bloque común y la de la última cabecera verificada.
+func syncHeaders(ctx context.Context, h host.Host, db *leveldb.DB, heads map[peer.ID]*SyncResponse, localHead, limit int64, checkpoints core.Checkpoints) (int64, int64, error) {
+    floor, err := reorgFloor(db, localHead, checkpoints)
+    if err != nil {
+        return -1, -1, err
+    }
+    if localHead >= 0 {
+        local, err := core.LoadHeader(db, localHead)
+        if err != nil {
+            return -1, -1, fmt.Errorf("error al cargar la cabecera %d: %v", localHead, err)
+        }
+        if err := checkpoints.Check(local.Index, local.Hash); err != nil {
+            return -1, -1, fmt.Errorf("la cadena local no pasa por los puntos de control: %v", err)
+        }
+    }
+
+    for _, id := range peersByHead(heads) {
+        if heads[id].Head <= localHead {
+            break
+        }
+
+        fork, err := forkPoint(ctx, h, db, id, localHead, floor)
+        if ctx.Err() != nil {
+            return -1, -1, ctx.Err()
+        }
+        if err != nil {
+            log.Printf("Cadena de %s descartada: %v\n", id, err)
+            delete(heads, id)
+            continue
+        }
+        var prev *common.Header
+        if fork >= 0 {
+            prev, err = core.LoadHeader(db, fork)
+            if err != nil {
+                return -1, -1, fmt.Errorf("error al cargar la cabecera %d: %v", fork, err)
+            }
+        }
+
+        // Las cabeceras que queden de una sincronización anterior se vuelven a pedir
+        if err := database.DeleteHeadersFrom(db, fork+1); err != nil {
+            return -1, -1, fmt.Errorf("error al limpiar el almacén de cabeceras: %v", err)
+        }
+
+        head := heads[id].Head
+        if head > limit {
+            head = limit
+        }
+        tip, err := fetchHeaders(ctx, h, db, id, prev, head, checkpoints)
+        if ctx.Err() != nil {
+            database.DeleteHeadersFrom(db, fork+1)
+            return -1, -1, ctx.Err()
+        }
+        if err != nil {
+            log.Printf("Cadena de cabeceras de %s rechazada: %v\n", id, err)
+            delete(heads, id)
+            continue
+        }
+
+        if fork < localHead {
+            log.Printf("La cadena de %s se separa de la local en la altura %d y llega a la %d\n", id, fork, heads[id].Head)
+        }
+        log.Printf("Cabeceras verificadas hasta la altura %d con %s\n", tip, id)
+        return fork, tip, nil
+    }
+
+    database.DeleteHeadersFrom(db, localHead+1)
+    return -1, -1, fmt.Errorf("ningún par entregó una cadena de cabeceras válida")
+}
+
+// reorgFloor devuelve la altura por debajo de la cual la cadena local no se reorganiza: el
+// último punto de control, o el primer bloque completo si es mayor, porque el estado en la
+// separación se calcula con los bloques. Nunca supera la cabeza local.
+func reorgFloor(db *leveldb.DB, localHead int64, checkpoints core.Checkpoints) (int64, error) {
+    floor := int64(0)
+    if len(checkpoints) > 0 {
+        floor = checkpoints.Last()
+    }
+    base, err := core.FirstFullBlock(db)
+    if err != nil {
+        return -1, err
+    }
+    if base > floor {
+        floor = base
+    }
+    if floor > localHead {
+        floor = localHead
+    }
+    return floor, nil
+}
+
+// forkPoint devuelve la altura del último bloque que la cadena local comparte con la de un par,
+// buscando hacia atrás desde la cabeza local sin bajar de floor. Casi siempre el par sigue a la
+// cabeza local y basta con pedirle esa cabecera. Las cabeceras del par todavía no están
+// verificadas: solo se usan para elegir desde dónde pedir su cadena, que se verifica después.
+func forkPoint(ctx context.Context, h host.Host, db *leveldb.DB, id peer.ID, localHead, floor int64) (int64, error) {
+    if localHead < 0 {
+        return -1, nil
+    }
+
+    end := localHead
+    count := int64(1)
+    for {
+        start := end - count + 1
+        if start < floor {
+            start = floor
+        }
+        response, err := requestSync(ctx, h, id, SyncRequest{Type: SyncRequestHeaders, From: start, Count: end - start + 1})
+        if err != nil {
+            return -1, err
+        }
+        if int64(len(response.Headers)) != end-start+1 {
+            return -1, fmt.Errorf("entregó %d cabeceras y se pidieron %d", len(response.Headers), end-start+1)
+        }
+
+        for i := len(response.Headers) - 1; i >= 0; i-- {
+            header := response.Headers[i]
+            if header.Index != start+int64(i) {
+                return -1, fmt.Errorf("entregó la altura %d en lugar de %d", header.Index, start+int64(i))
+            }
+            local, err := core.LoadHeader(db, header.Index)
+            if err != nil {
+                return -1, fmt.Errorf("error al cargar la cabecera %d: %v", header.Index, err)
+            }
+            if local.Hash == header.Hash {
+                return header.Index, nil
+            }
+        }
+
+        if start == floor {
+            return -1, fmt.Errorf("su cadena no comparte ningún bloque con la local desde la altura %d", floor)
+        }
+        end = start - 1
+        count = MaxHeadersPerRequest
+    }
+}
+
+// fetchHeaders pide a un par las cabeceras que siguen a prev hasta la altura head y guarda cada
+// lote verificado en el almacén de cabeceras.
+func fetchHeaders(ctx context.Context, h host.Host, db *leveldb.DB, id peer.ID, prev *common.Header, head int64, checkpoints core.Checkpoints) (int64, error) {
+    from := int64(0)
+    if prev != nil {
+        from = prev.Index + 1
+    }
+
+    for from <= head {
+        count := head - from + 1
+        if count > MaxHeadersPerRequest {
+            count = MaxHeadersPerRequest
+        }
+        response, err := requestSync(ctx, h, id, SyncRequest{Type: SyncRequestHeaders, From: from, Count: count})
+        if err != nil {
+            return -1, err
+        }
+        if len(response.Headers) == 0 {
+            return -1, fmt.Errorf("no entregó cabeceras desde la altura %d", from)
+        }
+        if int64(len(response.Headers)) > count {
+            reportPeer(id, InvalidBlock)
+            return -1, fmt.Errorf("entregó %d cabeceras y se pidieron %d", len(response.Headers), count)
+        }
+
+        // Si el par cambió de rama entre dos solicitudes sus cabeceras ya no siguen a las
+        // anteriores; no es una cadena inválida
+        if prev != nil && response.Headers[0].Index == prev.Index+1 && response.Headers[0].PrevBlock != prev.Hash {
+            return -1, fmt.Errorf("su cadena cambió de rama después de la altura %d", prev.Index)
+        }
+
+        if err := checkHeaders(prev, response.Headers, checkpoints); err != nil {
+            reportPeer(id, InvalidBlock)
+            return -1, err
+        }
+
+        batch := new(leveldb.Batch)
+        for _, header := range response.Headers {
+            data, err := json.Marshal(header)
+            if err != nil {
+                return -1, err
+            }
+            batch.Put(database.HeaderKey(header.Index), data)
+        }
+        if err := db.Write(batch, nil); err != nil {
+            return -1, fmt.Errorf("error al guardar cabeceras: %v", err)
+        }
+
+        prev = &response.Headers[len(response.Headers)-1]
+        from = prev.Index + 1
+    }
+
+    return prev.Index, nil
+}
+
+// checkHeaders comprueba que las cabeceras sigan a prev, enlazadas entre sí, con su propio hash
+// y pasando por los puntos de control. Para empezar desde el génesis prev debe ser nil.
+func checkHeaders(prev *common.Header, headers []common.Header, checkpoints core.Checkpoints) error {
+    for i := range headers {
+        header := headers[i]
+        if err := core.ValidateHeader(header, prev); err != nil {
+            return err
+        }
+        if err := checkpoints.Check(header.Index, header.Hash); err != nil {
+            return err
+        }
+        prev = &header
+    }
+    return nil
+}
+
+func localChainHead(db *leveldb.DB) (int64, error) {
+    head, err := database.LastBlockIndex(db)
+    if err == database.ErrNoBlocks {
+        return -1, nil
+    }
+    return head, err
+}
+
+// peersByHead ordena los pares de mayor a menor altura.
+func peersByHead(heads map[peer.ID]*SyncResponse) []peer.ID {
+    var ids []peer.ID
+    for id := range heads {
+        ids = append(ids, id)
+    }
+    sort.Slice(ids, func(i, j int) bool { return heads[ids[i]].Head > heads[ids[j]].Head })
+    return ids
+}
+
+type blockBatch struct {
+    from     int64
+    to       int64
+    attempts int
+}
+
+type batchResult struct {
+    batch  blockBatch
+    peer   peer.ID
+    blocks []common.Block
+    err    error
+}
+
+// fetchBlocks descarga los bloques from..to repartiendo lotes entre los pares libres y entrega
+// cada bloque a apply en orden de altura. Si un par falla o entrega un bloque inválido, el lote
+// se reintenta con otro par.
+func fetchBlocks(ctx context.Context, h host.Host, heads map[peer.ID]*SyncResponse, from, to int64, apply func(common.Block) error) error {
+    var pending []blockBatch
+    for start := from; start <= to; start += SyncBatchSize {
+        end := start + SyncBatchSize - 1
+        if end > to {
+            end = to
+        }
+        pending = append(pending, blockBatch{from: start, to: end})
+    }
+
+    idle := peersByHead(heads)
+    failures := make(map[peer.ID]int)
+    done := make(map[int64]batchResult)
+    results := make(chan batchResult, len(heads))
+    inflight := 0
+    next := from
+
+    canServe := func(id peer.ID, batch blockBatch) bool {
+        head := heads[id]
+        return head.Base <= batch.from && head.Head >= batch.from
+    }
+
+    requeue := func(batch blockBatch) error {
+        batch.attempts++
+        if batch.attempts >= MaxBatchAttempts {
+            return fmt.Errorf("no se pudieron obtener los bloques %d a %d tras %d intentos", batch.from, batch.to, batch.attempts)
+        }
+        pending = append([]blockBatch{batch}, pending...)
+        return nil
+    }
+
+    // Un par con demasiados fallos deja de recibir lotes
+    penalize := func(id peer.ID) {
+        failures[id]++
+        if failures[id] == MaxPeerFailures {
+            log.Printf("Se deja de sincronizar con %s por fallos repetidos\n", id)
+        }
+    }
+
+    for next <= to {
+        // Asignar lotes pendientes a los pares libres que puedan servirlos
+        var stillIdle []peer.ID
+        for _, id := range idle {
+            if failures[id] >= MaxPeerFailures {
+                continue
+            }
+            assigned := false
+            for i, batch := range pending {
+                if !canServe(id, batch) {
+                    continue
+                }
+                pending = append(pending[:i], pending[i+1:]...)
+                inflight++
+                assigned = true
+                go func(id peer.ID, batch blockBatch) {
+                    response, err := requestSync(ctx, h, id, SyncRequest{Type: SyncRequestBlocks, From: batch.from, Count: batch.to - batch.from + 1})
+                    result := batchResult{batch: batch, peer: id, err: err}
+                    if err == nil {
+                        result.blocks = response.Blocks
+                    }
+                    results <- result
+                }(id, batch)
+                break
+            }
+            if !assigned {
+                stillIdle = append(stillIdle, id)
+            }
+        }
+        idle = stillIdle
+
+        if inflight == 0 {
+            return fmt.Errorf("ningún par disponible puede servir los bloques desde la altura %d", next)
+        }
+
+        var result batchResult
+        select {
+        case result = <-results:
+        case <-ctx.Done():
+            // Los lotes en curso terminan solos al cancelarse sus solicitudes
+            return fmt.Errorf("sincronización interrumpida en la altura %d: %v", next-1, ctx.Err())
+        }
+        inflight--
+
+        if result.err == nil {
+            if err := checkBatch(result.batch, result.blocks); err != nil {
+                result.err = fmt.Errorf("%s %v", result.peer, err)
+                reportPeer(result.peer, InvalidBlock)
+            }
+        }
+        if result.err != nil {
+            log.Printf("Lote %d-%d fallido: %v\n", result.batch.from, result.batch.to, result.err)
+            penalize(result.peer)
+            idle = append(idle, result.peer)
+            if err := requeue(result.batch); err != nil {
+                return err
+            }
+            continue
+        }
+
+        // Si el par entregó menos bloques de los pedidos, el resto vuelve a la cola
+        last := result.blocks[len(result.blocks)-1].Index
+        if last < result.batch.to {
+            pending = append(pending, blockBatch{from: last + 1, to: result.batch.to})
+            result.batch.to = last
+        }
+        done[result.batch.from] = result
+        idle = append(idle, result.peer)
+
+        // Aplicar en orden todos los lotes consecutivos disponibles
+        for {
+            ready, ok := done[next]
+            if !ok {
+                break
+            }
+            delete(done, next)
+
+            for _, block := range ready.blocks {
+                err := fmt.Errorf("%s entregó la altura %d en lugar de %d", ready.peer, block.Index, next)
+                if block.Index == next {
+                    err = apply(block)
+                }
+                if err != nil {
+                    log.Printf("Bloque %d de %s rechazado: %v\n", next, ready.peer, err)
+                    penalize(ready.peer)
+                    retry := blockBatch{from: next, to: ready.batch.to, attempts: ready.batch.attempts}
+                    if err == errOtherBranch {
+                        // Un par de otra rama no cuenta como intento fallido del lote: tras
+                        // MaxPeerFailures bloques de otra rama deja de recibir lotes
+                        pending = append([]blockBatch{retry}, pending...)
+                        break
+                    }
+                    reportPeer(ready.peer, InvalidBlock)
+                    if err := requeue(retry); err != nil {
+                        return err
+                    }
+                    break
+                }
+                next++
+            }
+            log.Printf("Sincronizado hasta la altura %d de %d\n", next-1, to)
+        }
+    }
+
+    log.Printf("Sincronización completa hasta la altura %d\n", to)
+    return nil
+}
+
+// checkBatch comprueba que los bloques de una respuesta sean consecutivos desde el primero
+// del lote y no pasen del último. El par puede entregar menos bloques de los pedidos, pero
+// no otras alturas: el resto del lote se vuelve a pedir a partir del último entregado.
+func checkBatch(batch blockBatch, blocks []common.Block) error {
+    if len(blocks) == 0 {
+        return fmt.Errorf("no entregó bloques")
+    }
+    if int64(len(blocks)) > batch.to-batch.from+1 {
+        return fmt.Errorf("entregó %d bloques y se pidieron %d", len(blocks), batch.to-batch.from+1)
+    }
+    for i, block := range blocks {
+        if block.Index != batch.from+int64(i) {
+            return fmt.Errorf("entregó la altura %d en lugar de %d", block.Index, batch.from+int64(i))
+        }
+    }
+    return nil
+}
@@ -0,0 +1,286 @@
+package network
+
+import (
+    "path/filepath"
+    "testing"
+    "time"
+    "github.com/syndtr/goleveldb/leveldb"
+    "blockchain/common"
+    "blockchain/core"
+    "blockchain/wallet"
+)
+
+const testMnemonic = "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+
+// testChain devuelve una cadena válida de length bloques tras el génesis, cada uno con una
+// transferencia firmada desde la cuenta que recibe la emisión, y esa cuenta.
+func testChain(t *testing.T, length int) ([]common.Block, *wallet.Account) {
+    founder, err := wallet.DeriveAccount(testMnemonic, "", wallet.AddressPath(0, 0))
+    if err != nil {
+        t.Fatal(err)
+    }
+    recipient, err := wallet.DeriveAccount(testMnemonic, "", wallet.AddressPath(0, 1))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    genesis, _ := core.CreateGenesisBlock(founder.Address)
+    blocks := []common.Block{genesis}
+    validator := core.NewChainValidator(nil, nil)
+    if err := validator.Add(genesis); err != nil {
+        t.Fatal(err)
+    }
+
+    for i := 1; i <= length; i++ {
+        transaction, err := wallet.NewTransaction(founder.Address, recipient.Address, 1, 0, int64(i))
+        if err != nil {
+            t.Fatal(err)
+        }
+        if err := founder.Sign(transaction); err != nil {
+            t.Fatal(err)
+        }
+        block := nextTestBlock(blocks[len(blocks)-1], validator.State(), *transaction)
+        if err := validator.Add(block); err != nil {
+            t.Fatal(err)
+        }
+        blocks = append(blocks, block)
+    }
+    return blocks, founder
+}
+
+// nextTestBlock sella a continuación de prev un bloque con las transacciones indicadas y el
+// compromiso del estado tras prev.
+func nextTestBlock(prev common.Block, state core.State, transactions ...common.Transaction) common.Block {
+    block := common.Block{
+        Header:       common.Header{Index: prev.Index + 1, PrevBlock: prev.Hash, TimeStamp: time.Now().Unix(), StateHash: core.StateHash(state)},
+        Transactions: transactions,
+    }
+    core.SealBlock(&block)
+    return block
+}
+
+// testChainDB guarda los bloques en una base de datos temporal, con su estado, y la devuelve
+// abierta hasta el final de la prueba.
+func testChainDB(t *testing.T, blocks []common.Block) *leveldb.DB {
+    db, err := leveldb.OpenFile(filepath.Join(t.TempDir(), "chain"), nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { db.Close() })
+
+    for _, block := range blocks {
+        if err := core.SaveBlock(db, block); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if err := core.RebuildState(db); err != nil {
+        t.Fatal(err)
+    }
+    return db
+}
+
+func TestServeSyncRequest(t *testing.T) {
+    blocks, _ := testChain(t, 10)
+    db := testChainDB(t, blocks)
+    head := blocks[len(blocks)-1]
+
+    tests := []struct {
+        name    string
+        request SyncRequest
+        from    int64
+        count   int
+        code    ErrorCode
+    }{
+        {"cabeza", SyncRequest{Type: SyncRequestHead}, 0, 0, CodeOK},
+        {"cabeceras", SyncRequest{Type: SyncRequestHeaders, From: 2, Count: 3}, 2, 3, CodeOK},
+        {"cabeceras hasta la cabeza", SyncRequest{Type: SyncRequestHeaders, From: 8, Count: 100}, 8, 3, CodeOK},
+        {"cabeceras sin cantidad", SyncRequest{Type: SyncRequestHeaders}, 0, 11, CodeOK},
+        {"bloques", SyncRequest{Type: SyncRequestBlocks, From: 1, Count: 4}, 1, 4, CodeOK},
+        {"bloques hasta la cabeza", SyncRequest{Type: SyncRequestBlocks, From: 9, Count: 5}, 9, 2, CodeOK},
+        {"bloques tras la cabeza", SyncRequest{Type: SyncRequestBlocks, From: 11, Count: 5}, 11, 0, CodeOK},
+        {"tipo desconocido", SyncRequest{Type: "otro"}, 0, 0, CodeBadRequest},
+    }
+
+    for _, test := range tests {
+        response, err := serveSyncRequest(db, test.request)
+        if test.code != CodeOK {
+            if errorCode(err) != test.code {
+                t.Errorf("%s: se esperaba el código %d y se recibió %v", test.name, test.code, err)
+            }
+            continue
+        }
+        if err != nil {
+            t.Errorf("%s: %v", test.name, err)
+            continue
+        }
+        if response.Head != head.Index || response.Hash != head.Hash || response.Base != 0 {
+            t.Errorf("%s: cabeza incorrecta: %d %s base %d", test.name, response.Head, response.Hash, response.Base)
+        }
+
+        got := append([]common.Header(nil), response.Headers...)
+        for _, block := range response.Blocks {
+            if block.Hash != blocks[block.Index].Hash || len(block.Transactions) != len(blocks[block.Index].Transactions) {
+                t.Errorf("%s: el bloque %d no es el guardado", test.name, block.Index)
+            }
+            got = append(got, block.Header)
+        }
+        if len(got) != test.count {
+            t.Errorf("%s: se esperaban %d elementos y se recibieron %d", test.name, test.count, len(got))
+            continue
+        }
+        for i, header := range got {
+            if header.Index != test.from+int64(i) || header.Hash != blocks[header.Index].Hash {
+                t.Errorf("%s: posición %d: se recibió la altura %d", test.name, i, header.Index)
+            }
+        }
+    }
+
+    // Un nodo podado no entrega los cuerpos que ya no tiene, pero sí sus cabeceras
+    if _, err := core.PruneBlocks(db, 4); err != nil {
+        t.Fatal(err)
+    }
+
+    if _, err := serveSyncRequest(db, SyncRequest{Type: SyncRequestBlocks, From: 2, Count: 2}); errorCode(err) != CodePruned {
+        t.Fatalf("se esperaba el código de bloques podados y se recibió %v", err)
+    }
+    response, err := serveSyncRequest(db, SyncRequest{Type: SyncRequestHeaders, From: 0, Count: 3})
+    if err != nil || len(response.Headers) != 3 || response.Base == 0 {
+        t.Fatalf("cabeceras de un nodo podado: %v", err)
+    }
+}
+
+func TestCheckHeaders(t *testing.T) {
+    blocks, _ := testChain(t, 6)
+    headers := make([]common.Header, len(blocks))
+    for i, block := range blocks {
+        headers[i] = block.Header
+    }
+
+    // Devuelve una copia de las cabeceras desde from con la modificación indicada
+    modified := func(from int, change func([]common.Header)) []common.Header {
+        batch := append([]common.Header(nil), headers[from:]...)
+        change(batch)
+        return batch
+    }
+
+    checkpoints := core.Checkpoints{3: blocks[3].Hash}
+    wrongCheckpoint := core.Checkpoints{3: blocks[2].Hash}
+
+    tests := []struct {
+        name        string
+        prev        *common.Header
+        headers     []common.Header
+        checkpoints core.Checkpoints
+        valid       bool
+    }{
+        {"desde el génesis", nil, headers, checkpoints, true},
+        {"a continuación de la local", &headers[2], headers[3:], checkpoints, true},
+        {"sin puntos de control", nil, headers, nil, true},
+        {"no sigue a la local", &headers[1], headers[3:], nil, false},
+        {"enlace roto", nil, modified(0, func(batch []common.Header) { batch[4].PrevBlock = batch[2].Hash }), nil, false},
+        {"altura salteada", nil, modified(0, func(batch []common.Header) { batch[4].Index++ }), nil, false},
+        {"hash falso", &headers[2], modified(3, func(batch []common.Header) { batch[1].StateHash = "falso" }), nil, false},
+        {"punto de control distinto", nil, headers, wrongCheckpoint, false},
+        {"punto de control distinto tras la local", &headers[2], headers[3:], wrongCheckpoint, false},
+    }
+
+    for _, test := range tests {
+        err := checkHeaders(test.prev, test.headers, test.checkpoints)
+        if test.valid && err != nil {
+            t.Errorf("%s: se rechazó: %v", test.name, err)
+        }
+        if !test.valid && err == nil {
+            t.Errorf("%s: se aceptó", test.name)
+        }
+    }
+}
+
+func TestHeadersLimit(t *testing.T) {
+    checkpoints := core.Checkpoints{100: "a", 5000: "b"}
+
+    tests := []struct {
+        name         string
+        localHead    int64
+        checkpoints  core.Checkpoints
+        limit        int64
+        checkpointed bool
+    }{
+        {"nodo vacío", -1, checkpoints, 5000, true},
+        {"antes del último punto de control", 4000, checkpoints, 5000, true},
+        {"en el último punto de control", 5000, checkpoints, 5000 + MaxHeadersPerRequest, false},
+        {"tras el último punto de control", 6000, checkpoints, 6000 + MaxHeadersPerRequest, false},
+        {"nodo vacío sin puntos de control", -1, nil, MaxHeadersPerRequest - 1, false},
+    }
+
+    for _, test := range tests {
+        limit, checkpointed := headersLimit(test.localHead, test.checkpoints)
+        if limit != test.limit || checkpointed != test.checkpointed {
+            t.Errorf("%s: se esperaba %d %v y se obtuvo %d %v", test.name, test.limit, test.checkpointed, limit, checkpointed)
+        }
+    }
+}
+
+func TestCheckBatch(t *testing.T) {
+    blocks, _ := testChain(t, 10)
+    batch := blockBatch{from: 3, to: 6}
+
+    tests := []struct {
+        name   string
+        blocks []common.Block
+        valid  bool
+    }{
+        {"completo", blocks[3:7], true},
+        {"incompleto", blocks[3:5], true},
+        {"vacío", nil, false},
+        {"empieza antes", blocks[2:6], false},
+        {"empieza después", blocks[4:7], false},
+        {"se pasa del lote", blocks[3:8], false},
+        {"salta una altura", []common.Block{blocks[3], blocks[5]}, false},
+        {"repite una altura", []common.Block{blocks[3], blocks[3]}, false},
+    }
+
+    for _, test := range tests {
+        err := checkBatch(batch, test.blocks)
+        if test.valid && err != nil {
+            t.Errorf("%s: se rechazó: %v", test.name, err)
+        }
+        if !test.valid && err == nil {
+            t.Errorf("%s: se aceptó", test.name)
+        }
+    }
+}
+
+func TestReorgFloor(t *testing.T) {
+    blocks, _ := testChain(t, 5)
+    db := testChainDB(t, blocks)
+
+    tests := []struct {
+        name        string
+        localHead   int64
+        checkpoints core.Checkpoints
+        floor       int64
+    }{
+        {"sin puntos de control", 5, nil, 0},
+        {"por encima del último punto de control", 5, core.Checkpoints{3: blocks[3].Hash}, 3},
+        {"por debajo del último punto de control", 2, core.Checkpoints{3: blocks[3].Hash}, 2},
+        {"cadena vacía", -1, nil, -1},
+    }
+
+    for _, test := range tests {
+        floor, err := reorgFloor(db, test.localHead, test.checkpoints)
+        if err != nil {
+            t.Fatalf("%s: %v", test.name, err)
+        }
+        if floor != test.floor {
+            t.Errorf("%s: se esperaba %d y es %d", test.name, test.floor, floor)
+        }
+    }
+
+    // En un nodo podado el estado en la separación se calcula desde la altura de poda
+    if _, err := core.PruneBlocks(db, 1); err != nil {
+        t.Fatal(err)
+    }
+    if floor, err := reorgFloor(db, 5, nil); err != nil || floor != 4 {
+        t.Errorf("nodo podado: se esperaba 4 y es %d (%v)", floor, err)
+    }
+}
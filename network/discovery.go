@@ -0,0 +1,167 @@
+package network
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "time"
+    dht "github.com/libp2p/go-libp2p-kad-dht"
+    "github.com/libp2p/go-libp2p/core/host"
+    "github.com/libp2p/go-libp2p/core/network"
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/libp2p/go-libp2p/p2p/discovery/mdns"
+    drouting "github.com/libp2p/go-libp2p/p2p/discovery/routing"
+    dutil "github.com/libp2p/go-libp2p/p2p/discovery/util"
+)
+
+// DiscoveryNamespace es el nombre con el que los nodos se anuncian en la DHT y por mDNS.
+const DiscoveryNamespace = "blockchain"
+
+// DHTProtocolPrefix separa la DHT de esta red de la DHT pública de IPFS.
+const DHTProtocolPrefix = "/blockchain"
+
+// DiscoveryInterval es el tiempo entre dos búsquedas de pares en la DHT.
+const DiscoveryInterval = time.Minute
+
+// DiscoveryConfig indica de dónde obtiene pares el nodo.
+type DiscoveryConfig struct {
+    // BootstrapPeers son direcciones multiaddr completas (con /p2p/<id>) a las que conectarse al arrancar.
+    BootstrapPeers []string
+    // DHT activa la DHT Kademlia para encontrar pares más allá de los de arranque.
+    DHT bool
+    // MDNS activa el descubrimiento de pares en la red local.
+    MDNS bool
+    // LegacyEnv usa además la lista de nodos semilla del archivo .env y registra en ella este nodo.
+    LegacyEnv bool
+}
+
+// Discovery mantiene las fuentes de pares del nodo activas mientras dure ctx.
+type Discovery struct {
+    ctx      context.Context
+    host     host.Host
+    config   DiscoveryConfig
+    dht      *dht.IpfsDHT
+    mdns     mdns.Service
+    selfAddr string
+}
+
+// StartDiscovery conecta con los pares de arranque y pone en marcha la DHT y mDNS según config.
+// selfAddr es la dirección completa del nodo, que solo se usa para el archivo .env.
+func StartDiscovery(ctx context.Context, h host.Host, config DiscoveryConfig, selfAddr string) (*Discovery, error) {
+    d := &Discovery{ctx: ctx, host: h, config: config, selfAddr: selfAddr}
+
+    bootstrap := parseAddrs(config.BootstrapPeers)
+    if config.LegacyEnv {
+        UpdateSeedNodes(selfAddr)
+        bootstrap = append(bootstrap, parseAddrs(VerifySeedNodes(ctx, h, selfAddr))...)
+    }
+
+    for _, info := range bootstrap {
+        d.connect(ctx, info)
+    }
+
+    if config.DHT {
+        kad, err := dht.New(ctx, h,
+            dht.Mode(dht.ModeAutoServer),
+            dht.ProtocolPrefix(DHTProtocolPrefix),
+            dht.BootstrapPeers(bootstrap...),
+        )
+        if err != nil {
+            return nil, fmt.Errorf("error al iniciar la DHT: %v", err)
+        }
+        if err := kad.Bootstrap(ctx); err != nil {
+            kad.Close()
+            return nil, fmt.Errorf("error al arrancar la DHT: %v", err)
+        }
+        d.dht = kad
+        go d.findPeers(ctx, drouting.NewRoutingDiscovery(kad))
+    }
+
+    if config.MDNS {
+        d.mdns = mdns.NewMdnsService(h, DiscoveryNamespace, d)
+        if err := d.mdns.Start(); err != nil {
+            d.Close()
+            return nil, fmt.Errorf("error al iniciar mDNS: %v", err)
+        }
+    }
+
+    return d, nil
+}
+
+// HandlePeerFound conecta con los pares que aparecen por mDNS.
+func (d *Discovery) HandlePeerFound(info peer.AddrInfo) {
+    if info.ID == d.host.ID() {
+        return
+    }
+    d.connect(d.ctx, info)
+}
+
+func (d *Discovery) connect(ctx context.Context, info peer.AddrInfo) {
+    if d.host.Network().Connectedness(info.ID) == network.Connected {
+        return
+    }
+    ctx, cancel := context.WithTimeout(ctx, timeouts.Dial)
+    defer cancel()
+
+    if err := d.host.Connect(ctx, info); err != nil {
+        log.Printf("Fallo al conectar con %s: %v\n", info.ID, err)
+        return
+    }
+    log.Printf("Conectado con %s\n", info.ID)
+}
+
+// findPeers anuncia el nodo en la DHT y busca periódicamente otros nodos de la red.
+func (d *Discovery) findPeers(ctx context.Context, routing *drouting.RoutingDiscovery) {
+    dutil.Advertise(ctx, routing, DiscoveryNamespace)
+
+    ticker := time.NewTicker(DiscoveryInterval)
+    defer ticker.Stop()
+
+    for {
+        peers, err := routing.FindPeers(ctx, DiscoveryNamespace)
+        if err != nil {
+            log.Printf("Error al buscar pares en la DHT: %v\n", err)
+        } else {
+            for info := range peers {
+                if info.ID != d.host.ID() && len(info.Addrs) > 0 {
+                    d.connect(ctx, info)
+                }
+            }
+        }
+
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// Close detiene la DHT y mDNS y, si se usó el archivo .env, retira de él este nodo.
+func (d *Discovery) Close() {
+    if d.mdns != nil {
+        d.mdns.Close()
+    }
+    if d.dht != nil {
+        d.dht.Close()
+    }
+    if d.config.LegacyEnv {
+        RemoveNodeFromEnv(EnvFilePath, d.selfAddr)
+    }
+}
+
+func parseAddrs(addrs []string) []peer.AddrInfo {
+    var infos []peer.AddrInfo
+    for _, addr := range addrs {
+        if addr == "" {
+            continue
+        }
+        info, err := peer.AddrInfoFromString(addr)
+        if err != nil {
+            log.Printf("Dirección de nodo inválida %s: %v\n", addr, err)
+            continue
+        }
+        infos = append(infos, *info)
+    }
+    return infos
+}
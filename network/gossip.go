@@ -0,0 +1,371 @@
+package network
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "sync"
+    "time"
+    pubsub "github.com/libp2p/go-libp2p-pubsub"
+    pb "github.com/libp2p/go-libp2p-pubsub/pb"
+    "github.com/libp2p/go-libp2p/core/host"
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/syndtr/goleveldb/leveldb"
+    "blockchain/common"
+    "blockchain/core"
+    wire "blockchain/network/pb"
+)
+
+// Tópicos GossipSub por los que se propagan las transacciones y los bloques nuevos.
+const (
+    TxTopic    = "/blockchain/tx/1.0.0"
+    BlockTopic = "/blockchain/blocks/1.0.0"
+)
+
+// chainMu serializa las escrituras en la cadena local del nodo: los bloques que produce
+// el propio nodo y los que llegan por gossip.
+var chainMu sync.Mutex
+
+// ChainLock toma el mismo lock que chainMu, para quien escribe en la cadena desde fuera del
+// paquete, como el podador.
+var ChainLock sync.Locker = &chainMu
+
+// Gossip propaga transacciones y bloques por GossipSub. Los mensajes se validan antes de
+// reenviarse y se identifican por su hash, así que cada transacción o bloque se procesa una vez.
+type Gossip struct {
+    host        host.Host
+    db          *leveldb.DB
+    checkpoints core.Checkpoints
+    Mempool     *core.Mempool
+    txTopic     *pubsub.Topic
+    blockTopic  *pubsub.Topic
+
+    mu      sync.Mutex
+    syncing bool
+}
+
+// NewGossip se une a los tópicos de transacciones y bloques y empieza a procesar lo que llega.
+func NewGossip(ctx context.Context, h host.Host, db *leveldb.DB, checkpoints core.Checkpoints) (*Gossip, error) {
+    ps, err := pubsub.NewGossipSub(ctx, h, pubsub.WithMessageIdFn(gossipMessageID))
+    if err != nil {
+        return nil, fmt.Errorf("error al iniciar gossipsub: %v", err)
+    }
+
+    g := &Gossip{host: h, db: db, checkpoints: checkpoints, Mempool: core.NewMempool()}
+
+    if err := ps.RegisterTopicValidator(TxTopic, g.validateTransaction); err != nil {
+        return nil, err
+    }
+    if err := ps.RegisterTopicValidator(BlockTopic, g.validateBlock); err != nil {
+        return nil, err
+    }
+
+    g.txTopic, err = ps.Join(TxTopic)
+    if err != nil {
+        return nil, fmt.Errorf("error al unirse al tópico de transacciones: %v", err)
+    }
+    g.blockTopic, err = ps.Join(BlockTopic)
+    if err != nil {
+        return nil, fmt.Errorf("error al unirse al tópico de bloques: %v", err)
+    }
+
+    txSub, err := g.txTopic.Subscribe()
+    if err != nil {
+        return nil, err
+    }
+    blockSub, err := g.blockTopic.Subscribe()
+    if err != nil {
+        return nil, err
+    }
+
+    go g.readTransactions(ctx, txSub)
+    go g.readBlocks(ctx, blockSub)
+    go g.expireMempool(ctx)
+
+    return g, nil
+}
+
+// gossipMessageID identifica cada mensaje por el hash de la transacción o del bloque que lleva.
+func gossipMessageID(m *pb.Message) string {
+    var hash string
+    switch m.GetTopic() {
+    case TxTopic:
+        if transaction, err := decodeTransaction(m.Data); err == nil {
+            hash = transaction.Hash
+        }
+    case BlockTopic:
+        if block, err := decodeBlock(m.Data); err == nil {
+            hash = block.Hash
+        }
+    }
+    if hash == "" {
+        return pubsub.DefaultMsgIdFn(m)
+    }
+    return m.GetTopic() + "/" + hash
+}
+
+// Los mensajes de gossip llevan la transacción o el bloque en el formato de wire.proto.
+
+func encodeTransaction(transaction common.Transaction) ([]byte, error) {
+    message, err := wire.FromTransaction(transaction)
+    if err != nil {
+        return nil, err
+    }
+    return wire.Marshal(message)
+}
+
+func decodeTransaction(data []byte) (common.Transaction, error) {
+    var message wire.Transaction
+    if err := wire.Unmarshal(data, &message); err != nil {
+        return common.Transaction{}, err
+    }
+    return wire.ToTransaction(&message), nil
+}
+
+func encodeBlock(block common.Block) ([]byte, error) {
+    message, err := wire.FromBlock(block)
+    if err != nil {
+        return nil, err
+    }
+    return wire.Marshal(message)
+}
+
+func decodeBlock(data []byte) (common.Block, error) {
+    var message wire.Block
+    if err := wire.Unmarshal(data, &message); err != nil {
+        return common.Block{}, err
+    }
+    return wire.ToBlock(&message), nil
+}
+
+// PublishTransaction anuncia una transacción a la red.
+func (g *Gossip) PublishTransaction(ctx context.Context, transaction common.Transaction) error {
+    data, err := encodeTransaction(transaction)
+    if err != nil {
+        return err
+    }
+    return g.txTopic.Publish(ctx, data)
+}
+
+// PublishBlock anuncia un bloque nuevo.
+func (g *Gossip) PublishBlock(ctx context.Context, block common.Block) error {
+    data, err := encodeBlock(block)
+    if err != nil {
+        return err
+    }
+    return g.blockTopic.Publish(ctx, data)
+}
+
+func (g *Gossip) validateTransaction(ctx context.Context, from peer.ID, msg *pubsub.Message) pubsub.ValidationResult {
+    transaction, err := decodeTransaction(msg.Data)
+    if err != nil {
+        reportPeer(from, Spam)
+        return pubsub.ValidationReject
+    }
+    // Las transacciones nuevas siempre van firmadas
+    if err := common.VerifyTransactionSignature(transaction); err != nil {
+        log.Printf("Transacción con firma inválida de %s: %v\n", from, err)
+        reportPeer(from, BadSignature)
+        return pubsub.ValidationReject
+    }
+    if err := core.ValidateTransaction(transaction, core.Pending); err != nil {
+        log.Printf("Transacción inválida de %s: %v\n", from, err)
+        reportPeer(from, InvalidTransaction)
+        return pubsub.ValidationReject
+    }
+    return pubsub.ValidationAccept
+}
+
+// validateBlock solo comprueba lo que no depende de la cadena local; el enlace y el estado
+// se comprueban al aceptar el bloque.
+func (g *Gossip) validateBlock(ctx context.Context, from peer.ID, msg *pubsub.Message) pubsub.ValidationResult {
+    block, err := decodeBlock(msg.Data)
+    if err != nil {
+        reportPeer(from, Spam)
+        return pubsub.ValidationReject
+    }
+    if err := core.ValidateBlockContents(block); err != nil {
+        log.Printf("Bloque inválido de %s: %v\n", from, err)
+        reportPeer(from, InvalidBlock)
+        return pubsub.ValidationReject
+    }
+    if err := g.checkpoints.Check(block.Index, block.Hash); err != nil {
+        log.Printf("Bloque de %s fuera de los puntos de control: %v\n", from, err)
+        reportPeer(from, InvalidBlock)
+        return pubsub.ValidationReject
+    }
+    return pubsub.ValidationAccept
+}
+
+func (g *Gossip) readTransactions(ctx context.Context, sub *pubsub.Subscription) {
+    for {
+        msg, err := sub.Next(ctx)
+        if err != nil {
+            return
+        }
+        if msg.ReceivedFrom == g.host.ID() {
+            continue
+        }
+
+        transaction, err := decodeTransaction(msg.Data)
+        if err != nil {
+            continue
+        }
+
+        added, err := g.Mempool.Add(transaction)
+        if err != nil {
+            log.Printf("Transacción %s descartada: %v\n", transaction.Hash, err)
+        } else if added {
+            log.Printf("Transacción %s agregada al mempool\n", transaction.Hash)
+        }
+    }
+}
+
+// expireMempool retira periódicamente del mempool las transacciones cuyo bloque no llegó.
+func (g *Gossip) expireMempool(ctx context.Context) {
+    ticker := time.NewTicker(time.Minute)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            g.Mempool.Expire()
+        }
+    }
+}
+
+func (g *Gossip) readBlocks(ctx context.Context, sub *pubsub.Subscription) {
+    for {
+        msg, err := sub.Next(ctx)
+        if err != nil {
+            return
+        }
+        if msg.ReceivedFrom == g.host.ID() {
+            continue
+        }
+
+        block, err := decodeBlock(msg.Data)
+        if err != nil {
+            continue
+        }
+
+        if err := g.acceptBlock(ctx, block, msg.ReceivedFrom); err != nil {
+            log.Printf("Bloque %d de %s rechazado: %v\n", block.Index, msg.ReceivedFrom, err)
+        }
+    }
+}
+
+// acceptBlock incorpora a la cadena local un bloque recibido por gossip si sigue a la cabeza
+// local. La cadena que vale es la más larga: otra versión de la cabeza, de la misma altura, no
+// la reemplaza, y un bloque que sigue a otra versión de la cabeza, o que está más adelante,
+// indica que el par tiene una cadena más larga, que se sincroniza en segundo plano. Solo se
+// penaliza al par si el bloque es inválido en sí mismo, no por estar en otra rama.
+func (g *Gossip) acceptBlock(ctx context.Context, block common.Block, from peer.ID) error {
+    // La altura que anuncia el bloque no se cree hasta comprobar al menos su hash
+    if err := core.ValidateBlockContents(block); err != nil {
+        return err
+    }
+
+    chainMu.Lock()
+    defer chainMu.Unlock()
+
+    head, err := localChainHead(g.db)
+    if err != nil {
+        return err
+    }
+
+    if block.Index > head+1 {
+        log.Printf("Bloque %d por delante de la cadena local (%d), sincronizando con %s\n", block.Index, head, from)
+        g.syncFrom(ctx, from)
+        return nil
+    }
+    if block.Index < head || block.Index == 0 {
+        return nil
+    }
+
+    if block.Index == head {
+        current, err := core.LoadHeader(g.db, head)
+        if err != nil {
+            return fmt.Errorf("error al cargar la cabecera %d: %v", head, err)
+        }
+        if current.Hash == block.Hash {
+            return nil
+        }
+        return fmt.Errorf("la cadena local ya tiene otro bloque en la altura %d y la del par no es más larga", head)
+    }
+
+    parent := block.Index - 1
+    prev, err := core.LoadBlock(g.db, parent)
+    if err != nil {
+        return fmt.Errorf("error al cargar el bloque %d: %v", parent, err)
+    }
+    if block.PrevBlock != prev.Hash {
+        log.Printf("Bloque %d de %s sobre otra rama más larga, sincronizando\n", block.Index, from)
+        g.syncFrom(ctx, from)
+        return nil
+    }
+    state, err := core.StateAt(g.db, parent)
+    if err != nil {
+        return err
+    }
+
+    validator := core.NewChainValidator(prev, state)
+    if err := validator.Add(block); err != nil {
+        reportPeer(from, InvalidBlock)
+        return err
+    }
+
+    // El productor guarda una instantánea cada SnapshotInterval bloques; aquí se hace lo mismo
+    if parent > 0 && parent%core.SnapshotInterval == 0 {
+        if _, snapshot, err := core.CommitState(g.db, parent); err == nil && snapshot != nil {
+            if err := core.SaveSnapshot(g.db, snapshot); err != nil {
+                log.Printf("Error al guardar la instantánea: %v\n", err)
+            }
+        }
+    }
+
+    if err := core.SaveBlocks(g.db, []common.Block{block}, validator.State()); err != nil {
+        return fmt.Errorf("error al guardar el bloque %d: %v", block.Index, err)
+    }
+
+    g.Mempool.RemoveBlock(block)
+    noteHead(from, block.Index)
+    log.Printf("Bloque %d aceptado desde %s\n", block.Index, from)
+    return nil
+}
+
+// syncFrom sincroniza la cadena con un par en segundo plano, sin bloquear la lectura de
+// gossip. Solo corre una sincronización a la vez: los bloques que llegan mientras tanto por
+// delante de la cadena local se descartan, porque la sincronización en curso ya los trae.
+func (g *Gossip) syncFrom(ctx context.Context, from peer.ID) {
+    g.mu.Lock()
+    if g.syncing {
+        g.mu.Unlock()
+        return
+    }
+    g.syncing = true
+    g.mu.Unlock()
+
+    go func() {
+        defer func() {
+            g.mu.Lock()
+            g.syncing = false
+            g.mu.Unlock()
+        }()
+
+        // La altura del par se consulta por el protocolo de sincronización, que verifica la
+        // cadena de cabeceras antes de pedir los bloques
+        if len(queryHeads(ctx, g.host, []peer.ID{from})) == 0 {
+            return
+        }
+
+        chainMu.Lock()
+        defer chainMu.Unlock()
+        if err := SyncBlocks(ctx, g.host, g.db, []peer.ID{from}, g.checkpoints); err != nil {
+            log.Printf("Error al sincronizar con %s: %v\n", from, err)
+        }
+    }()
+}
+
@@ -0,0 +1,98 @@
+package network
+
+import (
+    "context"
+    "testing"
+    "github.com/syndtr/goleveldb/leveldb"
+    "blockchain/common"
+    "blockchain/core"
+    "blockchain/wallet"
+)
+
+func TestAcceptBlock(t *testing.T) {
+    blocks, founder := testChain(t, 4)
+    db := testChainDB(t, blocks[:4])
+    head := blocks[3]
+
+    state := func(height int) core.State {
+        validator := core.NewChainValidator(nil, nil)
+        for _, block := range blocks[:height+1] {
+            if err := validator.Add(block); err != nil {
+                t.Fatal(err)
+            }
+        }
+        return validator.State()
+    }
+
+    // Otra versión de la cabeza que deja fuera su transacción
+    shorter := nextTestBlock(blocks[2], state(2))
+
+    // Un bloque que sigue a esa otra versión: el par tiene una cadena más larga en otra rama
+    branch := nextTestBlock(shorter, state(2))
+
+    // Un bloque siguiente que repite una transacción ya confirmada
+    replay := nextTestBlock(head, state(3), head.Transactions[0])
+
+    // Un bloque con una altura falsa y un hash que no corresponde
+    forged := blocks[4]
+    forged.Index = 1000
+
+    // La cabeza tras el bloque siguiente con una transacción nueva, más adelante que la local
+    recipient, err := wallet.DeriveAccount(testMnemonic, "", wallet.AddressPath(0, 1))
+    if err != nil {
+        t.Fatal(err)
+    }
+    transaction, err := wallet.NewTransaction(founder.Address, recipient.Address, 1, 0, 5)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := founder.Sign(transaction); err != nil {
+        t.Fatal(err)
+    }
+    ahead := nextTestBlock(blocks[4], state(4), *transaction)
+
+    tests := []struct {
+        name  string
+        block common.Block
+        head  common.Block
+        valid bool
+    }{
+        {"cabeza repetida", head, head, true},
+        {"otra versión de la cabeza", shorter, head, false},
+        {"sigue a otra rama", branch, head, true},
+        {"repite una transacción", replay, head, false},
+        {"hash falso", forged, head, false},
+        {"bloque anterior", blocks[2], head, true},
+        {"por delante de la cabeza", ahead, head, true},
+        {"siguiente", blocks[4], blocks[4], true},
+        {"cabeza anterior tras avanzar", head, blocks[4], true},
+    }
+
+    g := &Gossip{db: db, Mempool: core.NewMempool()}
+    // Una sincronización en curso hace que los bloques por delante no inicien otra
+    g.syncing = true
+
+    for _, test := range tests {
+        err := g.acceptBlock(context.Background(), test.block, "")
+        if test.valid && err != nil {
+            t.Errorf("%s: se rechazó: %v", test.name, err)
+        }
+        if !test.valid && err == nil {
+            t.Errorf("%s: se aceptó", test.name)
+        }
+
+        current, err := core.LoadHeader(db, test.head.Index)
+        if err != nil || current.Hash != test.head.Hash {
+            t.Errorf("%s: la cabeza local cambió", test.name)
+        }
+        if _, err := core.LoadHeader(db, test.head.Index+1); err != leveldb.ErrNotFound {
+            t.Errorf("%s: se guardó un bloque por delante de la cabeza", test.name)
+        }
+        // Las transacciones confirmadas siguen en el índice
+        for _, transaction := range test.head.Transactions {
+            if _, height, err := core.FindTransaction(db, transaction.Hash); err != nil || height != test.head.Index {
+                t.Errorf("%s: la transacción %s dejó de estar confirmada", test.name, transaction.Hash)
+            }
+        }
+    }
+}
@@ -0,0 +1,85 @@
+package network
+
+import (
+    "crypto/rand"
+    "fmt"
+    "log"
+    "os"
+    "path/filepath"
+    "time"
+    "github.com/libp2p/go-libp2p/core/crypto"
+    "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// KeyFileName es el nombre del archivo con la clave privada del nodo dentro del directorio de datos.
+const KeyFileName = "node.key"
+
+// LoadIdentity lee la clave privada del nodo guardada en path.
+func LoadIdentity(path string) (crypto.PrivKey, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    key, err := crypto.UnmarshalPrivateKey(data)
+    if err != nil {
+        return nil, fmt.Errorf("archivo de clave inválido %s: %v", path, err)
+    }
+    return key, nil
+}
+
+// LoadOrCreateIdentity lee la clave privada del nodo o, si el archivo no existe, genera una
+// nueva y la guarda, de modo que el nodo conserva su identidad entre reinicios.
+func LoadOrCreateIdentity(path string) (crypto.PrivKey, error) {
+    key, err := LoadIdentity(path)
+    if err == nil || !os.IsNotExist(err) {
+        return key, err
+    }
+
+    key, err = generateIdentity(path)
+    if err != nil {
+        return nil, err
+    }
+
+    id, _ := peer.IDFromPrivateKey(key)
+    log.Printf("Nueva identidad de nodo %s guardada en %s\n", id, path)
+    return key, nil
+}
+
+// RotateIdentity genera una identidad nueva en path. Si ya existía una, se conserva una copia
+// con la fecha de rotación en el nombre. Devuelve el ID de la nueva identidad.
+func RotateIdentity(path string) (peer.ID, error) {
+    if _, err := os.Stat(path); err == nil {
+        backup := fmt.Sprintf("%s.%d.bak", path, time.Now().Unix())
+        if err := os.Rename(path, backup); err != nil {
+            return "", fmt.Errorf("error al respaldar la clave anterior: %v", err)
+        }
+        log.Printf("Clave anterior respaldada en %s\n", backup)
+    }
+
+    key, err := generateIdentity(path)
+    if err != nil {
+        return "", err
+    }
+    return peer.IDFromPrivateKey(key)
+}
+
+func generateIdentity(path string) (crypto.PrivKey, error) {
+    key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+    if err != nil {
+        return nil, fmt.Errorf("error al generar la clave del nodo: %v", err)
+    }
+
+    data, err := crypto.MarshalPrivateKey(key)
+    if err != nil {
+        return nil, err
+    }
+
+    if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+        return nil, fmt.Errorf("error al crear el directorio de la clave: %v", err)
+    }
+    if err := os.WriteFile(path, data, 0600); err != nil {
+        return nil, fmt.Errorf("error al guardar la clave del nodo: %v", err)
+    }
+
+    return key, nil
+}
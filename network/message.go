@@ -0,0 +1,361 @@
+package network
+
+import (
+    "context"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "math/rand"
+    "sync"
+    "time"
+    "github.com/libp2p/go-libp2p/core/host"
+    "github.com/libp2p/go-libp2p/core/network"
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/libp2p/go-libp2p/core/protocol"
+    "google.golang.org/protobuf/proto"
+    "blockchain/network/pb"
+)
+
+// Todos los protocolos de stream usan el mismo formato: cada mensaje va precedido de su
+// longitud en 4 bytes big-endian y es un pb.Envelope codificado con pb.Marshal. Cada stream
+// lleva una única solicitud y su respuesta, que repite el tipo y el ID de la solicitud.
+
+// MaxMessageSize es el tamaño máximo de un mensaje. Los mensajes más grandes se rechazan sin
+// leerlos. Las solicitudes entrantes tienen además el límite de su protocolo en StreamLimits.
+const MaxMessageSize = 64 << 20
+
+// Tipos de mensaje de cada protocolo.
+const (
+    MsgStatus          = "status"
+    MsgSync            = "sync"
+    MsgSnapshot        = "snapshot"
+    MsgCreateAccount   = "create_account"
+    MsgGetBalance      = "get_balance"
+    MsgGetTransaction  = "get_transaction"
+    MsgSendTransaction = "send_transaction"
+    MsgUser            = "user"
+)
+
+// ErrorCode clasifica los errores de una respuesta. CodeOK indica que la respuesta tuvo éxito.
+// Los valores coinciden con los de pb.ErrorCode.
+type ErrorCode int
+
+const (
+    CodeOK ErrorCode = iota
+    // CodeBadRequest indica una solicitud mal formada o de un tipo que el protocolo no admite.
+    CodeBadRequest
+    // CodeNotFound indica que no existe lo solicitado.
+    CodeNotFound
+    // CodePruned indica que el nodo ya no conserva los datos solicitados.
+    CodePruned
+    // CodeUnavailable indica que el nodo no puede atender la solicitud por ahora.
+    CodeUnavailable
+    // CodeRejected indica que la transacción enviada no es válida.
+    CodeRejected
+    // CodeIncompatible indica que el par pertenece a otra red o usa otra versión del protocolo.
+    CodeIncompatible
+    // CodeInternal indica un fallo del nodo al atender la solicitud.
+    CodeInternal
+    // CodeRateLimited indica que el par superó los límites de solicitudes del protocolo.
+    CodeRateLimited
+    // CodeTimeout indica que la solicitud no se completó en su plazo o se interrumpió porque
+    // alguno de los nodos se estaba cerrando.
+    CodeTimeout
+)
+
+var errorCodeNames = map[ErrorCode]string{
+    CodeOK:           "ok",
+    CodeBadRequest:   "solicitud inválida",
+    CodeNotFound:     "no encontrado",
+    CodePruned:       "podado",
+    CodeUnavailable:  "no disponible",
+    CodeRejected:     "rechazado",
+    CodeIncompatible: "incompatible",
+    CodeInternal:     "error interno",
+    CodeRateLimited:  "límite superado",
+    CodeTimeout:      "tiempo agotado",
+}
+
+func (code ErrorCode) String() string {
+    if name, ok := errorCodeNames[code]; ok {
+        return name
+    }
+    return fmt.Sprintf("código %d", int(code))
+}
+
+// Message es el sobre de todas las solicitudes y respuestas. Payload contiene el cuerpo propio
+// de cada tipo de mensaje, ya convertido al esquema de pb, y solo se envía si Code es CodeOK.
+type Message struct {
+    Type      string
+    RequestID uint64
+    Code      ErrorCode
+    Error     string
+    Payload   proto.Message
+}
+
+// ProtocolError es un error estructurado devuelto por el nodo remoto o por un manejador, o un
+// CodeTimeout cuando la solicitud no se completó a tiempo.
+type ProtocolError struct {
+    Code    ErrorCode
+    Message string
+}
+
+func (e *ProtocolError) Error() string {
+    return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// protocolErrorf crea un ProtocolError con el código indicado.
+func protocolErrorf(code ErrorCode, format string, args ...interface{}) *ProtocolError {
+    return &ProtocolError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// errorCode devuelve el código de err, o CodeInternal si no es un ProtocolError.
+func errorCode(err error) ErrorCode {
+    if perr, ok := err.(*ProtocolError); ok {
+        return perr.Code
+    }
+    return CodeInternal
+}
+
+// NewRequest crea una solicitud del tipo indicado con un ID nuevo.
+func NewRequest(msgType string, payload interface{}) (*Message, error) {
+    msg := &Message{Type: msgType, RequestID: rand.Uint64()}
+    if payload != nil {
+        body, err := toWire(payload)
+        if err != nil {
+            return nil, fmt.Errorf("error al codificar la solicitud: %v", err)
+        }
+        msg.Payload = body
+    }
+    return msg, nil
+}
+
+// Decode decodifica el cuerpo del mensaje en v.
+func (msg *Message) Decode(v interface{}) error {
+    if msg.Payload == nil {
+        return fmt.Errorf("mensaje %s sin contenido", msg.Type)
+    }
+    if err := fromWire(msg.Payload, v); err != nil {
+        return fmt.Errorf("contenido inválido en mensaje %s: %v", msg.Type, err)
+    }
+    return nil
+}
+
+// WriteMessage escribe msg precedido de su longitud.
+func WriteMessage(w io.Writer, msg *Message) error {
+    envelope := &pb.Envelope{
+        Type:      msg.Type,
+        RequestId: msg.RequestID,
+        Code:      pb.ErrorCode(msg.Code),
+        Error:     msg.Error,
+    }
+    if err := setBody(envelope, msg.Payload); err != nil {
+        return err
+    }
+
+    data, err := pb.Marshal(envelope)
+    if err != nil {
+        return fmt.Errorf("error al codificar el mensaje: %v", err)
+    }
+    if len(data) > MaxMessageSize {
+        return fmt.Errorf("mensaje de %d bytes supera el máximo de %d", len(data), MaxMessageSize)
+    }
+
+    frame := make([]byte, 4+len(data))
+    binary.BigEndian.PutUint32(frame, uint32(len(data)))
+    copy(frame[4:], data)
+
+    _, err = w.Write(frame)
+    return err
+}
+
+// ErrMessageTooLarge indica que un mensaje supera el tamaño máximo permitido.
+var ErrMessageTooLarge = errors.New("mensaje demasiado grande")
+
+// ReadMessage lee un mensaje escrito con WriteMessage.
+func ReadMessage(r io.Reader) (*Message, error) {
+    return readMessage(r, MaxMessageSize)
+}
+
+// readMessage lee un mensaje de hasta maxSize bytes. Los mensajes más grandes se rechazan sin leerlos.
+func readMessage(r io.Reader, maxSize int) (*Message, error) {
+    var prefix [4]byte
+    if _, err := io.ReadFull(r, prefix[:]); err != nil {
+        return nil, err
+    }
+
+    size := binary.BigEndian.Uint32(prefix[:])
+    if int64(size) > int64(maxSize) {
+        return nil, ErrMessageTooLarge
+    }
+
+    data := make([]byte, size)
+    if _, err := io.ReadFull(r, data); err != nil {
+        return nil, err
+    }
+
+    var envelope pb.Envelope
+    if err := pb.Unmarshal(data, &envelope); err != nil {
+        return nil, fmt.Errorf("mensaje mal formado: %v", err)
+    }
+
+    return &Message{
+        Type:      envelope.Type,
+        RequestID: envelope.RequestId,
+        Code:      ErrorCode(envelope.Code),
+        Error:     envelope.Error,
+        Payload:   getBody(&envelope),
+    }, nil
+}
+
+// respond envía la respuesta a request: result si err es nil o el error estructurado si no.
+func respond(s network.Stream, request *Message, result interface{}, err error) error {
+    response := &Message{Type: request.Type, RequestID: request.RequestID}
+
+    if err == nil && result != nil {
+        response.Payload, err = toWire(result)
+    }
+    if err != nil {
+        response.Code = errorCode(err)
+        response.Error = err.Error()
+        if perr, ok := err.(*ProtocolError); ok {
+            response.Error = perr.Message
+        }
+        response.Payload = nil
+    }
+
+    return WriteMessage(s, response)
+}
+
+// activeStreams cuenta los streams entrantes que todavía no se han respondido.
+var activeStreams sync.WaitGroup
+
+// WaitStreams espera hasta timeout a que se respondan los streams entrantes en curso. Se llama
+// al cerrar el nodo, después de cancelar su contexto, para que cada par reciba su respuesta antes
+// de que se cierre el host. Devuelve false si venció el plazo.
+func WaitStreams(timeout time.Duration) bool {
+    done := make(chan struct{})
+    go func() {
+        activeStreams.Wait()
+        close(done)
+    }()
+
+    select {
+    case <-done:
+        return true
+    case <-time.After(timeout):
+        return false
+    }
+}
+
+// serveStream atiende la única solicitud de un stream. Comprueba que sea del tipo msgType,
+// llama a handle y responde con lo que devuelva. El contexto de handle se cancela al cerrarse
+// el nodo o al vencer el plazo del protocolo; en ese caso se responde con CodeTimeout sin
+// esperar a handle, aunque la operación puede llegar a completarse.
+func serveStream(ctx context.Context, s network.Stream, msgType string, handle func(ctx context.Context, request *Message) (interface{}, error)) {
+    activeStreams.Add(1)
+    defer activeStreams.Done()
+    defer s.Close()
+
+    remote := s.Conn().RemotePeer()
+
+    request, release, err := readRequest(s)
+    if err != nil {
+        log.Printf("Solicitud de %s a %s descartada: %v\n", remote, s.Protocol(), err)
+        return
+    }
+
+    if request.Type != msgType {
+        release()
+        respond(s, request, nil, protocolErrorf(CodeBadRequest, "tipo de mensaje inesperado: %s", request.Type))
+        return
+    }
+
+    ctx, cancel := context.WithTimeout(ctx, streamLimit(string(s.Protocol())).Timeout)
+    defer cancel()
+
+    type handled struct {
+        result interface{}
+        err    error
+    }
+    done := make(chan handled, 1)
+    go func() {
+        defer release()
+        result, err := handle(ctx, request)
+        done <- handled{result, err}
+    }()
+
+    var result interface{}
+    select {
+    case h := <-done:
+        result, err = h.result, h.err
+    case <-ctx.Done():
+        err = protocolErrorf(CodeTimeout, "solicitud %s interrumpida: %v", request.Type, ctx.Err())
+    }
+    if err != nil {
+        log.Printf("Solicitud %s de %s fallida: %v\n", request.Type, remote, err)
+    }
+
+    if err := respond(s, request, result, err); err != nil {
+        log.Printf("Error al responder a %s: %v\n", remote, err)
+    }
+}
+
+// SendRequest abre un stream con el par, envía una solicitud y decodifica la respuesta en
+// response, que puede ser nil. Si el par responde con un error se devuelve un *ProtocolError.
+// Si ctx no tiene plazo se aplica Timeouts.Request; cuando vence el plazo o se cancela ctx el
+// stream se cierra y se devuelve un *ProtocolError con CodeTimeout.
+func SendRequest(ctx context.Context, h host.Host, id peer.ID, protocolID string, msgType string, request, response interface{}) error {
+    if _, ok := ctx.Deadline(); !ok {
+        var cancel context.CancelFunc
+        ctx, cancel = context.WithTimeout(ctx, timeouts.Request)
+        defer cancel()
+    }
+
+    s, err := h.NewStream(ctx, id, protocol.ID(protocolID))
+    if err != nil {
+        return timeoutError(ctx, fmt.Errorf("error al abrir stream con %s: %v", id, err))
+    }
+    defer s.Close()
+    deadline, _ := ctx.Deadline()
+    s.SetDeadline(deadline)
+
+    // Al cancelarse ctx se interrumpen la escritura y la lectura pendientes
+    stop := context.AfterFunc(ctx, func() { s.Reset() })
+    defer stop()
+
+    msg, err := NewRequest(msgType, request)
+    if err != nil {
+        return err
+    }
+    if err := WriteMessage(s, msg); err != nil {
+        return timeoutError(ctx, fmt.Errorf("error al enviar solicitud a %s: %v", id, err))
+    }
+    s.CloseWrite()
+
+    reply, err := ReadMessage(s)
+    if err != nil {
+        return timeoutError(ctx, fmt.Errorf("error al leer respuesta de %s: %v", id, err))
+    }
+    if reply.RequestID != msg.RequestID || reply.Type != msg.Type {
+        return fmt.Errorf("respuesta de %s no corresponde a la solicitud", id)
+    }
+    if reply.Code != CodeOK {
+        return &ProtocolError{Code: reply.Code, Message: reply.Error}
+    }
+
+    if response != nil {
+        return reply.Decode(response)
+    }
+    return nil
+}
+
+// timeoutError convierte err en un error CodeTimeout si ctx venció o fue cancelado.
+func timeoutError(ctx context.Context, err error) error {
+    if ctx.Err() != nil {
+        return protocolErrorf(CodeTimeout, "%v (%v)", err, ctx.Err())
+    }
+    return err
+}
@@ -0,0 +1,275 @@
+package pb
+
+import (
+    "encoding/hex"
+    "fmt"
+    "github.com/tyler-smith/go-bip32"
+    "google.golang.org/protobuf/encoding/protojson"
+    "google.golang.org/protobuf/proto"
+    "blockchain/common"
+)
+
+// DebugJSON hace que Marshal escriba JSON legible en lugar del formato binario, para inspeccionar
+// el tráfico. Unmarshal acepta los dos formatos, así que los nodos en modo de depuración siguen
+// entendiéndose con los demás.
+var DebugJSON bool
+
+// Marshal codifica m en binario o, con DebugJSON, en JSON.
+func Marshal(m proto.Message) ([]byte, error) {
+    if DebugJSON {
+        return protojson.Marshal(m)
+    }
+    return proto.Marshal(m)
+}
+
+// Unmarshal decodifica m desde cualquiera de los dos formatos. Ningún mensaje binario de este
+// esquema empieza por '{', que en JSON abre siempre el objeto.
+func Unmarshal(data []byte, m proto.Message) error {
+    if len(data) > 0 && data[0] == '{' {
+        return protojson.Unmarshal(data, m)
+    }
+    return proto.Unmarshal(data, m)
+}
+
+// DecodeHash convierte un hash hexadecimal en bytes. Solo se aceptan hashes en minúsculas para
+// que la conversión inversa devuelva exactamente el mismo texto.
+func DecodeHash(s string) ([]byte, error) {
+    if s == "" {
+        return nil, nil
+    }
+    b, err := hex.DecodeString(s)
+    if err != nil || hex.EncodeToString(b) != s {
+        return nil, fmt.Errorf("hash no hexadecimal: %q", s)
+    }
+    return b, nil
+}
+
+// EncodeHash es la inversa de DecodeHash.
+func EncodeHash(b []byte) string {
+    if len(b) == 0 {
+        return ""
+    }
+    return hex.EncodeToString(b)
+}
+
+func FromHeader(header common.Header) (*Header, error) {
+    out := &Header{Index: header.Index, Timestamp: header.TimeStamp, Nonce: header.Nonce}
+
+    var err error
+    if out.PrevBlock, err = DecodeHash(header.PrevBlock); err != nil {
+        return nil, fmt.Errorf("cabecera %d: %v", header.Index, err)
+    }
+    if out.Hash, err = DecodeHash(header.Hash); err != nil {
+        return nil, fmt.Errorf("cabecera %d: %v", header.Index, err)
+    }
+    if out.StateHash, err = DecodeHash(header.StateHash); err != nil {
+        return nil, fmt.Errorf("cabecera %d: %v", header.Index, err)
+    }
+    if out.TxRoot, err = DecodeHash(header.TxRoot); err != nil {
+        return nil, fmt.Errorf("cabecera %d: %v", header.Index, err)
+    }
+    return out, nil
+}
+
+func ToHeader(header *Header) common.Header {
+    return common.Header{
+        Index:     header.GetIndex(),
+        PrevBlock: EncodeHash(header.GetPrevBlock()),
+        Hash:      EncodeHash(header.GetHash()),
+        TimeStamp: header.GetTimestamp(),
+        Nonce:     header.GetNonce(),
+        StateHash: EncodeHash(header.GetStateHash()),
+        TxRoot:    EncodeHash(header.GetTxRoot()),
+    }
+}
+
+func FromTransaction(tx common.Transaction) (*Transaction, error) {
+    hash, err := DecodeHash(tx.Hash)
+    if err != nil {
+        return nil, fmt.Errorf("transacción: %v", err)
+    }
+    publicKey, err := DecodeHash(tx.PublicKey)
+    if err != nil {
+        return nil, fmt.Errorf("transacción %s: clave pública: %v", tx.Hash, err)
+    }
+
+    out := &Transaction{
+        Index:     tx.Index,
+        Sender:    tx.Sender,
+        Recipient: tx.Recipient,
+        Amount:    tx.Ammount,
+        Timestamp: tx.TimeStamp,
+        Hash:      hash,
+        PublicKey: publicKey,
+        Nonce:     tx.Nonce,
+        Fee:       tx.Fee,
+    }
+    if signature, err := DecodeHash(tx.Signature); err == nil && signature != nil {
+        out.Signature = &Transaction_SignatureBytes{SignatureBytes: signature}
+    } else if tx.Signature != "" {
+        out.Signature = &Transaction_SignatureText{SignatureText: tx.Signature}
+    }
+
+    if tx.Multisig != nil {
+        out.Multisig = &Multisig{Threshold: uint32(tx.Multisig.Threshold)}
+        for _, key := range tx.Multisig.PublicKeys {
+            keyBytes, err := DecodeHash(key)
+            if err != nil {
+                return nil, fmt.Errorf("transacción %s: clave de la multifirma: %v", tx.Hash, err)
+            }
+            out.Multisig.PublicKeys = append(out.Multisig.PublicKeys, keyBytes)
+        }
+    }
+    // Las posiciones sin firma viajan vacías para conservar el orden
+    for _, signature := range tx.Signatures {
+        signatureBytes, err := DecodeHash(signature)
+        if err != nil {
+            return nil, fmt.Errorf("transacción %s: firma: %v", tx.Hash, err)
+        }
+        out.Signatures = append(out.Signatures, signatureBytes)
+    }
+    return out, nil
+}
+
+func ToTransaction(tx *Transaction) common.Transaction {
+    out := common.Transaction{
+        Index:     tx.GetIndex(),
+        Sender:    tx.GetSender(),
+        Recipient: tx.GetRecipient(),
+        Ammount:   tx.GetAmount(),
+        TimeStamp: tx.GetTimestamp(),
+        Hash:      EncodeHash(tx.GetHash()),
+        PublicKey: EncodeHash(tx.GetPublicKey()),
+        Nonce:     tx.GetNonce(),
+        Fee:       tx.GetFee(),
+    }
+    if signature := tx.GetSignatureBytes(); signature != nil {
+        out.Signature = EncodeHash(signature)
+    } else {
+        out.Signature = tx.GetSignatureText()
+    }
+
+    if multisig := tx.GetMultisig(); multisig != nil {
+        out.Multisig = &common.Multisig{Threshold: int(multisig.GetThreshold())}
+        for _, key := range multisig.GetPublicKeys() {
+            out.Multisig.PublicKeys = append(out.Multisig.PublicKeys, EncodeHash(key))
+        }
+    }
+    for _, signature := range tx.GetSignatures() {
+        out.Signatures = append(out.Signatures, EncodeHash(signature))
+    }
+    return out
+}
+
+func FromBlock(block common.Block) (*Block, error) {
+    header, err := FromHeader(block.Header)
+    if err != nil {
+        return nil, err
+    }
+
+    out := &Block{Header: header}
+    for _, tx := range block.Transactions {
+        converted, err := FromTransaction(tx)
+        if err != nil {
+            return nil, fmt.Errorf("bloque %d: %v", block.Index, err)
+        }
+        out.Transactions = append(out.Transactions, converted)
+    }
+    return out, nil
+}
+
+func ToBlock(block *Block) common.Block {
+    out := common.Block{Header: ToHeader(block.GetHeader())}
+    for _, tx := range block.GetTransactions() {
+        out.Transactions = append(out.Transactions, ToTransaction(tx))
+    }
+    return out
+}
+
+func FromHeaders(headers []common.Header) ([]*Header, error) {
+    var out []*Header
+    for _, header := range headers {
+        converted, err := FromHeader(header)
+        if err != nil {
+            return nil, err
+        }
+        out = append(out, converted)
+    }
+    return out, nil
+}
+
+func ToHeaders(headers []*Header) []common.Header {
+    var out []common.Header
+    for _, header := range headers {
+        out = append(out, ToHeader(header))
+    }
+    return out
+}
+
+func FromBlocks(blocks []common.Block) ([]*Block, error) {
+    var out []*Block
+    for _, block := range blocks {
+        converted, err := FromBlock(block)
+        if err != nil {
+            return nil, err
+        }
+        out = append(out, converted)
+    }
+    return out, nil
+}
+
+func ToBlocks(blocks []*Block) []common.Block {
+    var out []common.Block
+    for _, block := range blocks {
+        out = append(out, ToBlock(block))
+    }
+    return out
+}
+
+func FromSnapshot(snapshot common.Snapshot) (*Snapshot, error) {
+    out := &Snapshot{Height: snapshot.Height}
+
+    var err error
+    if out.BlockHash, err = DecodeHash(snapshot.BlockHash); err != nil {
+        return nil, fmt.Errorf("instantánea %d: %v", snapshot.Height, err)
+    }
+    if out.StateHash, err = DecodeHash(snapshot.StateHash); err != nil {
+        return nil, fmt.Errorf("instantánea %d: %v", snapshot.Height, err)
+    }
+    for _, account := range snapshot.Accounts {
+        out.Accounts = append(out.Accounts, &Account{Address: account.Address, Balance: account.Balance, Nonce: account.Nonce})
+    }
+    return out, nil
+}
+
+func ToSnapshot(snapshot *Snapshot) common.Snapshot {
+    out := common.Snapshot{
+        Height:    snapshot.GetHeight(),
+        BlockHash: EncodeHash(snapshot.GetBlockHash()),
+        StateHash: EncodeHash(snapshot.GetStateHash()),
+    }
+    for _, account := range snapshot.GetAccounts() {
+        out.Accounts = append(out.Accounts, common.Account{Address: account.GetAddress(), Balance: account.GetBalance(), Nonce: account.GetNonce()})
+    }
+    return out
+}
+
+func FromUser(user common.User) *User {
+    out := &User{Address: user.Address, Balance: user.Balance, Nonce: user.Nonce}
+    if user.PublicKey != nil {
+        out.PublicKey = user.PublicKey.String()
+    }
+    return out
+}
+
+func ToUser(user *User) (common.User, error) {
+    out := common.User{Address: user.GetAddress(), Balance: user.GetBalance(), Nonce: user.GetNonce()}
+
+    var err error
+    if user.GetPublicKey() != "" {
+        if out.PublicKey, err = bip32.B58Deserialize(user.GetPublicKey()); err != nil {
+            return out, fmt.Errorf("clave pública inválida: %v", err)
+        }
+    }
+    return out, nil
+}
@@ -0,0 +1,177 @@
+package pb
+
+import (
+    "reflect"
+    "testing"
+    "github.com/tyler-smith/go-bip32"
+    "google.golang.org/protobuf/proto"
+    "blockchain/common"
+)
+
+const (
+    testHash  = "9d84ec3d881ce07c64476d217abc1c2f23599e1fc9014d996a331d442f8a4de0"
+    testHash2 = "0a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f60718293a4b5c6d7e8f9"
+)
+
+func testHeader() common.Header {
+    return common.Header{
+        Index:     7,
+        PrevBlock: testHash,
+        Hash:      testHash2,
+        TimeStamp: 1700000000,
+        Nonce:     3,
+        StateHash: testHash,
+        TxRoot:    testHash2,
+    }
+}
+
+func testTransactions() []common.Transaction {
+    return []common.Transaction{
+        {Index: 0, Sender: "a", Recipient: "b", Ammount: 1.5, Signature: testHash, TimeStamp: 1700000001, Hash: testHash2},
+        // Las firmas que no son hexadecimales viajan como texto
+        {Index: 1, Sender: "a", Recipient: "b", Ammount: 2, Signature: "OSCURT", TimeStamp: 1700000002, Hash: testHash},
+        {Index: 2, Sender: "a", Recipient: "b", Ammount: 3, Signature: "ABCD", TimeStamp: 1700000003, Hash: testHash},
+        {Index: 3, Sender: "a", Recipient: "b", Ammount: 4, TimeStamp: 1700000004, Hash: testHash},
+        {Index: 4, Sender: "a", Recipient: "b", Ammount: 5, Signature: testHash2, TimeStamp: 1700000005, Hash: testHash, PublicKey: "02" + testHash, Nonce: 4, Fee: 0.25},
+        // Multifirma con la segunda posición sin firmar
+        {Index: 5, Sender: "a", Recipient: "b", Ammount: 6, TimeStamp: 1700000006, Hash: testHash,
+            Multisig: &common.Multisig{Threshold: 1, PublicKeys: []string{"02" + testHash, "03" + testHash}}, Signatures: []string{testHash2, ""}},
+    }
+}
+
+// roundTrip codifica m en binario y en JSON y devuelve las dos copias decodificadas.
+func roundTrip(t *testing.T, m proto.Message) []proto.Message {
+    t.Helper()
+
+    defer func(debug bool) { DebugJSON = debug }(DebugJSON)
+
+    var out []proto.Message
+    for _, debug := range []bool{false, true} {
+        DebugJSON = debug
+        data, err := Marshal(m)
+        if err != nil {
+            t.Fatalf("error al codificar (JSON=%v): %v", debug, err)
+        }
+        decoded := m.ProtoReflect().New().Interface()
+        if err := Unmarshal(data, decoded); err != nil {
+            t.Fatalf("error al decodificar (JSON=%v): %v", debug, err)
+        }
+        if !proto.Equal(m, decoded) {
+            t.Fatalf("el mensaje cambió al codificarlo (JSON=%v):\n%v\n%v", debug, m, decoded)
+        }
+        out = append(out, decoded)
+    }
+    return out
+}
+
+func TestHeaderRoundTrip(t *testing.T) {
+    header := testHeader()
+
+    message, err := FromHeader(header)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, decoded := range roundTrip(t, message) {
+        if got := ToHeader(decoded.(*Header)); got != header {
+            t.Fatalf("cabecera distinta:\n%+v\n%+v", header, got)
+        }
+    }
+}
+
+func TestTransactionRoundTrip(t *testing.T) {
+    for _, tx := range testTransactions() {
+        message, err := FromTransaction(tx)
+        if err != nil {
+            t.Fatal(err)
+        }
+        for _, decoded := range roundTrip(t, message) {
+            if got := ToTransaction(decoded.(*Transaction)); !reflect.DeepEqual(got, tx) {
+                t.Fatalf("transacción distinta:\n%+v\n%+v", tx, got)
+            }
+        }
+    }
+}
+
+func TestBlockRoundTrip(t *testing.T) {
+    block := common.Block{Header: testHeader(), Transactions: testTransactions()}
+
+    message, err := FromBlock(block)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, decoded := range roundTrip(t, message) {
+        if got := ToBlock(decoded.(*Block)); !reflect.DeepEqual(got, block) {
+            t.Fatalf("bloque distinto:\n%+v\n%+v", block, got)
+        }
+    }
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+    snapshot := common.Snapshot{
+        Height:    100,
+        BlockHash: testHash,
+        StateHash: testHash2,
+        Accounts: []common.Account{
+            {Address: "a", Balance: 10, Nonce: 1},
+            {Address: "b", Balance: 0.25, Nonce: 0},
+        },
+    }
+
+    message, err := FromSnapshot(snapshot)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, decoded := range roundTrip(t, message) {
+        if got := ToSnapshot(decoded.(*Snapshot)); !reflect.DeepEqual(got, snapshot) {
+            t.Fatalf("instantánea distinta:\n%+v\n%+v", snapshot, got)
+        }
+    }
+}
+
+func TestUserRoundTrip(t *testing.T) {
+    seed := make([]byte, 32)
+    for i := range seed {
+        seed[i] = byte(i)
+    }
+    privateKey, err := bip32.NewMasterKey(seed)
+    if err != nil {
+        t.Fatal(err)
+    }
+    user := common.User{
+        PublicKey: privateKey.PublicKey(),
+        Address:   "direccion",
+        Balance:   42,
+        Nonce:     2,
+    }
+
+    for _, decoded := range roundTrip(t, FromUser(user)) {
+        got, err := ToUser(decoded.(*User))
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !reflect.DeepEqual(got, user) {
+            t.Fatalf("usuario distinto:\n%+v\n%+v", user, got)
+        }
+    }
+}
+
+func TestInvalidHashRejected(t *testing.T) {
+    header := testHeader()
+    header.Hash = "no es hex"
+    if _, err := FromHeader(header); err == nil {
+        t.Fatal("se aceptó un hash no hexadecimal")
+    }
+
+    // Un hash en mayúsculas no volvería igual, así que también se rechaza
+    header.Hash = "ABCD"
+    if _, err := FromHeader(header); err == nil {
+        t.Fatal("se aceptó un hash en mayúsculas")
+    }
+}
+
+// TestPrivateKeyNotSent comprueba que una clave privada no pueda viajar en un usuario.
+func TestPrivateKeyNotSent(t *testing.T) {
+    if fields := (&User{}).ProtoReflect().Descriptor().Fields(); fields.ByName("private_key") != nil {
+        t.Fatal("el esquema de usuario todavía tiene un campo para la clave privada")
+    }
+}
@@ -0,0 +1,343 @@
+package network
+
+import (
+    "context"
+    "encoding/json"
+    "log"
+    "os"
+    "sort"
+    "sync"
+    "time"
+    "github.com/libp2p/go-libp2p/core/control"
+    "github.com/libp2p/go-libp2p/core/host"
+    "github.com/libp2p/go-libp2p/core/network"
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/multiformats/go-multiaddr"
+)
+
+// Misbehavior es un motivo por el que se penaliza a un par.
+type Misbehavior string
+
+const (
+    InvalidBlock       Misbehavior = "bloque inválido"
+    InvalidTransaction Misbehavior = "transacción inválida"
+    BadSignature       Misbehavior = "firma inválida"
+    Spam               Misbehavior = "spam"
+    Timeout            Misbehavior = "tiempo de espera agotado"
+)
+
+// penalties es lo que resta cada motivo a la puntuación de un par.
+var penalties = map[Misbehavior]int{
+    InvalidBlock:       50,
+    InvalidTransaction: 20,
+    BadSignature:       50,
+    Spam:               10,
+    Timeout:            5,
+}
+
+// BanThreshold es la puntuación por debajo de la cual un par queda vetado.
+const BanThreshold = -100
+
+// BanDuration es lo que dura un veto.
+const BanDuration = time.Hour
+
+// PeerMaintenanceInterval es el tiempo entre dos revisiones de la tabla de pares. En cada una
+// las puntuaciones negativas se recuperan un punto, expiran los vetos vencidos y se ajusta la
+// cantidad de conexiones a los límites.
+const PeerMaintenanceInterval = 30 * time.Second
+
+// PeerConfig define los límites del gestor de pares.
+type PeerConfig struct {
+    MinPeers int
+    MaxPeers int
+    // BanFile es el archivo donde se guardan los vetos para que sobrevivan a un reinicio.
+    BanFile string
+}
+
+// PeerStatus es una fila de la tabla de pares.
+type PeerStatus struct {
+    ID          peer.ID
+    Score       int
+    Connected   bool
+    Addrs       []string   `json:",omitempty"`
+    LastReason  string     `json:",omitempty"`
+    BannedUntil *time.Time `json:",omitempty"`
+}
+
+type peerRecord struct {
+    score      int
+    lastReason Misbehavior
+}
+
+// PeerManager puntúa a los pares según su comportamiento, veta temporalmente a los que bajan
+// del umbral y mantiene la cantidad de conexiones entre MinPeers y MaxPeers. Se registra como
+// ConnectionGater del host para rechazar las conexiones de los pares vetados.
+type PeerManager struct {
+    config PeerConfig
+    host   host.Host
+
+    mu      sync.Mutex
+    records map[peer.ID]*peerRecord
+    bans    map[peer.ID]time.Time
+}
+
+// peerManager es el gestor de pares activo, al que los protocolos informan de las faltas.
+var peerManager *PeerManager
+
+// NewPeerManager crea el gestor de pares y carga los vetos guardados. Se debe pasar al host con
+// libp2p.ConnectionGater y después llamar a Start.
+func NewPeerManager(config PeerConfig) *PeerManager {
+    pm := &PeerManager{
+        config:  config,
+        records: make(map[peer.ID]*peerRecord),
+        bans:    make(map[peer.ID]time.Time),
+    }
+
+    if config.BanFile != "" {
+        data, err := os.ReadFile(config.BanFile)
+        if err == nil {
+            if err := json.Unmarshal(data, &pm.bans); err != nil {
+                log.Printf("Archivo de vetos inválido %s: %v\n", config.BanFile, err)
+            }
+        } else if !os.IsNotExist(err) {
+            log.Printf("Error al leer los vetos: %v\n", err)
+        }
+    }
+
+    return pm
+}
+
+// Start asocia el gestor al host y lo mantiene activo hasta que se cancela ctx.
+func (pm *PeerManager) Start(ctx context.Context, h host.Host) {
+    pm.mu.Lock()
+    pm.host = h
+    pm.mu.Unlock()
+
+    peerManager = pm
+
+    go func() {
+        ticker := time.NewTicker(PeerMaintenanceInterval)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-ctx.Done():
+                return
+            case <-ticker.C:
+                pm.maintain(ctx)
+            }
+        }
+    }()
+}
+
+// reportPeer informa de una falta al gestor de pares activo, si lo hay.
+func reportPeer(id peer.ID, reason Misbehavior) {
+    if peerManager != nil {
+        peerManager.Penalize(id, reason)
+    }
+}
+
+// Penalize resta a la puntuación del par la penalización del motivo y lo veta si baja del umbral.
+func (pm *PeerManager) Penalize(id peer.ID, reason Misbehavior) {
+    pm.mu.Lock()
+    record := pm.record(id)
+    record.score -= penalties[reason]
+    record.lastReason = reason
+    score := record.score
+    pm.mu.Unlock()
+
+    log.Printf("Par %s penalizado por %s (puntuación %d)\n", id, reason, score)
+    if score <= BanThreshold {
+        pm.Ban(id, BanDuration)
+    }
+}
+
+// Ban veta al par durante d y cierra sus conexiones.
+func (pm *PeerManager) Ban(id peer.ID, d time.Duration) {
+    pm.mu.Lock()
+    pm.bans[id] = time.Now().Add(d)
+    pm.record(id).score = 0
+    pm.saveBans()
+    h := pm.host
+    pm.mu.Unlock()
+
+    log.Printf("Par %s vetado durante %s\n", id, d)
+    if h != nil {
+        h.Network().ClosePeer(id)
+    }
+}
+
+// Unban levanta el veto del par.
+func (pm *PeerManager) Unban(id peer.ID) {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    delete(pm.bans, id)
+    pm.saveBans()
+}
+
+// IsBanned indica si el par está vetado.
+func (pm *PeerManager) IsBanned(id peer.ID) bool {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    return pm.banned(id)
+}
+
+// Table devuelve el estado de los pares conocidos, de mayor a menor puntuación.
+func (pm *PeerManager) Table() []PeerStatus {
+    pm.mu.Lock()
+    defer pm.mu.Unlock()
+
+    ids := make(map[peer.ID]bool)
+    for id := range pm.records {
+        ids[id] = true
+    }
+    for id := range pm.bans {
+        ids[id] = true
+    }
+    if pm.host != nil {
+        for _, id := range pm.host.Network().Peers() {
+            ids[id] = true
+        }
+    }
+
+    table := make([]PeerStatus, 0, len(ids))
+    for id := range ids {
+        status := PeerStatus{ID: id}
+        if record, ok := pm.records[id]; ok {
+            status.Score = record.score
+            status.LastReason = string(record.lastReason)
+        }
+        if until, ok := pm.bans[id]; ok && time.Now().Before(until) {
+            until := until
+            status.BannedUntil = &until
+        }
+        if pm.host != nil {
+            status.Connected = pm.host.Network().Connectedness(id) == network.Connected
+            for _, addr := range pm.host.Peerstore().Addrs(id) {
+                status.Addrs = append(status.Addrs, addr.String())
+            }
+        }
+        table = append(table, status)
+    }
+
+    sort.Slice(table, func(i, j int) bool { return table[i].Score > table[j].Score })
+    return table
+}
+
+func (pm *PeerManager) record(id peer.ID) *peerRecord {
+    record, ok := pm.records[id]
+    if !ok {
+        record = &peerRecord{}
+        pm.records[id] = record
+    }
+    return record
+}
+
+func (pm *PeerManager) banned(id peer.ID) bool {
+    until, ok := pm.bans[id]
+    return ok && time.Now().Before(until)
+}
+
+// saveBans guarda los vetos vigentes. Se llama con pm.mu tomado.
+func (pm *PeerManager) saveBans() {
+    if pm.config.BanFile == "" {
+        return
+    }
+    data, err := json.Marshal(pm.bans)
+    if err != nil {
+        return
+    }
+    if err := os.WriteFile(pm.config.BanFile, data, 0600); err != nil {
+        log.Printf("Error al guardar los vetos: %v\n", err)
+    }
+}
+
+// maintain recupera puntuaciones, retira vetos vencidos y ajusta las conexiones a los límites.
+func (pm *PeerManager) maintain(ctx context.Context) {
+    pm.mu.Lock()
+    for _, record := range pm.records {
+        if record.score < 0 {
+            record.score++
+        }
+    }
+    expired := false
+    for id, until := range pm.bans {
+        if !time.Now().Before(until) {
+            delete(pm.bans, id)
+            expired = true
+        }
+    }
+    if expired {
+        pm.saveBans()
+    }
+    h := pm.host
+    pm.mu.Unlock()
+
+    connected := h.Network().Peers()
+
+    // Por encima del máximo se desconectan los pares con peor puntuación
+    if pm.config.MaxPeers > 0 && len(connected) > pm.config.MaxPeers {
+        table := pm.Table()
+        excess := len(connected) - pm.config.MaxPeers
+        for i := len(table) - 1; i >= 0 && excess > 0; i-- {
+            if table[i].Connected {
+                h.Network().ClosePeer(table[i].ID)
+                excess--
+            }
+        }
+    }
+
+    // Por debajo del mínimo se intenta reconectar con pares conocidos
+    if len(connected) < pm.config.MinPeers {
+        missing := pm.config.MinPeers - len(connected)
+        for _, id := range h.Peerstore().PeersWithAddrs() {
+            if missing == 0 {
+                break
+            }
+            if id == h.ID() || pm.IsBanned(id) || h.Network().Connectedness(id) == network.Connected {
+                continue
+            }
+            dialCtx, cancel := context.WithTimeout(ctx, timeouts.Dial)
+            err := h.Connect(dialCtx, h.Peerstore().PeerInfo(id))
+            cancel()
+            if err == nil {
+                missing--
+            }
+        }
+    }
+}
+
+// Métodos de connmgr.ConnectionGater: los pares vetados no pueden conectarse ni ser marcados,
+// y las conexiones entrantes se rechazan cuando se alcanzó MaxPeers.
+
+func (pm *PeerManager) InterceptPeerDial(id peer.ID) bool {
+    return !pm.IsBanned(id)
+}
+
+func (pm *PeerManager) InterceptAddrDial(id peer.ID, addr multiaddr.Multiaddr) bool {
+    return !pm.IsBanned(id)
+}
+
+func (pm *PeerManager) InterceptAccept(addrs network.ConnMultiaddrs) bool {
+    return true
+}
+
+func (pm *PeerManager) InterceptSecured(dir network.Direction, id peer.ID, addrs network.ConnMultiaddrs) bool {
+    if pm.IsBanned(id) {
+        return false
+    }
+
+    pm.mu.Lock()
+    h := pm.host
+    pm.mu.Unlock()
+
+    if dir == network.DirInbound && h != nil && pm.config.MaxPeers > 0 && h.Network().Connectedness(id) != network.Connected {
+        return len(h.Network().Peers()) < pm.config.MaxPeers
+    }
+    return true
+}
+
+func (pm *PeerManager) InterceptUpgraded(conn network.Conn) (bool, control.DisconnectReason) {
+    return true, 0
+}
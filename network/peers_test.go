@@ -0,0 +1,84 @@
+package network
+
+import (
+    "path/filepath"
+    "testing"
+    "time"
+    "github.com/libp2p/go-libp2p/core/network"
+    "github.com/libp2p/go-libp2p/core/peer"
+)
+
+func testPeerID(t *testing.T) peer.ID {
+    id, err := peer.Decode("12D3KooWCKBb57vuFp7pj9Z8psQ4TaXehh8v9wuBi9fTBWKr9j3s")
+    if err != nil {
+        t.Fatal(err)
+    }
+    return id
+}
+
+func TestPenalize(t *testing.T) {
+    id := testPeerID(t)
+
+    tests := []struct {
+        name    string
+        reasons []Misbehavior
+        score   int
+        banned  bool
+    }{
+        {"sin faltas", nil, 0, false},
+        {"un bloque inválido", []Misbehavior{InvalidBlock}, -50, false},
+        {"justo en el umbral", []Misbehavior{InvalidBlock, BadSignature}, 0, true},
+        {"faltas leves", []Misbehavior{Spam, Timeout, InvalidTransaction, Spam}, -45, false},
+        {"faltas leves hasta el umbral", []Misbehavior{InvalidTransaction, InvalidTransaction, InvalidTransaction, InvalidTransaction, InvalidTransaction}, 0, true},
+        {"por encima del umbral", []Misbehavior{InvalidBlock, InvalidTransaction, Spam, Timeout}, -85, false},
+    }
+
+    for _, test := range tests {
+        pm := NewPeerManager(PeerConfig{})
+        for _, reason := range test.reasons {
+            pm.Penalize(id, reason)
+        }
+
+        if pm.IsBanned(id) != test.banned {
+            t.Errorf("%s: vetado: %v, se esperaba %v", test.name, pm.IsBanned(id), test.banned)
+        }
+        // El veto reinicia la puntuación para cuando expire
+        score := 0
+        if record, ok := pm.records[id]; ok {
+            score = record.score
+        }
+        if score != test.score {
+            t.Errorf("%s: puntuación %d, se esperaba %d", test.name, score, test.score)
+        }
+        // Un par vetado no se puede marcar ni conectar
+        if pm.InterceptPeerDial(id) == test.banned || pm.InterceptSecured(network.DirInbound, id, nil) == test.banned {
+            t.Errorf("%s: el gater no coincide con el veto", test.name)
+        }
+    }
+}
+
+func TestBanPersistence(t *testing.T) {
+    id := testPeerID(t)
+    config := PeerConfig{BanFile: filepath.Join(t.TempDir(), "bans.json")}
+
+    pm := NewPeerManager(config)
+    pm.Ban(id, time.Hour)
+
+    // Los vetos sobreviven a un reinicio
+    restarted := NewPeerManager(config)
+    if !restarted.IsBanned(id) {
+        t.Fatal("el veto no sobrevivió al reinicio")
+    }
+
+    // Levantar un veto también se guarda
+    restarted.Unban(id)
+    if NewPeerManager(config).IsBanned(id) {
+        t.Fatal("el veto levantado volvió tras el reinicio")
+    }
+
+    // Un veto vencido no cuenta, aunque siga en el archivo
+    pm.Ban(id, -time.Second)
+    if NewPeerManager(config).IsBanned(id) {
+        t.Fatal("un veto vencido sigue vigente")
+    }
+}
@@ -0,0 +1,181 @@
+package network
+
+import (
+    "sync"
+    "time"
+    "github.com/libp2p/go-libp2p/core/network"
+    "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// StreamLimit son los límites que se aplican a las solicitudes entrantes de un protocolo.
+type StreamLimit struct {
+    // Rate es la cantidad de solicitudes por segundo que cada par puede hacer en promedio.
+    Rate float64
+    // Burst es la cantidad de solicitudes seguidas que se le permiten a un par por encima de Rate.
+    Burst int
+    // PerPeer es la cantidad de streams simultáneos que se atienden a cada par.
+    PerPeer int
+    // Total es la cantidad de streams simultáneos que se atienden entre todos los pares.
+    Total int
+    // MaxRequest es el tamaño máximo de la solicitud en bytes.
+    MaxRequest int
+    // Timeout es el plazo para recibir la solicitud y enviar la respuesta.
+    Timeout time.Duration
+}
+
+// StreamLimits son los límites de cada protocolo. Crear una cuenta y servir una instantánea son
+// las operaciones más costosas, así que se permiten muy pocas por par.
+var StreamLimits = map[string]StreamLimit{
+    "/create-account":       {Rate: 1.0 / 60, Burst: 2, PerPeer: 1, Total: 4, MaxRequest: 1 << 10, Timeout: 10 * time.Second},
+    "/get-balance":          {Rate: 10, Burst: 20, PerPeer: 4, Total: 64, MaxRequest: 4 << 10, Timeout: 10 * time.Second},
+    "/get-trans":            {Rate: 10, Burst: 20, PerPeer: 4, Total: 64, MaxRequest: 4 << 10, Timeout: 10 * time.Second},
+    "/send-balance":         {Rate: 5, Burst: 10, PerPeer: 2, Total: 32, MaxRequest: 64 << 10, Timeout: 10 * time.Second},
+    SyncProtocolID:          {Rate: 10, Burst: 20, PerPeer: 4, Total: 32, MaxRequest: 4 << 10, Timeout: 30 * time.Second},
+    SnapshotProtocolID:      {Rate: 1.0 / 60, Burst: 2, PerPeer: 1, Total: 4, MaxRequest: 1 << 10, Timeout: time.Minute},
+    StatusProtocolID:        {Rate: 1, Burst: 5, PerPeer: 1, Total: 64, MaxRequest: 4 << 10, Timeout: StatusTimeout},
+    UserBroadcastProtocolID: {Rate: 1, Burst: 5, PerPeer: 1, Total: 16, MaxRequest: 16 << 10, Timeout: 10 * time.Second},
+}
+
+// DefaultStreamLimit se aplica a los protocolos que no están en StreamLimits.
+var DefaultStreamLimit = StreamLimit{Rate: 1, Burst: 5, PerPeer: 1, Total: 16, MaxRequest: 4 << 10, Timeout: 10 * time.Second}
+
+// limiterSweepInterval es cada cuánto se olvidan los pares que ya no tienen solicitudes pendientes.
+const limiterSweepInterval = time.Minute
+
+func streamLimit(protocol string) StreamLimit {
+    if limit, ok := StreamLimits[protocol]; ok {
+        return limit
+    }
+    return DefaultStreamLimit
+}
+
+type limitKey struct {
+    peer     peer.ID
+    protocol string
+}
+
+// bucket es el balde de fichas de un par en un protocolo. strikes cuenta las solicitudes
+// rechazadas seguidas: un par que respeta los rechazos y reintenta más tarde no se penaliza,
+// uno que insiste más de Burst veces sí.
+type bucket struct {
+    tokens  float64
+    last    time.Time
+    active  int
+    strikes int
+}
+
+// rateLimiter aplica los StreamLimits a las solicitudes entrantes.
+type rateLimiter struct {
+    mu        sync.Mutex
+    buckets   map[limitKey]*bucket
+    active    map[string]int
+    lastSweep time.Time
+}
+
+// limiter es el limitador de todos los manejadores de stream del nodo.
+var limiter = &rateLimiter{
+    buckets: make(map[limitKey]*bucket),
+    active:  make(map[string]int),
+}
+
+// acquire registra una solicitud del par en el protocolo. Si el par o el protocolo superan sus
+// límites devuelve un error CodeRateLimited, y abusive indica si el par insiste tanto que merece
+// una penalización. Si no, release se debe llamar al terminar.
+func (l *rateLimiter) acquire(id peer.ID, protocol string, limit StreamLimit) (release func(), abusive bool, err error) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    now := time.Now()
+    if now.Sub(l.lastSweep) > limiterSweepInterval {
+        l.sweep(now)
+    }
+
+    key := limitKey{peer: id, protocol: protocol}
+    b, ok := l.buckets[key]
+    if !ok {
+        b = &bucket{tokens: float64(limit.Burst), last: now}
+        l.buckets[key] = b
+    }
+
+    // Las fichas se recuperan a razón de Rate por segundo, hasta Burst
+    b.tokens += now.Sub(b.last).Seconds() * limit.Rate
+    if b.tokens > float64(limit.Burst) {
+        b.tokens = float64(limit.Burst)
+    }
+    b.last = now
+
+    switch {
+    case b.active >= limit.PerPeer:
+        err = protocolErrorf(CodeRateLimited, "demasiadas solicitudes simultáneas a %s", protocol)
+    case l.active[protocol] >= limit.Total:
+        // La saturación no es culpa de este par, así que no cuenta en su contra
+        return nil, false, protocolErrorf(CodeRateLimited, "el nodo está atendiendo demasiadas solicitudes a %s", protocol)
+    case b.tokens < 1:
+        wait := time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+        err = protocolErrorf(CodeRateLimited, "demasiadas solicitudes a %s, reintente en %s", protocol, (wait + 99*time.Millisecond).Truncate(100*time.Millisecond))
+    }
+    if err != nil {
+        b.strikes++
+        return nil, b.strikes > limit.Burst, err
+    }
+
+    b.strikes = 0
+    b.tokens--
+    b.active++
+    l.active[protocol]++
+
+    var once sync.Once
+    return func() {
+        once.Do(func() {
+            l.mu.Lock()
+            defer l.mu.Unlock()
+            b.active--
+            l.active[protocol]--
+        })
+    }, false, nil
+}
+
+// sweep olvida los baldes sin solicitudes en curso que ya se habrían llenado. Se llama con l.mu tomado.
+func (l *rateLimiter) sweep(now time.Time) {
+    for key, b := range l.buckets {
+        limit := streamLimit(key.protocol)
+        if b.active == 0 && b.strikes == 0 && b.tokens+now.Sub(b.last).Seconds()*limit.Rate >= float64(limit.Burst) {
+            delete(l.buckets, key)
+        }
+    }
+    l.lastSweep = now
+}
+
+// readRequest lee la solicitud de un stream entrante aplicando los límites de su protocolo: plazo
+// de lectura y escritura, tamaño máximo y frecuencia. Si el par superó sus límites se le responde
+// con CodeRateLimited y se devuelve el error. Si no, release se debe llamar al terminar de
+// atender la solicitud.
+func readRequest(s network.Stream) (request *Message, release func(), err error) {
+    remote := s.Conn().RemotePeer()
+    protocol := string(s.Protocol())
+    limit := streamLimit(protocol)
+
+    s.SetDeadline(time.Now().Add(limit.Timeout))
+
+    release, abusive, limited := limiter.acquire(remote, protocol, limit)
+
+    request, err = readMessage(s, limit.MaxRequest)
+    if err == ErrMessageTooLarge {
+        reportPeer(remote, Spam)
+    }
+    if err != nil {
+        if release != nil {
+            release()
+        }
+        return nil, nil, err
+    }
+
+    if limited != nil {
+        if abusive {
+            reportPeer(remote, Spam)
+        }
+        respond(s, request, nil, limited)
+        return nil, nil, limited
+    }
+    return request, release, nil
+}
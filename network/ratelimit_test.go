@@ -0,0 +1,79 @@
+package network
+
+import (
+    "context"
+    "testing"
+    "time"
+    "github.com/libp2p/go-libp2p"
+    "github.com/libp2p/go-libp2p/core/host"
+    "github.com/libp2p/go-libp2p/core/network"
+    "github.com/libp2p/go-libp2p/core/peer"
+)
+
+func testHost(t *testing.T) host.Host {
+    h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { h.Close() })
+    return h
+}
+
+func TestServeStreamRateLimit(t *testing.T) {
+    const protocol = "/get-balance"
+
+    // Límites de prueba: dos solicitudes seguidas y después casi ninguna
+    defer func(limit StreamLimit) { StreamLimits[protocol] = limit }(StreamLimits[protocol])
+    StreamLimits[protocol] = StreamLimit{Rate: 0.001, Burst: 2, PerPeer: 4, Total: 64, MaxRequest: 4 << 10, Timeout: 5 * time.Second}
+    defer func(l *rateLimiter, pm *PeerManager) { limiter, peerManager = l, pm }(limiter, peerManager)
+    limiter = &rateLimiter{buckets: make(map[limitKey]*bucket), active: make(map[string]int)}
+    peerManager = NewPeerManager(PeerConfig{})
+
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+
+    server := testHost(t)
+    client := testHost(t)
+    server.SetStreamHandler(protocol, func(s network.Stream) {
+        serveStream(ctx, s, MsgGetBalance, func(ctx context.Context, request *Message) (interface{}, error) {
+            return &BalanceResponse{Balance: 1}, nil
+        })
+    })
+    if err := client.Connect(ctx, peer.AddrInfo{ID: server.ID(), Addrs: server.Addrs()}); err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        name  string
+        code  ErrorCode
+        score int
+    }{
+        {"primera", CodeOK, 0},
+        {"segunda", CodeOK, 0},
+        {"rechazada", CodeRateLimited, 0},
+        {"rechazada otra vez", CodeRateLimited, 0},
+        // Tras Burst rechazos seguidos el par que insiste se penaliza
+        {"insistente", CodeRateLimited, -penalties[Spam]},
+    }
+
+    for _, test := range tests {
+        var response BalanceResponse
+        err := SendRequest(ctx, client, server.ID(), protocol, MsgGetBalance, &BalanceRequest{Address: "x"}, &response)
+        if code := errorCode(err); err != nil && code != test.code || err == nil && test.code != CodeOK {
+            t.Errorf("%s: se esperaba %s y se recibió %v", test.name, test.code, err)
+        }
+        if err == nil && response.Balance != 1 {
+            t.Errorf("%s: respuesta inesperada %+v", test.name, response)
+        }
+
+        score := 0
+        peerManager.mu.Lock()
+        if record, ok := peerManager.records[client.ID()]; ok {
+            score = record.score
+        }
+        peerManager.mu.Unlock()
+        if score != test.score {
+            t.Errorf("%s: puntuación %d, se esperaba %d", test.name, score, test.score)
+        }
+    }
+}
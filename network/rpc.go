@@ -0,0 +1,106 @@
+package network
+
+import (
+    "context"
+    "encoding/json"
+    "log"
+    "net/http"
+    "strings"
+    "github.com/gorilla/mux"
+    "github.com/syndtr/goleveldb/leveldb"
+    "blockchain/common"
+    "blockchain/core"
+)
+
+// DefaultRPCAddr es la dirección por defecto de la API REST del nodo.
+const DefaultRPCAddr = "127.0.0.1:8091"
+
+// maxTransactionBody limita el tamaño de las transacciones recibidas por la API REST.
+const maxTransactionBody = 1 << 20
+
+// ServeRPC atiende en addr la API REST del nodo, con la que se consultan cuentas y
+// transacciones y se envían transacciones firmadas sin pasar por un cliente libp2p:
+//
+//  GET  /accounts/{address}    saldo, nonce y comisión mínima para construir una transacción
+//  POST /transactions          transacción firmada en JSON, como la produce el cliente
+//  GET  /transactions/{hash}   estado de una transacción; 404 si el nodo no la conoce y 410 si
+//                              su bloque fue podado
+func ServeRPC(ctx context.Context, addr string, db *leveldb.DB, gossip *Gossip) error {
+    r := mux.NewRouter()
+
+    r.HandleFunc("/accounts/{address}", func(w http.ResponseWriter, r *http.Request) {
+        address := strings.TrimSpace(mux.Vars(r)["address"])
+        if err := common.ValidateAddress(address); err != nil {
+            writeRPCError(w, protocolErrorf(CodeBadRequest, "%v", err))
+            return
+        }
+        user, err := getAccount(common.AddressKey(address), db)
+        if err != nil {
+            writeRPCError(w, err)
+            return
+        }
+        writeAdminJSON(w, http.StatusOK, &BalanceResponse{Address: address, Balance: user.Balance, Nonce: user.Nonce, MinFee: MinFee})
+    }).Methods("GET")
+
+    r.HandleFunc("/transactions", func(w http.ResponseWriter, r *http.Request) {
+        var transaction common.Transaction
+        decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxTransactionBody))
+        decoder.DisallowUnknownFields()
+        if err := decoder.Decode(&transaction); err != nil {
+            writeRPCError(w, protocolErrorf(CodeBadRequest, "transacción inválida: %v", err))
+            return
+        }
+        response, err := SubmitTransaction(ctx, transaction, db, gossip)
+        if err != nil {
+            writeRPCError(w, err)
+            return
+        }
+        writeAdminJSON(w, http.StatusOK, response)
+    }).Methods("POST")
+
+    r.HandleFunc("/transactions/{hash}", func(w http.ResponseWriter, r *http.Request) {
+        var mempool *core.Mempool
+        if gossip != nil {
+            mempool = gossip.Mempool
+        }
+        info, err := lookupTransaction(db, strings.ToLower(mux.Vars(r)["hash"]), mempool)
+        if err != nil {
+            writeRPCError(w, err)
+            return
+        }
+        writeAdminJSON(w, TransactionStatusCode(info), info)
+    }).Methods("GET")
+
+    log.Printf("API REST escuchando en %s\n", addr)
+    return http.ListenAndServe(addr, r)
+}
+
+// TransactionStatusCode devuelve el estado HTTP con el que se responde la consulta de una
+// transacción: 404 si el nodo no la conoce y 410 si su bloque fue podado.
+func TransactionStatusCode(info *TransactionInfo) int {
+    switch info.Status {
+    case TxUnknown:
+        return http.StatusNotFound
+    case TxPruned:
+        return http.StatusGone
+    }
+    return http.StatusOK
+}
+
+// writeRPCError responde con el estado HTTP que corresponde al código del error.
+func writeRPCError(w http.ResponseWriter, err error) {
+    status := http.StatusInternalServerError
+    if protocolErr, ok := err.(*ProtocolError); ok {
+        switch protocolErr.Code {
+        case CodeBadRequest:
+            status = http.StatusBadRequest
+        case CodeNotFound:
+            status = http.StatusNotFound
+        case CodePruned:
+            status = http.StatusGone
+        case CodeRejected:
+            status = http.StatusUnprocessableEntity
+        }
+    }
+    writeAdminJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,31 @@
+package network
+
+import (
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+)
+
+func TestWriteRPCError(t *testing.T) {
+    tests := []struct {
+        name   string
+        err    error
+        status int
+    }{
+        {"solicitud inválida", protocolErrorf(CodeBadRequest, "dirección inválida"), http.StatusBadRequest},
+        {"no encontrado", protocolErrorf(CodeNotFound, "no existe"), http.StatusNotFound},
+        {"podado", protocolErrorf(CodePruned, "bloques podados"), http.StatusGone},
+        {"rechazada", protocolErrorf(CodeRejected, "saldo insuficiente"), http.StatusUnprocessableEntity},
+        {"fallo interno", protocolErrorf(CodeInternal, "error"), http.StatusInternalServerError},
+        {"error sin código", fmt.Errorf("error al abrir la base de datos"), http.StatusInternalServerError},
+    }
+
+    for _, test := range tests {
+        recorder := httptest.NewRecorder()
+        writeRPCError(recorder, test.err)
+        if recorder.Code != test.status {
+            t.Errorf("%s: estado %d, se esperaba %d", test.name, recorder.Code, test.status)
+        }
+    }
+}
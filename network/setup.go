@@ -5,7 +5,6 @@ import (
     "log"
     "fmt"
     "strings"
-    "encoding/json"
     "os"
     "github.com/joho/godotenv"
     "github.com/libp2p/go-libp2p/core/host"
@@ -69,18 +68,6 @@ func RemoveNodeFromEnv(filePath, currentNodeAddr string) {
     }
 }
 
-func ConnectToSeedNodes(ctx context.Context, h host.Host, activeSeedNodes []string) {
-    for _, addr := range activeSeedNodes {
-        peerAddr, _ := multiaddr.NewMultiaddr(addr)
-        peerinfo, _ := peer.AddrInfoFromP2pAddr(peerAddr)
-        if err := h.Connect(ctx, *peerinfo); err != nil {
-            log.Printf("Fallo al conectar con el nodo semilla activo: %s\n", addr)
-        } else {
-            log.Printf("Conectado con éxito al nodo semilla: %s\n", addr)
-        }
-    }
-}
-
 func VerifySeedNodes(ctx context.Context, h host.Host, currentNodeAddr string) []string {
     seedNodes, _ := godotenv.Read()
     nodes := strings.Split(seedNodes[SeedNodesEnvVar], ",")
@@ -106,16 +93,16 @@ func VerifySeedNodes(ctx context.Context, h host.Host, currentNodeAddr string) [
     return activeSeedNodes
 }
 
-func SetupBroadcastStreamHandler(h host.Host) {
+func SetupBroadcastStreamHandler(ctx context.Context, h host.Host) {
     h.SetStreamHandler(UserBroadcastProtocolID, func(s network.Stream) {
-        defer s.Close()
-
-        var user common.User
-        if err := json.NewDecoder(s).Decode(&user); err != nil {
-            fmt.Printf("Error al recibir datos de usuario: %v\n", err)
-            return
-        }
-
-        fmt.Printf("Datos de usuario recibidos de %s: %v\n", s.Conn().RemotePeer(), user)
+        serveStream(ctx, s, MsgUser, func(ctx context.Context, request *Message) (interface{}, error) {
+            var user common.User
+            if err := request.Decode(&user); err != nil {
+                return nil, protocolErrorf(CodeBadRequest, "error al recibir datos de usuario: %v", err)
+            }
+
+            fmt.Printf("Datos de usuario recibidos de %s: %v\n", s.Conn().RemotePeer(), user)
+            return nil, nil
+        })
     })
-}
\ No newline at end of file
+}
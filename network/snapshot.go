@@ -0,0 +1,146 @@
+package network
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "github.com/libp2p/go-libp2p/core/host"
+    "github.com/libp2p/go-libp2p/core/network"
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/syndtr/goleveldb/leveldb"
+    "blockchain/common"
+    "blockchain/core"
+    "blockchain/database"
+)
+
+const SnapshotProtocolID = "/blockchain/snapshot/1.0.0"
+
+// SnapshotResponse contiene la instantánea más reciente de un nodo, el bloque al que
+// corresponde y todos los bloques posteriores. El primero de Blocks compromete el estado
+// de la instantánea en su cabecera.
+type SnapshotResponse struct {
+    Snapshot *common.Snapshot
+    Anchor   *common.Block
+    Blocks   []common.Block
+}
+
+func SetupSnapshotHandler(ctx context.Context, h host.Host, db *leveldb.DB) {
+    h.SetStreamHandler(SnapshotProtocolID, func(s network.Stream) {
+        serveStream(ctx, s, MsgSnapshot, func(ctx context.Context, request *Message) (interface{}, error) {
+            log.Println("Solicitud de instantánea recibida.")
+            return buildSnapshotResponse(db)
+        })
+    })
+}
+
+// buildSnapshotResponse busca la instantánea más reciente que ya tenga un bloque sucesor.
+func buildSnapshotResponse(db *leveldb.DB) (*SnapshotResponse, error) {
+    head, err := database.LastBlockIndex(db)
+    if err != nil {
+        return nil, err
+    }
+
+    snapshot, err := core.LatestSnapshot(db, head-1)
+    if err == leveldb.ErrNotFound {
+        return nil, protocolErrorf(CodeUnavailable, "no hay instantáneas disponibles")
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    anchor, err := core.LoadBlock(db, snapshot.Height)
+    if err != nil {
+        return nil, fmt.Errorf("error al cargar el bloque %d: %v", snapshot.Height, err)
+    }
+
+    response := &SnapshotResponse{Snapshot: snapshot, Anchor: anchor}
+    for height := snapshot.Height + 1; height <= head; height++ {
+        block, err := core.LoadBlock(db, height)
+        if err != nil {
+            return nil, fmt.Errorf("error al cargar el bloque %d: %v", height, err)
+        }
+        response.Blocks = append(response.Blocks, *block)
+    }
+
+    return response, nil
+}
+
+// SyncFromSnapshot inicia una base de datos local vacía a partir de la instantánea de un par.
+// La instantánea solo se acepta si el bloque siguiente la compromete y si su bloque coincide con
+// la cabecera verificada de esa altura. Los bloques posteriores se validan uno a uno contra sus
+// cabeceras y se descartan los que van más allá de la última cabecera verificada.
+func SyncFromSnapshot(ctx context.Context, h host.Host, db *leveldb.DB, peerID peer.ID) error {
+    ctx, cancel := context.WithTimeout(ctx, timeouts.Snapshot)
+    defer cancel()
+
+    var response SnapshotResponse
+    err := SendRequest(ctx, h, peerID, SnapshotProtocolID, MsgSnapshot, nil, &response)
+    if err != nil {
+        return fmt.Errorf("el nodo remoto no sirvió una instantánea: %v", err)
+    }
+    if response.Snapshot == nil || response.Anchor == nil || len(response.Blocks) == 0 {
+        return fmt.Errorf("respuesta de instantánea incompleta")
+    }
+
+    if err := core.VerifySnapshot(response.Snapshot, response.Anchor, &response.Blocks[0]); err != nil {
+        return fmt.Errorf("instantánea inválida: %v", err)
+    }
+
+
+    if _, err := database.LastBlockIndex(db); err != database.ErrNoBlocks {
+        return fmt.Errorf("la base de datos local ya contiene bloques")
+    }
+
+    // El estado de la instantánea solo es fiable si tanto su bloque como el que la compromete están verificados
+    if err := matchesHeader(db, *response.Anchor); err != nil {
+        return fmt.Errorf("instantánea inválida: %v", err)
+    }
+    if err := matchesHeader(db, response.Blocks[0]); err != nil {
+        return fmt.Errorf("instantánea inválida: %v", err)
+    }
+
+    validator := core.NewChainValidator(response.Anchor, core.StateFromAccounts(response.Snapshot.Accounts))
+    for i, block := range response.Blocks {
+        if _, err := database.GetHeader(db, block.Index); err == leveldb.ErrNotFound {
+            response.Blocks = response.Blocks[:i]
+            break
+        }
+        if err := matchesHeader(db, block); err != nil {
+            return fmt.Errorf("bloque inválido tras la instantánea: %v", err)
+        }
+        if err := validator.Add(block); err != nil {
+            return fmt.Errorf("bloque inválido tras la instantánea: %v", err)
+        }
+    }
+
+    if err := core.SaveBlock(db, *response.Anchor); err != nil {
+        return fmt.Errorf("error al guardar el bloque %d: %v", response.Anchor.Index, err)
+    }
+    if err := core.SaveSnapshot(db, response.Snapshot); err != nil {
+        return fmt.Errorf("error al guardar la instantánea: %v", err)
+    }
+    for _, block := range response.Blocks {
+        if err := core.SaveBlock(db, block); err != nil {
+            return fmt.Errorf("error al guardar el bloque %d: %v", block.Index, err)
+        }
+    }
+
+    if err := core.StoreState(db, validator.State()); err != nil {
+        return fmt.Errorf("error al guardar el estado: %v", err)
+    }
+
+    log.Printf("Base de datos iniciada desde la instantánea de la altura %d, %d bloques posteriores\n", response.Snapshot.Height, len(response.Blocks))
+    return nil
+}
+
+// matchesHeader comprueba que el bloque coincide con la cabecera verificada de su altura.
+func matchesHeader(db *leveldb.DB, block common.Block) error {
+    header, err := database.GetHeader(db, block.Index)
+    if err != nil {
+        return fmt.Errorf("no hay cabecera verificada para el bloque %d: %v", block.Index, err)
+    }
+    if header.Hash != block.Hash {
+        return errOtherBranch
+    }
+    return nil
+}
@@ -0,0 +1,329 @@
+package network
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "sort"
+    "sync"
+    "time"
+    "github.com/libp2p/go-libp2p/core/host"
+    "github.com/libp2p/go-libp2p/core/network"
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/syndtr/goleveldb/leveldb"
+    "blockchain/common"
+    "blockchain/core"
+    "blockchain/database"
+)
+
+const StatusProtocolID = "/blockchain/status/1.0.0"
+
+// ProtocolVersion se incrementa con cada cambio incompatible de los protocolos de la red.
+// La versión 2 introdujo las direcciones en Base58Check, que los nodos anteriores rechazan, la
+// 3 el nonce y la comisión de las transacciones, que cambian su hash, y la 4 la respuesta de
+// /get-trans con el estado de la transacción.
+const ProtocolVersion = 4
+
+// DefaultChainID identifica la red principal. Nodos con distinto ChainID no se conectan entre sí.
+const DefaultChainID = "chain_block"
+
+// AddressVersions devuelve los bytes de versión de las direcciones de una red, de una clave y
+// multifirma: los de la red principal para DefaultChainID y los de las redes de prueba para
+// cualquier otra.
+func AddressVersions(chainID string) (byte, byte) {
+    if chainID == DefaultChainID {
+        return common.MainNetAddressVersion, common.MainNetMultisigVersion
+    }
+    return common.TestNetAddressVersion, common.TestNetMultisigVersion
+}
+
+// StatusTimeout limita lo que espera el handshake la respuesta del par.
+const StatusTimeout = 10 * time.Second
+
+// Capacidades opcionales que un nodo anuncia en el handshake.
+const (
+    // CapabilityPruned indica que el nodo solo conserva los cuerpos desde Base.
+    CapabilityPruned = "pruned"
+    // CapabilitySnapshots indica que el nodo puede servir instantáneas de estado.
+    CapabilitySnapshots = "snapshots"
+)
+
+// Status es lo que cada nodo anuncia de sí mismo al conectarse.
+type Status struct {
+    Version      int
+    ChainID      string
+    Genesis      string
+    Head         int64
+    HeadHash     string
+    Base         int64
+    Capabilities []string `json:",omitempty"`
+}
+
+// Has indica si el nodo anunció la capacidad.
+func (status *Status) Has(capability string) bool {
+    for _, c := range status.Capabilities {
+        if c == capability {
+            return true
+        }
+    }
+    return false
+}
+
+// StatusService intercambia el estado con cada par nuevo y recuerda lo que anunció cada uno.
+// Los pares de otra versión, otra red u otro génesis se desconectan.
+type StatusService struct {
+    ctx     context.Context
+    host    host.Host
+    db      *leveldb.DB
+    chainID string
+    pruned  bool
+
+    mu    sync.Mutex
+    peers map[peer.ID]*Status
+}
+
+// statusService es el servicio activo, que la sincronización consulta para elegir pares.
+var statusService *StatusService
+
+// StartStatusService registra el protocolo de estado y hace el handshake con cada conexión
+// saliente mientras dure ctx. pruned indica si el nodo corre en modo podado.
+func StartStatusService(ctx context.Context, h host.Host, db *leveldb.DB, chainID string, pruned bool) *StatusService {
+    s := &StatusService{
+        ctx:     ctx,
+        host:    h,
+        db:      db,
+        chainID: chainID,
+        pruned:  pruned,
+        peers:   make(map[peer.ID]*Status),
+    }
+
+    h.SetStreamHandler(StatusProtocolID, s.handleStream)
+
+    // Solo quien abre la conexión inicia el handshake; un intercambio basta para ambos lados
+    h.Network().Notify(&network.NotifyBundle{
+        ConnectedF: func(n network.Network, conn network.Conn) {
+            if conn.Stat().Direction == network.DirOutbound {
+                go s.handshake(conn.RemotePeer())
+            }
+        },
+        DisconnectedF: func(n network.Network, conn network.Conn) {
+            if n.Connectedness(conn.RemotePeer()) != network.Connected {
+                s.mu.Lock()
+                delete(s.peers, conn.RemotePeer())
+                s.mu.Unlock()
+            }
+        },
+    })
+
+    statusService = s
+    return s
+}
+
+// LocalStatus describe la cadena local. Si no se puede leer se informa una cadena vacía, que
+// no permite a los pares pedirle bloques.
+func (s *StatusService) LocalStatus() *Status {
+    status, err := s.readLocalStatus()
+    if err != nil {
+        log.Printf("Error al leer el estado de la cadena local: %v\n", err)
+        return &Status{Version: ProtocolVersion, ChainID: s.chainID, Head: -1}
+    }
+    return status
+}
+
+func (s *StatusService) readLocalStatus() (*Status, error) {
+    status := &Status{Version: ProtocolVersion, ChainID: s.chainID, Head: -1}
+
+    db := s.db
+    head, err := database.LastBlockIndex(db)
+    if err == database.ErrNoBlocks {
+        return status, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    if genesis, err := core.LoadHeader(db, 0); err == nil {
+        status.Genesis = genesis.Hash
+    } else if genesis, err := database.GetHeader(db, 0); err == nil {
+        // Nodo iniciado desde una instantánea: el génesis está en el almacén de cabeceras
+        status.Genesis = genesis.Hash
+    }
+
+    header, err := core.LoadHeader(db, head)
+    if err != nil {
+        return nil, err
+    }
+    status.Head = head
+    status.HeadHash = header.Hash
+
+    status.Base, err = core.FirstFullBlock(db)
+    if err != nil {
+        return nil, err
+    }
+
+    pruned, err := core.PrunedHeight(db)
+    if err != nil {
+        return nil, err
+    }
+    if s.pruned || pruned > 0 {
+        status.Capabilities = append(status.Capabilities, CapabilityPruned)
+    }
+    if _, err := core.LatestSnapshot(db, head-1); err == nil {
+        status.Capabilities = append(status.Capabilities, CapabilitySnapshots)
+    }
+
+    return status, nil
+}
+
+func (s *StatusService) handleStream(stream network.Stream) {
+    defer stream.Close()
+
+    remote := stream.Conn().RemotePeer()
+
+    request, release, err := readRequest(stream)
+    if err != nil {
+        log.Printf("Error al leer el estado de %s: %v\n", remote, err)
+        return
+    }
+    defer release()
+
+    var status Status
+    err = request.Decode(&status)
+    if err != nil {
+        err = protocolErrorf(CodeBadRequest, "%v", err)
+    } else if request.Type != MsgStatus {
+        err = protocolErrorf(CodeBadRequest, "tipo de mensaje inesperado: %s", request.Type)
+    } else if incompatible := s.compatible(&status); incompatible != nil {
+        err = protocolErrorf(CodeIncompatible, "%v", incompatible)
+    }
+
+    // Se responde aun cuando el par es incompatible, para que sepa por qué se le desconecta
+    if err := respond(stream, request, s.LocalStatus(), err); err != nil {
+        log.Printf("Error al enviar el estado a %s: %v\n", remote, err)
+    }
+    if err != nil {
+        log.Printf("Desconectando a %s: %v\n", remote, err)
+        s.host.Network().ClosePeer(remote)
+        return
+    }
+
+    s.record(remote, &status)
+}
+
+func (s *StatusService) handshake(id peer.ID) {
+    ctx, cancel := context.WithTimeout(s.ctx, timeouts.Handshake)
+    defer cancel()
+
+    var status Status
+    err := SendRequest(ctx, s.host, id, StatusProtocolID, MsgStatus, s.LocalStatus(), &status)
+    if perr, ok := err.(*ProtocolError); ok {
+        log.Printf("Desconectando a %s: %v\n", id, perr)
+        s.host.Network().ClosePeer(id)
+        return
+    }
+    if err != nil {
+        // Pares que no hablan el protocolo, como los de la DHT pública, simplemente no se registran
+        return
+    }
+
+    s.record(id, &status)
+}
+
+// record guarda el estado anunciado por el par o lo desconecta si es incompatible.
+func (s *StatusService) record(id peer.ID, status *Status) {
+    if err := s.compatible(status); err != nil {
+        log.Printf("Desconectando a %s: %v\n", id, err)
+        s.host.Network().ClosePeer(id)
+        return
+    }
+
+    s.mu.Lock()
+    s.peers[id] = status
+    s.mu.Unlock()
+
+    log.Printf("Estado de %s: altura %d, capacidades %v\n", id, status.Head, status.Capabilities)
+}
+
+func (s *StatusService) compatible(status *Status) error {
+    if status.Version != ProtocolVersion {
+        return fmt.Errorf("versión de protocolo %d incompatible con %d", status.Version, ProtocolVersion)
+    }
+    if status.ChainID != s.chainID {
+        return fmt.Errorf("pertenece a la red %q y no a %q", status.ChainID, s.chainID)
+    }
+
+    local := s.LocalStatus()
+    if local.Genesis != "" && status.Genesis != "" && local.Genesis != status.Genesis {
+        return fmt.Errorf("tiene otro bloque génesis")
+    }
+    return nil
+}
+
+// PeerStatus devuelve lo que anunció el par en el handshake.
+func (s *StatusService) PeerStatus(id peer.ID) (*Status, bool) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    status, ok := s.peers[id]
+    if !ok {
+        return nil, false
+    }
+    copied := *status
+    return &copied, true
+}
+
+// Peers devuelve los pares compatibles, de mayor a menor altura anunciada.
+func (s *StatusService) Peers() []peer.ID {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    var ids []peer.ID
+    for id := range s.peers {
+        ids = append(ids, id)
+    }
+    sort.Slice(ids, func(i, j int) bool { return s.peers[ids[i]].Head > s.peers[ids[j]].Head })
+    return ids
+}
+
+// WaitForPeers espera hasta timeout a que algún par complete el handshake y devuelve los compatibles.
+func (s *StatusService) WaitForPeers(ctx context.Context, timeout time.Duration) []peer.ID {
+    ctx, cancel := context.WithTimeout(ctx, timeout)
+    defer cancel()
+
+    ticker := time.NewTicker(200 * time.Millisecond)
+    defer ticker.Stop()
+
+    for {
+        if peers := s.Peers(); len(peers) > 0 {
+            return peers
+        }
+        select {
+        case <-ctx.Done():
+            return s.Peers()
+        case <-ticker.C:
+        }
+    }
+}
+
+// advertisedStatus devuelve el estado anunciado por el par, si hubo handshake con él.
+func advertisedStatus(id peer.ID) (*Status, bool) {
+    if statusService == nil {
+        return nil, false
+    }
+    return statusService.PeerStatus(id)
+}
+
+// noteHead actualiza la altura conocida de un par cuando se sabe que avanzó, por ejemplo al
+// recibir de él un bloque o una respuesta de sincronización.
+func noteHead(id peer.ID, head int64) {
+    if statusService == nil {
+        return
+    }
+
+    statusService.mu.Lock()
+    defer statusService.mu.Unlock()
+
+    if status, ok := statusService.peers[id]; ok && head > status.Head {
+        status.Head = head
+    }
+}
@@ -0,0 +1,39 @@
+package network
+
+import (
+    "testing"
+)
+
+func TestCompatible(t *testing.T) {
+    blocks, _ := testChain(t, 2)
+    genesis := blocks[0].Hash
+
+    withChain := &StatusService{db: testChainDB(t, blocks), chainID: DefaultChainID}
+    empty := &StatusService{db: testChainDB(t, nil), chainID: DefaultChainID}
+
+    tests := []struct {
+        name    string
+        service *StatusService
+        status  Status
+        ok      bool
+    }{
+        {"misma cadena", withChain, Status{Version: ProtocolVersion, ChainID: DefaultChainID, Genesis: genesis}, true},
+        {"otra versión", withChain, Status{Version: ProtocolVersion + 1, ChainID: DefaultChainID, Genesis: genesis}, false},
+        {"otra red", withChain, Status{Version: ProtocolVersion, ChainID: "otra", Genesis: genesis}, false},
+        {"otro génesis", withChain, Status{Version: ProtocolVersion, ChainID: DefaultChainID, Genesis: "otro"}, false},
+        {"par sin génesis", withChain, Status{Version: ProtocolVersion, ChainID: DefaultChainID, Head: -1}, true},
+        {"nodo local vacío", empty, Status{Version: ProtocolVersion, ChainID: DefaultChainID, Genesis: genesis}, true},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            err := tt.service.compatible(&tt.status)
+            if tt.ok && err != nil {
+                t.Errorf("rechazado: %v", err)
+            }
+            if !tt.ok && err == nil {
+                t.Error("aceptado, se esperaba un error")
+            }
+        })
+    }
+}
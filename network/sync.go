@@ -2,11 +2,10 @@ package network
 
 import (
 	"fmt"
+    "context"
     "log"
-    "bufio"
     "encoding/json"
     "strings"
-    "context"
     "github.com/libp2p/go-libp2p/core/host"
     "github.com/libp2p/go-libp2p/core/network"
     "github.com/libp2p/go-libp2p/core/peer"
@@ -16,57 +15,27 @@ import (
     "blockchain/common"
 )
 
-const SyncProtocolID = "/blockchain/sync/1.0.0"
-
-func SyncDatabase(h host.Host, localDBPath string, activeSeedNodes []string) error {
+// SyncDatabase pone al día la base de datos local pidiendo bloques por altura a los pares conectados.
+// Si no hay pares y la cadena local está vacía se copia la base de datos maestra del nodo, que
+// guarda la cadena al cerrar.
+func SyncDatabase(ctx context.Context, h host.Host, db *leveldb.DB, masterPath string, peers []peer.ID, checkpoints core.Checkpoints) error {
     var err error
-    
-    // Verificar si hay nodos activos para sincronizar
-    if len(activeSeedNodes) > 0 {
-        // Elegir un nodo activo al azar para la sincronización
-        remoteNodeAddr, err := common.ChooseRandomNodeFromEnv(h.ID().String())
-        if err != nil {
-            return fmt.Errorf("error al elegir un nodo activo al azar: %v", err)
-        }
-
-        log.Println("Sincronizando con un nodo activo:", remoteNodeAddr)
-
-        // Convertir la dirección del nodo remoto a peer.AddrInfo
-        remotePeerInfo, err := peer.AddrInfoFromString(remoteNodeAddr)
-        if err != nil {
-            return fmt.Errorf("error al convertir la dirección del nodo remoto: %v", err)
-        }
-
-        // Abrir un stream con el nodo remoto
-        s, err := h.NewStream(context.Background(), remotePeerInfo.ID, SyncProtocolID)
-        if err != nil {
-            return fmt.Errorf("error al abrir stream con el nodo remoto: %v", err)
-        }
-        defer s.Close()
-
-        // Enviar solicitud de sincronización
-        _, err = s.Write([]byte("sync_request\n"))
-        if err != nil {
-            return fmt.Errorf("error al enviar solicitud de sincronización: %v", err)
-        }
 
-        // Recibir datos del nodo remoto
-        var allData map[string]interface{}
-        if err := json.NewDecoder(s).Decode(&allData); err != nil {
-            return fmt.Errorf("error al recibir datos del nodo remoto: %v", err)
-        }
+    // Verificar si hay pares para sincronizar
+    if len(peers) > 0 {
+        log.Printf("Sincronizando con %d pares\n", len(peers))
 
-        err = updateLocalDatabase(localDBPath, allData)
+        err = SyncBlocks(ctx, h, db, peers, checkpoints)
         if err != nil {
-            return fmt.Errorf("error al actualizar la base de datos local: %v", err)
+            return fmt.Errorf("error al sincronizar bloques: %v", err)
         }
 
-    } else {
+    } else if _, err := database.LastBlockIndex(db); err == database.ErrNoBlocks {
         // Sincronizar con la base de datos maestra
 
-        log.Println("No hay nodos activos para sincronizar, sincronizando con la base de datos maestra...")
+        log.Println("No hay pares para sincronizar, sincronizando con la base de datos maestra...")
 
-        err = database.SyncWithMasterDB(localDBPath)
+        err = database.SyncWithMasterDB(masterPath, db)
         if err != nil {
             return fmt.Errorf("error al sincronizar con la base de datos maestra: %v", err)
         }
@@ -75,498 +44,305 @@ func SyncDatabase(h host.Host, localDBPath string, activeSeedNodes []string) err
     return nil
 }
 
-func updateLocalDatabase(localDBPath string, data map[string]interface{}) error {
-    db, err := leveldb.OpenFile(localDBPath, nil)
-    if err != nil {
-        return fmt.Errorf("error al abrir la base de datos local: %v", err)
-    }
-    defer db.Close()
+// BalanceRequest es la solicitud del protocolo /get-balance.
+type BalanceRequest struct {
+    Address string
+}
 
-    for key, value := range data {
-        valueBytes, err := json.Marshal(value)
-        if err != nil {
-            return fmt.Errorf("error al serializar valor para la clave %s: %v", key, err)
-        }
-        if err := db.Put([]byte(key), valueBytes, nil); err != nil {
-            return fmt.Errorf("error al actualizar la base de datos local para la clave %s: %v", key, err)
-        }
-    }
+// BalanceResponse es la respuesta del protocolo /get-balance. Nonce es la cantidad de
+// transacciones que ya envió la cuenta, así que la siguiente lleva Nonce+1, y MinFee la
+// comisión mínima que acepta el nodo.
+type BalanceResponse struct {
+    Address string
+    Balance float64
+    Nonce   int64
+    MinFee  float64
+}
 
-    db.Close()
+// MinFee es la comisión mínima que el nodo exige a las transacciones que recibe. No es una
+// regla de consenso: los bloques de otros nodos pueden incluir transacciones con menos.
+var MinFee float64
 
-    return nil
+// TransactionRequest es la solicitud del protocolo /get-trans.
+type TransactionRequest struct {
+    Hash string
 }
 
-func SetupCreateAccountHandler(h host.Host) {
-    h.SetStreamHandler("/create-account", func(s network.Stream) {
-        defer s.Close()
+// Estados de una transacción en la respuesta del protocolo /get-trans.
+const (
+    TxUnknown   = "unknown"
+    TxPending   = "pending"
+    TxConfirmed = "confirmed"
+    TxPruned    = "pruned"
+)
 
-        log.Println("Solicitud de creación de cuenta recibida. Leyendo solicitud...")
-        // Leer la solicitud del cliente
-        buf := bufio.NewReader(s)
-        msg, err := buf.ReadString('\n')
-        if err != nil {
-            fmt.Println("Error al leer solicitud:", err)
-            return
-        }
+// TransactionInfo es la respuesta del protocolo /get-trans: la transacción con su estado y,
+// si está confirmada, la altura y el hash de su bloque y las confirmaciones, contando ese
+// bloque. Si el bloque fue podado el estado es TxPruned: se informa el bloque, pero no la
+// transacción. Transaction es nil si el nodo no la conoce o si su bloque fue podado, y Height
+// es -1 si la transacción no está en un bloque.
+type TransactionInfo struct {
+    Transaction   *common.Transaction `json:",omitempty"`
+    Status        string
+    Height        int64
+    BlockHash     string `json:",omitempty"`
+    Confirmations int64
+}
 
-        msg = strings.TrimSpace(msg)
+// SendResponse es la respuesta del protocolo /send-balance: el hash de la transacción aceptada
+// y la altura del bloque que la incluye.
+type SendResponse struct {
+    Hash  string
+    Block int64
+}
 
-        log.Println("Solicitud leída:", msg)
-        if msg == "create_account" {
-            log.Println("Procesando creación de cuenta...")
-            // Crear una nueva cuenta
-            user, err := core.NewUser("data/" + h.ID().String(), h, 0)
-            if err != nil {
-                fmt.Println("Error al crear usuario:", err)
-                return
+func SetupCreateAccountHandler(ctx context.Context, h host.Host, db *leveldb.DB) {
+    h.SetStreamHandler("/create-account", func(s network.Stream) {
+        serveStream(ctx, s, MsgCreateAccount, func(ctx context.Context, request *Message) (interface{}, error) {
+            var account common.User
+            if err := request.Decode(&account); err != nil {
+                return nil, protocolErrorf(CodeBadRequest, "%v", err)
             }
-            log.Println("Usuario creado con éxito:", user)
 
-            // Enviar la información del usuario de vuelta al cliente
-            userData, err := json.Marshal(user)
-            if err != nil {
-                fmt.Println("Error al codificar usuario:", err)
-                return
+            // La billetera del cliente genera las claves; el nodo solo registra la clave pública
+            if account.PublicKey == nil {
+                return nil, protocolErrorf(CodeBadRequest, "se requiere la clave pública de la cuenta")
             }
-
-            log.Println("Enviando datos del usuario...")
-            _, err = s.Write(append(userData, '\n'))
-            if err != nil {
-                fmt.Println("Error al enviar datos de usuario:", err)
-                return
+            if account.PublicKey.IsPrivate {
+                return nil, protocolErrorf(CodeBadRequest, "se recibió una clave privada; solo se debe enviar la clave pública")
             }
-            log.Println("Datos del usuario enviados con éxito.")
-        } else {
-            log.Println("Solicitud no válida.")
-        }
-    })
-}
-
-func SetupSyncHandler(h host.Host) {
-    h.SetStreamHandler(SyncProtocolID, func(s network.Stream) {
-        defer s.Close()
-
-        log.Println("Solicitud de sincronización recibida.")
-
-        // Leer la solicitud del nodo solicitante
-        buf := bufio.NewReader(s)
-        msg, err := buf.ReadString('\n')
-        if err != nil {
-            log.Println("Error al leer solicitud:", err)
-            return
-        }
-
-        if strings.TrimSpace(msg) == "sync_request" {
-            // Extraer toda la información de la base de datos
-            dbData, err := extractDBData("data/" + h.ID().String())
-            if err != nil {
-                log.Println("Error al extraer datos de la base de datos:", err)
-                return
+            if account.Address != "" {
+                if err := common.ValidateAddress(account.Address); err != nil {
+                    return nil, protocolErrorf(CodeBadRequest, "%v", err)
+                }
+                if !common.SameAddress(account.Address, common.DeriveAddress(account.PublicKey.Key)) {
+                    return nil, protocolErrorf(CodeBadRequest, "la dirección %s no corresponde a la clave pública", account.Address)
+                }
             }
 
-            // Enviar datos al nodo solicitante
-            _, err = s.Write(dbData)
+            log.Println("Procesando registro de cuenta...")
+            // La lista de usuarios también la reescriben los bloques nuevos
+            chainMu.Lock()
+            user, err := core.RegisterUser(db, account.PublicKey, 0)
+            chainMu.Unlock()
             if err != nil {
-                log.Println("Error al enviar datos de sincronización:", err)
-                return
+                return nil, fmt.Errorf("error al registrar usuario: %v", err)
             }
-
-            log.Println("Datos de sincronización enviados con éxito.")
-        } else {
-            log.Println("Solicitud no reconocida.")
-        }
+            log.Println("Usuario registrado con éxito:", user.Address)
+            return &user, nil
+        })
     })
 }
 
-func SetupGetTransHandler(h host.Host) {
+// SetupGetTransHandler responde el estado de una transacción. Las pendientes se buscan en el
+// mempool de gossip, que puede ser nil.
+func SetupGetTransHandler(ctx context.Context, h host.Host, db *leveldb.DB, gossip *Gossip) {
     h.SetStreamHandler("/get-trans", func(s network.Stream) {
-        defer s.Close()
-
-        log.Println("Solicitud de obtener transacción recibida.")
-        
-        // Leer el hash de la transacción del cliente
-        buf := bufio.NewReader(s)
-        hash, err := buf.ReadString('\n')
-        if err != nil {
-            fmt.Println("Error al leer el hash:", err)
-            return
-        }
-        hash = strings.TrimSpace(hash)
-
-        // Buscar la transacción en la base de datos
-        transaction, err := getTransactionByHash("data/"+h.ID().String(), hash)
-        if err != nil {
-            fmt.Println("Error al obtener la transacción:", err)
-            return
-        }
-
-        // Enviar la transacción al cliente
-        transactionData, err := json.Marshal(transaction)
-        if err != nil {
-            fmt.Println("Error al codificar la transacción:", err)
-            return
-        }
-
-        _, err = s.Write(append(transactionData, '\n'))
-        if err != nil {
-            fmt.Println("Error al enviar la transacción:", err)
-            return
-        }
+        serveStream(ctx, s, MsgGetTransaction, func(ctx context.Context, request *Message) (interface{}, error) {
+            var query TransactionRequest
+            if err := request.Decode(&query); err != nil {
+                return nil, protocolErrorf(CodeBadRequest, "%v", err)
+            }
 
-        log.Println("Transacción enviada con éxito.")
+            var mempool *core.Mempool
+            if gossip != nil {
+                mempool = gossip.Mempool
+            }
+            return lookupTransaction(db, strings.TrimSpace(query.Hash), mempool)
+        })
     })
 }
 
 
-func getTransactionByHash(dbPath, hash string) (*common.Transaction, error) {
-    db, err := leveldb.OpenFile(dbPath, nil)
-    if err != nil {
-        return nil, fmt.Errorf("error al abrir la base de datos: %v", err)
-    }
-    defer db.Close()
-
-    iter := db.NewIterator(nil, nil)
-    for iter.Next() {
-        // Ignorar la clave "USER"
-        if string(iter.Key()) == "USER" {
-            continue
+// lookupTransaction busca la transacción en el índice de transacciones y, si no está en un
+// bloque, en el mempool. Una transacción desconocida no es un error: se informa su estado.
+func lookupTransaction(db *leveldb.DB, hash string, mempool *core.Mempool) (*TransactionInfo, error) {
+    // De los bloques podados se conservan la cabecera y el índice, así que se informa el bloque
+    // aunque ya no se tenga la transacción
+    transaction, height, err := core.FindTransaction(db, hash)
+    if err == nil || err == core.ErrPruned {
+        status := TxConfirmed
+        if err == core.ErrPruned {
+            status = TxPruned
         }
-
-        var block common.Block
-        err := json.Unmarshal(iter.Value(), &block)
+        header, err := core.LoadHeader(db, height)
         if err != nil {
-            continue // o manejar el error
-        }
-
-        for _, tx := range block.Transactions {
-            if tx.Hash == hash {
-                return &tx, nil
-            }
+            return nil, fmt.Errorf("error al cargar el bloque %d: %v", height, err)
         }
+        head, err := database.LastBlockIndex(db)
+        if err != nil {
+            return nil, err
+        }
+        return &TransactionInfo{
+            Transaction:   transaction,
+            Status:        status,
+            Height:        height,
+            BlockHash:     header.Hash,
+            Confirmations: head - height + 1,
+        }, nil
     }
-    iter.Release()
-    if err := iter.Error(); err != nil {
+    if err != leveldb.ErrNotFound {
         return nil, err
     }
 
-    return nil, fmt.Errorf("transacción no encontrada")
+    if mempool != nil {
+        if pending, ok := mempool.Get(hash); ok {
+            return &TransactionInfo{Transaction: &pending, Status: TxPending, Height: -1}, nil
+        }
+    }
+    return &TransactionInfo{Status: TxUnknown, Height: -1}, nil
 }
 
 
-func SetupGetBalanceHandler(h host.Host) {
+func SetupGetBalanceHandler(ctx context.Context, h host.Host, db *leveldb.DB) {
     h.SetStreamHandler("/get-balance", func(s network.Stream) {
-        defer s.Close()
-
-        log.Println("Solicitud de obtener saldo recibida.")
-        // Leer la dirección del cliente
-        buf := bufio.NewReader(s)
-        address, err := buf.ReadString('\n')
-        if err != nil {
-            fmt.Println("Error al leer la dirección:", err)
-            return
-        }
-        address = strings.TrimSpace(address)
-
-        // Obtener el saldo de la dirección
-        balance, err := getBalance(address, "data/"+h.ID().String())
-        if err != nil {
-            fmt.Println("Error al obtener el saldo:", err)
-            return
-        }
-
-        // Enviar el saldo al cliente
-        _, err = s.Write([]byte(fmt.Sprintf("%f\n", balance)))
-        if err != nil {
-            fmt.Println("Error al enviar el saldo:", err)
-            return
-        }
+        serveStream(ctx, s, MsgGetBalance, func(ctx context.Context, request *Message) (interface{}, error) {
+            var query BalanceRequest
+            if err := request.Decode(&query); err != nil {
+                return nil, protocolErrorf(CodeBadRequest, "%v", err)
+            }
+            address := strings.TrimSpace(query.Address)
+            if err := common.ValidateAddress(address); err != nil {
+                return nil, protocolErrorf(CodeBadRequest, "%v", err)
+            }
 
-        log.Println("Saldo enviado con éxito.")
+            // Obtener el saldo y el nonce de la dirección
+            user, err := getAccount(common.AddressKey(address), db)
+            if err != nil {
+                return nil, err
+            }
+            return &BalanceResponse{Address: address, Balance: user.Balance, Nonce: user.Nonce, MinFee: MinFee}, nil
+        })
     })
 }
 
-func SetupSendHandler(h host.Host) {
+// SetupSendHandler procesa las transacciones firmadas que envían los clientes.
+func SetupSendHandler(ctx context.Context, h host.Host, db *leveldb.DB, gossip *Gossip) {
     h.SetStreamHandler("/send-balance", func(s network.Stream) {
-        defer s.Close()
-
-        log.Println("Solicitud de envío de saldo recibida.")
-
-        // Leer la transacción del cliente
-        buf := bufio.NewReader(s)
-        transactionData, err := buf.ReadString('\n')
-        if err != nil {
-            log.Printf("Error al leer la transacción: %v\n", err)
-            return
-        }
-
-        if transactionData == "" {
-            log.Println("No se recibieron datos de transacción.")
-            return
-        }
-
-        log.Printf("Datos de transacción recibidos: %s\n", transactionData)
-
-        var transaction common.Transaction
-        err = json.Unmarshal([]byte(transactionData), &transaction)
-        if err != nil {
-            log.Printf("Error al decodificar la transacción: %v\n", err)
-            return
-        }
-
-        log.Printf("Transacción decodificada: %+v\n", transaction)
-
-        // Procesar la transacción
-        err = processTransaction(transaction, "data/"+h.ID().String())
-        if err != nil {
-            log.Printf("Error al procesar la transacción: %v\n", err)
-            response := fmt.Sprintf("Error al procesar la transacción: %v\n", err)
-            _, err = s.Write([]byte(response))
-            if err != nil {
-                log.Printf("Error al enviar respuesta: %v\n", err)
-                return
+        serveStream(ctx, s, MsgSendTransaction, func(ctx context.Context, request *Message) (interface{}, error) {
+            var transaction common.Transaction
+            if err := request.Decode(&transaction); err != nil {
+                return nil, protocolErrorf(CodeBadRequest, "%v", err)
             }
-        }
-
-        // Enviar respuesta al cliente
-        response := "Transacción procesada con éxito."
-        _, err = s.Write([]byte(response + "\n"))
-        if err != nil {
-            log.Printf("Error al enviar respuesta: %v\n", err)
-            return
-        }
-
-        log.Println("Respuesta enviada al cliente.")
+            return SubmitTransaction(ctx, transaction, db, gossip)
+        })
     })
 }
 
-func processTransaction(transaction common.Transaction, dbPath string) error {
-    err := updateBalances(transaction, dbPath)
-    if err != nil {
-        return fmt.Errorf("error al actualizar saldos: %v", err)
-    }
+// SubmitTransaction agrega a la cadena una transacción firmada, recibida por p2p o por la API
+// REST, y anuncia por gossip la transacción y el bloque que la incluye. El nodo no firma: la
+// transacción puede haberse construido y firmado sin conexión en otra máquina.
+func SubmitTransaction(ctx context.Context, transaction common.Transaction, db *leveldb.DB, gossip *Gossip) (*SendResponse, error) {
+    log.Printf("Transacción recibida: %+v\n", transaction)
 
-    lastblock, err := database.GetLastBlockIndex(dbPath)
-    if err != nil {
-        return fmt.Errorf("error al obtener el último bloque: %v", err)
+    if err := common.VerifyTransactionSignature(transaction); err != nil {
+        return nil, protocolErrorf(CodeRejected, "%v", err)
+    }
+    if err := core.ValidateTransaction(transaction, core.Pending); err != nil {
+        return nil, protocolErrorf(CodeRejected, "%v", err)
     }
 
-    log.Println("Último bloque:", lastblock)
-
-    // verifica si es bloque genesis o index 0
-
-    dbMaster, err := leveldb.OpenFile("data/master", nil)
-    if err != nil {
-        return fmt.Errorf("error al abrir la base de datos maestra: %v", err)
+    // Sin nonce una transacción ya incluida en un bloque se podría volver a enviar, así que
+    // solo los bloques anteriores pueden tener transacciones sin él
+    if transaction.Nonce == 0 {
+        return nil, protocolErrorf(CodeRejected, "la transacción no lleva nonce; constrúyala con el nonce de la cuenta")
+    }
+    if transaction.Fee < MinFee {
+        return nil, protocolErrorf(CodeRejected, "comisión insuficiente: el nodo exige al menos %f", MinFee)
     }
-    defer dbMaster.Close()
 
-    block, err := core.LoadBlock(dbMaster, lastblock)
+    // Procesar la transacción
+    chainMu.Lock()
+    block, err := processTransaction(transaction, db)
+    chainMu.Unlock()
     if err != nil {
-        return fmt.Errorf("error al cargar el último bloque: %v", err)
+        return nil, protocolErrorf(CodeRejected, "error al procesar la transacción: %v", err)
     }
 
-    dbMaster.Close()
-
-    // ver cantidad de transacciones en el bloque
-
-    if len(block.Transactions) >= 5 {
-        log.Println("Creando nuevo bloque...")
-        // crear nuevo bloque
-        newBlock := core.GenerateBlock(lastblock+1, block.Header.Hash, []common.Transaction{transaction}, lastblock+1)
-        // agremos la transacción al nuevo bloque
-        newBlock.Transactions = append(newBlock.Transactions, transaction)
-
-        // guardar el nuevo bloque
-        // save en master
-        masterDB, err := leveldb.OpenFile("data/master", nil)
-        if err != nil {
-            return fmt.Errorf("error al abrir la base de datos maestra: %v", err)
-        }
-        defer masterDB.Close()
-
-        localDB, err := leveldb.OpenFile(dbPath, nil)
-        if err != nil {
-            return fmt.Errorf("error al abrir la base de datos local: %v", err)
-        }
-        defer localDB.Close()
-
-        err = core.SaveBlock(masterDB, newBlock)
-        err = core.SaveBlock(localDB, newBlock)
-
-        masterDB.Close()
-        localDB.Close()
-
-    } else {
-        // agregar transacción al bloque
-        log.Println("Agregando transacción al bloque...")
-        block.Transactions = append(block.Transactions, transaction)
-        
-        masterDB, err := leveldb.OpenFile("data/master", nil)
-        if err != nil {
-            return fmt.Errorf("error al abrir la base de datos maestra: %v", err)
+    if gossip != nil {
+        if err := gossip.PublishTransaction(ctx, transaction); err != nil {
+            log.Printf("Error al anunciar la transacción: %v\n", err)
         }
-        defer masterDB.Close()
-
-        localDB, err := leveldb.OpenFile(dbPath, nil)
-        if err != nil {
-            return fmt.Errorf("error al abrir la base de datos local: %v", err)
+        if err := gossip.PublishBlock(ctx, *block); err != nil {
+            log.Printf("Error al anunciar el bloque: %v\n", err)
         }
-        defer localDB.Close()
-
-        err = core.SaveBlock(masterDB, *block)
-        err = core.SaveBlock(localDB, *block)
-
-        masterDB.Close()
-        localDB.Close()
     }
 
-    return nil
+    return &SendResponse{Hash: transaction.Hash, Block: block.Header.Index}, nil
 }
 
-func updateBalances(transaction common.Transaction, dbPath string) error {
-    // Abrir la base de datos maestra
-    masterDB, err := leveldb.OpenFile("data/master", nil)
+// processTransaction agrega la transacción a la cadena en un bloque nuevo a continuación del
+// último y devuelve ese bloque. Un bloque se sella una sola vez: los pares que ya lo recibieron
+// tienen su hash, así que nunca se le agregan transacciones después. Los saldos se actualizan
+// al guardar el bloque, en la misma escritura, así que una transacción rechazada no los cambia.
+func processTransaction(transaction common.Transaction, db *leveldb.DB) (*common.Block, error) {
+    lastblock, err := database.LastBlockIndex(db)
     if err != nil {
-        return fmt.Errorf("error al abrir la base de datos maestra: %v", err)
+        return nil, fmt.Errorf("error al obtener el último bloque: %v", err)
     }
-    defer masterDB.Close()
 
-    // Obtener los usuarios de la base de datos maestra
-    usersData, err := masterDB.Get([]byte("USER"), nil)
-    if err != nil {
-        return fmt.Errorf("error al obtener datos de usuarios: %v", err)
-    }
+    log.Println("Último bloque:", lastblock)
 
-    var users []*common.User
-    err = json.Unmarshal(usersData, &users)
+    prev, err := core.LoadBlock(db, lastblock)
     if err != nil {
-        return fmt.Errorf("error al deserializar usuarios: %v", err)
-    }
-
-    // Buscar el remitente y el destinatario en la lista de usuarios
-    var sender, recipient *common.User
-    for _, user := range users {
-        if user.Address == transaction.Sender {
-            sender = user
-        } else if user.Address == transaction.Recipient {
-            recipient = user
-        }
-    }
-
-    // Verificar si el remitente tiene saldo suficiente
-
-    if sender == nil {
-        return fmt.Errorf("remitente no encontrado")
-    }
-
-    if recipient == nil {
-        return fmt.Errorf("destinatario no encontrado")
-    }
-
-    if sender.Balance < transaction.Ammount {
-        return fmt.Errorf("saldo insuficiente")
+        return nil, fmt.Errorf("error al cargar el último bloque: %v", err)
     }
 
-    // Actualizar los saldos
-    sender.Balance -= transaction.Ammount
-    recipient.Balance += transaction.Ammount
-
-    // Actualizar la base de datos local
-    updatedUsersData, err := json.Marshal(users)
+    // La cabecera del bloque nuevo compromete el estado tras el bloque anterior
+    stateHash, snapshot, err := core.CommitState(db, lastblock)
     if err != nil {
-        return fmt.Errorf("error al serializar usuarios actualizados: %v", err)
+        return nil, fmt.Errorf("error al calcular el compromiso de estado: %v", err)
     }
-
-    err = masterDB.Put([]byte("USER"), updatedUsersData, nil)
+    state, err := core.StateAt(db, lastblock)
     if err != nil {
-        return fmt.Errorf("error al actualizar usuarios en la base de datos local: %v", err)
+        return nil, err
     }
 
-    // Actualizar la base de datos local
+    log.Println("Creando nuevo bloque...")
+    newBlock := core.GenerateBlock(lastblock+1, prev.Hash, []common.Transaction{transaction}, lastblock+1)
+    newBlock.Header.StateHash = stateHash
+    core.SealBlock(&newBlock)
 
-    // Abrir la base de datos local
-
-    db, err := leveldb.OpenFile(dbPath, nil)
-    if err != nil {
-        return fmt.Errorf("error al abrir la base de datos local: %v", err)
+    // El bloque se valida como cualquier otro antes de guardarlo: si el remitente no tiene
+    // saldo o el nonce no es el siguiente, la transacción se rechaza
+    validator := core.NewChainValidator(prev, state)
+    if err := validator.Add(newBlock); err != nil {
+        return nil, err
     }
 
-    // Actualizar el saldo del remitente
-
-    err = db.Put([]byte("USER"), updatedUsersData, nil)
+    if snapshot != nil {
+        log.Println("Guardando instantánea de estado en la altura", snapshot.Height)
+        if err := core.SaveSnapshot(db, snapshot); err != nil {
+            log.Printf("Error al guardar la instantánea: %v\n", err)
+        }
+    }
 
-    masterDB.Close()
-    db.Close()
+    if err := core.SaveBlocks(db, []common.Block{newBlock}, validator.State()); err != nil {
+        return nil, fmt.Errorf("error al guardar el bloque %d: %v", newBlock.Index, err)
+    }
 
-    return nil
+    return &newBlock, nil
 }
 
-func getBalance(address, dbPath string) (float64, error) {
-    db, err := leveldb.OpenFile(dbPath, nil)
-    if err != nil {
-        return 0, fmt.Errorf("error al abrir la base de datos: %v", err)
-    }
-    defer db.Close()
-
+// getAccount devuelve la cuenta de una dirección en su forma canónica.
+func getAccount(address string, db *leveldb.DB) (*common.User, error) {
     // Aquí asumimos que los datos del usuario están almacenados bajo la clave "USER"
     data, err := db.Get([]byte("USER"), nil)
     if err != nil {
-        return 0, fmt.Errorf("error al obtener datos de usuarios: %v", err)
+        return nil, fmt.Errorf("error al obtener datos de usuarios: %v", err)
     }
 
     var users []*common.User
     err = json.Unmarshal(data, &users)
     if err != nil {
-        return 0, fmt.Errorf("error al deserializar usuarios: %v", err)
+        return nil, fmt.Errorf("error al deserializar usuarios: %v", err)
     }
 
     for _, user := range users {
         if user.Address == address {
-            return user.Balance, nil
+            return user, nil
         }
     }
 
-    db.Close()
-
-    return 0, fmt.Errorf("dirección no encontrada")
+    return nil, protocolErrorf(CodeNotFound, "dirección no encontrada")
 }
-
-// extractDBData extrae los datos de la base de datos para la sincronización.
-func extractDBData(dbPath string) ([]byte, error) {
-    // Abrir la base de datos
-    db, err := leveldb.OpenFile(dbPath, nil)
-    if err != nil {
-        return nil, fmt.Errorf("error al abrir la base de datos: %v", err)
-    }
-    defer db.Close()
-
-    // Crear un mapa para almacenar todos los datos
-    allData := make(map[string]interface{})
-
-    // Iterar sobre todos los elementos en la base de datos
-    iter := db.NewIterator(nil, nil)
-    for iter.Next() {
-        key := iter.Key()
-        value := iter.Value()
-
-        var data interface{}
-        if err := json.Unmarshal(value, &data); err != nil {
-            return nil, fmt.Errorf("error al deserializar el valor para la clave %s: %v", key, err)
-        }
-
-        allData[string(key)] = data
-    }
-    iter.Release()
-    if err := iter.Error(); err != nil {
-        return nil, fmt.Errorf("error al iterar sobre la base de datos: %v", err)
-    }
-
-    // Serializar el mapa completo a JSON
-    jsonData, err := json.Marshal(allData)
-    if err != nil {
-        return nil, fmt.Errorf("error al serializar los datos de la base de datos: %v", err)
-    }
-
-    return jsonData, nil
-}
\ No newline at end of file
@@ -0,0 +1,146 @@
+package network
+
+import (
+    "net/http"
+    "strings"
+    "testing"
+    "blockchain/common"
+    "blockchain/core"
+    "blockchain/database"
+    "blockchain/wallet"
+)
+
+func TestProcessTransaction(t *testing.T) {
+    blocks, founder := testChain(t, 2)
+    db := testChainDB(t, blocks)
+    recipient, err := wallet.DeriveAccount(testMnemonic, "", wallet.AddressPath(0, 2))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    transfer := func(amount float64, nonce int64) common.Transaction {
+        transaction, err := wallet.NewTransaction(founder.Address, recipient.Address, amount, 0, nonce)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if err := founder.Sign(transaction); err != nil {
+            t.Fatal(err)
+        }
+        return *transaction
+    }
+
+    balance := func(address string) float64 {
+        account, err := getAccount(common.AddressKey(address), db)
+        if err != nil {
+            t.Fatal(err)
+        }
+        return account.Balance
+    }
+    initial := balance(founder.Address)
+
+    tests := []struct {
+        name        string
+        transaction common.Transaction
+        valid       bool
+    }{
+        {"nonce repetido", transfer(1, 2), false},
+        {"nonce adelantado", transfer(1, 4), false},
+        {"saldo insuficiente", transfer(initial+1, 3), false},
+        {"válida", transfer(5, 3), true},
+    }
+
+    for _, test := range tests {
+        head, err := database.LastBlockIndex(db)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        block, err := processTransaction(test.transaction, db)
+        if !test.valid {
+            if err == nil {
+                t.Errorf("%s: se aceptó", test.name)
+                continue
+            }
+            // Una transacción rechazada no deja bloque ni cambia los saldos
+            if current, _ := database.LastBlockIndex(db); current != head {
+                t.Errorf("%s: la cabeza pasó de %d a %d", test.name, head, current)
+            }
+            if balance(founder.Address) != initial {
+                t.Errorf("%s: cambió el saldo del remitente", test.name)
+            }
+            continue
+        }
+
+        if err != nil {
+            t.Errorf("%s: se rechazó: %v", test.name, err)
+            continue
+        }
+        if block.Index != head+1 || block.StateHash == "" {
+            t.Errorf("%s: el bloque %d no sigue a la cabeza o no compromete el estado", test.name, block.Index)
+        }
+        if balance(founder.Address) != initial-5 || balance(recipient.Address) != 5 {
+            t.Errorf("%s: saldos del remitente %f y del destinatario %f", test.name, balance(founder.Address), balance(recipient.Address))
+        }
+    }
+}
+
+func TestLookupTransaction(t *testing.T) {
+    blocks, founder := testChain(t, 5)
+    db := testChainDB(t, blocks)
+    // Se conservan los cuerpos de los dos últimos bloques: los anteriores al 3 se podan
+    if _, err := core.PruneBlocks(db, 2); err != nil {
+        t.Fatal(err)
+    }
+
+    recipient, err := wallet.DeriveAccount(testMnemonic, "", wallet.AddressPath(0, 1))
+    if err != nil {
+        t.Fatal(err)
+    }
+    pending, err := wallet.NewTransaction(founder.Address, recipient.Address, 1, 0, 6)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := founder.Sign(pending); err != nil {
+        t.Fatal(err)
+    }
+    mempool := core.NewMempool()
+    if _, err := mempool.Add(*pending); err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        name          string
+        hash          string
+        status        string
+        height        int64
+        confirmations int64
+        transaction   bool
+        code          int
+    }{
+        {"confirmada", blocks[5].Transactions[0].Hash, TxConfirmed, 5, 1, true, http.StatusOK},
+        {"confirmada en el primer bloque completo", blocks[3].Transactions[0].Hash, TxConfirmed, 3, 3, true, http.StatusOK},
+        {"bloque podado", blocks[1].Transactions[0].Hash, TxPruned, 1, 5, false, http.StatusGone},
+        {"pendiente", pending.Hash, TxPending, -1, 0, true, http.StatusOK},
+        {"desconocida", strings.Repeat("0", 64), TxUnknown, -1, 0, false, http.StatusNotFound},
+    }
+
+    for _, test := range tests {
+        info, err := lookupTransaction(db, test.hash, mempool)
+        if err != nil {
+            t.Errorf("%s: %v", test.name, err)
+            continue
+        }
+        if info.Status != test.status || info.Height != test.height || info.Confirmations != test.confirmations {
+            t.Errorf("%s: estado %s, altura %d y %d confirmaciones", test.name, info.Status, info.Height, info.Confirmations)
+        }
+        if (info.Transaction != nil) != test.transaction {
+            t.Errorf("%s: se esperaba la transacción: %v", test.name, test.transaction)
+        }
+        if test.height >= 0 && info.BlockHash != blocks[test.height].Hash {
+            t.Errorf("%s: hash de bloque %s", test.name, info.BlockHash)
+        }
+        if code := TransactionStatusCode(info); code != test.code {
+            t.Errorf("%s: estado HTTP %d, se esperaba %d", test.name, code, test.code)
+        }
+    }
+}
@@ -0,0 +1,46 @@
+package network
+
+import (
+    "time"
+)
+
+// Timeouts son los plazos de las operaciones de red que inicia el nodo. Los plazos de las
+// solicitudes que atiende están en StreamLimits.
+type Timeouts struct {
+    // Request limita cada solicitud a otro nodo, incluidas las de sincronización.
+    Request time.Duration
+    // Snapshot limita la descarga de una instantánea, que puede ser mucho mayor.
+    Snapshot time.Duration
+    // Handshake limita el intercambio de estado al conectar con un par.
+    Handshake time.Duration
+    // Dial limita cada intento de conexión con un par.
+    Dial time.Duration
+}
+
+// DefaultTimeouts son los plazos que se usan si no se configuran otros.
+var DefaultTimeouts = Timeouts{
+    Request:   30 * time.Second,
+    Snapshot:  2 * time.Minute,
+    Handshake: StatusTimeout,
+    Dial:      10 * time.Second,
+}
+
+var timeouts = DefaultTimeouts
+
+// SetTimeouts cambia los plazos de las operaciones de red. Los plazos en cero conservan el valor
+// por defecto.
+func SetTimeouts(t Timeouts) {
+    if t.Request <= 0 {
+        t.Request = DefaultTimeouts.Request
+    }
+    if t.Snapshot <= 0 {
+        t.Snapshot = DefaultTimeouts.Snapshot
+    }
+    if t.Handshake <= 0 {
+        t.Handshake = DefaultTimeouts.Handshake
+    }
+    if t.Dial <= 0 {
+        t.Dial = DefaultTimeouts.Dial
+    }
+    timeouts = t
+}
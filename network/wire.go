@@ -0,0 +1,292 @@
+package network
+
+import (
+    "fmt"
+    "strings"
+    "google.golang.org/protobuf/proto"
+    "blockchain/common"
+    "blockchain/network/pb"
+)
+
+// toWire convierte el contenido de un mensaje en su tipo del esquema protobuf.
+func toWire(v interface{}) (proto.Message, error) {
+    switch v := v.(type) {
+    case *Status:
+        genesis, err := pb.DecodeHash(v.Genesis)
+        if err != nil {
+            return nil, err
+        }
+        headHash, err := pb.DecodeHash(v.HeadHash)
+        if err != nil {
+            return nil, err
+        }
+        return &pb.Status{
+            Version:      int32(v.Version),
+            ChainId:      v.ChainID,
+            Genesis:      genesis,
+            Head:         v.Head,
+            HeadHash:     headHash,
+            Base:         v.Base,
+            Capabilities: v.Capabilities,
+        }, nil
+
+    case *SyncRequest:
+        return &pb.SyncRequest{Type: v.Type, From: v.From, Count: v.Count}, nil
+
+    case *SyncResponse:
+        hash, err := pb.DecodeHash(v.Hash)
+        if err != nil {
+            return nil, err
+        }
+        headers, err := pb.FromHeaders(v.Headers)
+        if err != nil {
+            return nil, err
+        }
+        blocks, err := pb.FromBlocks(v.Blocks)
+        if err != nil {
+            return nil, err
+        }
+        return &pb.SyncResponse{Head: v.Head, Hash: hash, Base: v.Base, Headers: headers, Blocks: blocks}, nil
+
+    case *SnapshotResponse:
+        out := &pb.SnapshotResponse{}
+        var err error
+        if v.Snapshot != nil {
+            if out.Snapshot, err = pb.FromSnapshot(*v.Snapshot); err != nil {
+                return nil, err
+            }
+        }
+        if v.Anchor != nil {
+            if out.Anchor, err = pb.FromBlock(*v.Anchor); err != nil {
+                return nil, err
+            }
+        }
+        if out.Blocks, err = pb.FromBlocks(v.Blocks); err != nil {
+            return nil, err
+        }
+        return out, nil
+
+    case *common.User:
+        return pb.FromUser(*v), nil
+
+    case *BalanceRequest:
+        return &pb.BalanceRequest{Address: v.Address}, nil
+
+    case *BalanceResponse:
+        return &pb.BalanceResponse{Address: v.Address, Balance: v.Balance, Nonce: v.Nonce, MinFee: v.MinFee}, nil
+
+    case *TransactionRequest:
+        hash, err := pb.DecodeHash(v.Hash)
+        if err != nil {
+            return nil, err
+        }
+        return &pb.TransactionRequest{Hash: hash}, nil
+
+    case *common.Transaction:
+        return pb.FromTransaction(*v)
+
+    case *SendResponse:
+        hash, err := pb.DecodeHash(v.Hash)
+        if err != nil {
+            return nil, err
+        }
+        return &pb.SendResponse{Hash: hash, Block: v.Block}, nil
+
+    case *TransactionInfo:
+        blockHash, err := pb.DecodeHash(v.BlockHash)
+        if err != nil {
+            return nil, err
+        }
+        out := &pb.TransactionInfo{
+            Status:        pb.TransactionStatus(pb.TransactionStatus_value[strings.ToUpper(v.Status)]),
+            Height:        v.Height,
+            BlockHash:     blockHash,
+            Confirmations: v.Confirmations,
+        }
+        if v.Transaction != nil {
+            if out.Transaction, err = pb.FromTransaction(*v.Transaction); err != nil {
+                return nil, err
+            }
+        }
+        return out, nil
+    }
+
+    return nil, fmt.Errorf("tipo de mensaje no admitido: %T", v)
+}
+
+// fromWire convierte el contenido recibido en v, que debe ser un puntero al tipo que se espera.
+func fromWire(m proto.Message, v interface{}) error {
+    var ok bool
+
+    switch v := v.(type) {
+    case *Status:
+        var in *pb.Status
+        if in, ok = m.(*pb.Status); ok {
+            *v = Status{
+                Version:      int(in.Version),
+                ChainID:      in.ChainId,
+                Genesis:      pb.EncodeHash(in.Genesis),
+                Head:         in.Head,
+                HeadHash:     pb.EncodeHash(in.HeadHash),
+                Base:         in.Base,
+                Capabilities: in.Capabilities,
+            }
+        }
+
+    case *SyncRequest:
+        var in *pb.SyncRequest
+        if in, ok = m.(*pb.SyncRequest); ok {
+            *v = SyncRequest{Type: in.Type, From: in.From, Count: in.Count}
+        }
+
+    case *SyncResponse:
+        var in *pb.SyncResponse
+        if in, ok = m.(*pb.SyncResponse); ok {
+            *v = SyncResponse{
+                Head:    in.Head,
+                Hash:    pb.EncodeHash(in.Hash),
+                Base:    in.Base,
+                Headers: pb.ToHeaders(in.Headers),
+                Blocks:  pb.ToBlocks(in.Blocks),
+            }
+        }
+
+    case *SnapshotResponse:
+        var in *pb.SnapshotResponse
+        if in, ok = m.(*pb.SnapshotResponse); ok {
+            *v = SnapshotResponse{Blocks: pb.ToBlocks(in.Blocks)}
+            if in.Snapshot != nil {
+                snapshot := pb.ToSnapshot(in.Snapshot)
+                v.Snapshot = &snapshot
+            }
+            if in.Anchor != nil {
+                anchor := pb.ToBlock(in.Anchor)
+                v.Anchor = &anchor
+            }
+        }
+
+    case *common.User:
+        var in *pb.User
+        if in, ok = m.(*pb.User); ok {
+            user, err := pb.ToUser(in)
+            if err != nil {
+                return err
+            }
+            *v = user
+        }
+
+    case *BalanceRequest:
+        var in *pb.BalanceRequest
+        if in, ok = m.(*pb.BalanceRequest); ok {
+            *v = BalanceRequest{Address: in.Address}
+        }
+
+    case *BalanceResponse:
+        var in *pb.BalanceResponse
+        if in, ok = m.(*pb.BalanceResponse); ok {
+            *v = BalanceResponse{Address: in.Address, Balance: in.Balance, Nonce: in.Nonce, MinFee: in.MinFee}
+        }
+
+    case *TransactionRequest:
+        var in *pb.TransactionRequest
+        if in, ok = m.(*pb.TransactionRequest); ok {
+            *v = TransactionRequest{Hash: pb.EncodeHash(in.Hash)}
+        }
+
+    case *common.Transaction:
+        var in *pb.Transaction
+        if in, ok = m.(*pb.Transaction); ok {
+            *v = pb.ToTransaction(in)
+        }
+
+    case *SendResponse:
+        var in *pb.SendResponse
+        if in, ok = m.(*pb.SendResponse); ok {
+            *v = SendResponse{Hash: pb.EncodeHash(in.Hash), Block: in.Block}
+        }
+
+    case *TransactionInfo:
+        var in *pb.TransactionInfo
+        if in, ok = m.(*pb.TransactionInfo); ok {
+            *v = TransactionInfo{
+                Status:        strings.ToLower(in.Status.String()),
+                Height:        in.Height,
+                BlockHash:     pb.EncodeHash(in.BlockHash),
+                Confirmations: in.Confirmations,
+            }
+            if in.Transaction != nil {
+                transaction := pb.ToTransaction(in.Transaction)
+                v.Transaction = &transaction
+            }
+        }
+
+    default:
+        return fmt.Errorf("tipo de mensaje no admitido: %T", v)
+    }
+
+    if !ok {
+        return fmt.Errorf("se esperaba %T y se recibió %T", v, m)
+    }
+    return nil
+}
+
+// setBody coloca m en el campo del sobre que le corresponde.
+func setBody(envelope *pb.Envelope, m proto.Message) error {
+    switch m := m.(type) {
+    case nil:
+    case *pb.Status:
+        envelope.Body = &pb.Envelope_Status{Status: m}
+    case *pb.SyncRequest:
+        envelope.Body = &pb.Envelope_SyncRequest{SyncRequest: m}
+    case *pb.SyncResponse:
+        envelope.Body = &pb.Envelope_SyncResponse{SyncResponse: m}
+    case *pb.SnapshotResponse:
+        envelope.Body = &pb.Envelope_SnapshotResponse{SnapshotResponse: m}
+    case *pb.User:
+        envelope.Body = &pb.Envelope_User{User: m}
+    case *pb.BalanceRequest:
+        envelope.Body = &pb.Envelope_BalanceRequest{BalanceRequest: m}
+    case *pb.BalanceResponse:
+        envelope.Body = &pb.Envelope_BalanceResponse{BalanceResponse: m}
+    case *pb.TransactionRequest:
+        envelope.Body = &pb.Envelope_TransactionRequest{TransactionRequest: m}
+    case *pb.Transaction:
+        envelope.Body = &pb.Envelope_Transaction{Transaction: m}
+    case *pb.SendResponse:
+        envelope.Body = &pb.Envelope_SendResponse{SendResponse: m}
+    case *pb.TransactionInfo:
+        envelope.Body = &pb.Envelope_TransactionInfo{TransactionInfo: m}
+    default:
+        return fmt.Errorf("tipo de mensaje no admitido: %T", m)
+    }
+    return nil
+}
+
+// getBody devuelve el contenido del sobre, o nil si no tiene.
+func getBody(envelope *pb.Envelope) proto.Message {
+    switch body := envelope.Body.(type) {
+    case *pb.Envelope_Status:
+        return body.Status
+    case *pb.Envelope_SyncRequest:
+        return body.SyncRequest
+    case *pb.Envelope_SyncResponse:
+        return body.SyncResponse
+    case *pb.Envelope_SnapshotResponse:
+        return body.SnapshotResponse
+    case *pb.Envelope_User:
+        return body.User
+    case *pb.Envelope_BalanceRequest:
+        return body.BalanceRequest
+    case *pb.Envelope_BalanceResponse:
+        return body.BalanceResponse
+    case *pb.Envelope_TransactionRequest:
+        return body.TransactionRequest
+    case *pb.Envelope_Transaction:
+        return body.Transaction
+    case *pb.Envelope_SendResponse:
+        return body.SendResponse
+    case *pb.Envelope_TransactionInfo:
+        return body.TransactionInfo
+    }
+    return nil
+}
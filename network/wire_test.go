@@ -0,0 +1,122 @@
+package network
+
+import (
+    "bytes"
+    "reflect"
+    "testing"
+    "blockchain/common"
+    "blockchain/network/pb"
+)
+
+const (
+    testHash  = "9d84ec3d881ce07c64476d217abc1c2f23599e1fc9014d996a331d442f8a4de0"
+    testHash2 = "0a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f60718293a4b5c6d7e8f9"
+)
+
+func testBlock(index int64) common.Block {
+    return common.Block{
+        Header: common.Header{Index: index, PrevBlock: testHash, Hash: testHash2, TimeStamp: 1700000000, TxRoot: testHash},
+        Transactions: []common.Transaction{
+            {Index: 0, Sender: "a", Recipient: "b", Ammount: 1, Signature: "OSCURT", TimeStamp: 1700000000, Hash: testHash},
+        },
+    }
+}
+
+// TestMessageRoundTrip envía cada tipo de mensaje por el codec, en binario y en JSON, y
+// comprueba que se recibe igual.
+func TestMessageRoundTrip(t *testing.T) {
+    snapshot := common.Snapshot{Height: 4, BlockHash: testHash, StateHash: testHash2, Accounts: []common.Account{{Address: "a", Balance: 1}}}
+    anchor := testBlock(4)
+
+    messages := []struct {
+        msgType string
+        payload interface{}
+        target  interface{}
+    }{
+        {MsgStatus, &Status{Version: ProtocolVersion, ChainID: DefaultChainID, Genesis: testHash, Head: 9, HeadHash: testHash2, Base: 2, Capabilities: []string{CapabilityPruned, CapabilitySnapshots}}, &Status{}},
+        {MsgSync, &SyncRequest{Type: SyncRequestBlocks, From: 3, Count: 10}, &SyncRequest{}},
+        {MsgSync, &SyncResponse{Head: 9, Hash: testHash, Base: 0, Headers: []common.Header{testBlock(1).Header}, Blocks: []common.Block{testBlock(1), testBlock(2)}}, &SyncResponse{}},
+        {MsgSnapshot, &SnapshotResponse{Snapshot: &snapshot, Anchor: &anchor, Blocks: []common.Block{testBlock(5)}}, &SnapshotResponse{}},
+        {MsgCreateAccount, &common.User{Address: "a", Balance: 3, Nonce: 1}, &common.User{}},
+        {MsgGetBalance, &BalanceRequest{Address: "a"}, &BalanceRequest{}},
+        {MsgGetBalance, &BalanceResponse{Address: "a", Balance: 2.5, Nonce: 3, MinFee: 0.1}, &BalanceResponse{}},
+        {MsgGetTransaction, &TransactionRequest{Hash: testHash}, &TransactionRequest{}},
+        {MsgGetTransaction, &TransactionInfo{Transaction: &testBlock(0).Transactions[0], Status: TxConfirmed, Height: 3, BlockHash: testHash2, Confirmations: 2}, &TransactionInfo{}},
+        {MsgGetTransaction, &TransactionInfo{Status: TxUnknown, Height: -1}, &TransactionInfo{}},
+        {MsgSendTransaction, &testBlock(0).Transactions[0], &common.Transaction{}},
+        {MsgSendTransaction, &SendResponse{Hash: testHash, Block: 3}, &SendResponse{}},
+    }
+
+    defer func(debug bool) { pb.DebugJSON = debug }(pb.DebugJSON)
+
+    for _, debug := range []bool{false, true} {
+        pb.DebugJSON = debug
+        for _, m := range messages {
+            request, err := NewRequest(m.msgType, m.payload)
+            if err != nil {
+                t.Fatalf("%T: %v", m.payload, err)
+            }
+
+            var buf bytes.Buffer
+            if err := WriteMessage(&buf, request); err != nil {
+                t.Fatalf("%T: error al escribir: %v", m.payload, err)
+            }
+            if debug && buf.Bytes()[4] != '{' {
+                t.Fatalf("%T: el modo de depuración no escribió JSON", m.payload)
+            }
+
+            received, err := ReadMessage(&buf)
+            if err != nil {
+                t.Fatalf("%T: error al leer: %v", m.payload, err)
+            }
+            if received.Type != m.msgType || received.RequestID != request.RequestID {
+                t.Fatalf("%T: sobre distinto: %+v", m.payload, received)
+            }
+
+            target := reflect.New(reflect.TypeOf(m.target).Elem()).Interface()
+            if err := received.Decode(target); err != nil {
+                t.Fatalf("%T: error al decodificar: %v", m.payload, err)
+            }
+            if !reflect.DeepEqual(target, m.payload) {
+                t.Fatalf("%T distinto (JSON=%v):\n%+v\n%+v", m.payload, debug, m.payload, target)
+            }
+        }
+    }
+}
+
+func TestErrorResponseRoundTrip(t *testing.T) {
+    var buf bytes.Buffer
+    response := &Message{Type: MsgGetTransaction, RequestID: 7, Code: CodePruned, Error: "transacción podada"}
+    if err := WriteMessage(&buf, response); err != nil {
+        t.Fatal(err)
+    }
+
+    received, err := ReadMessage(&buf)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if received.Code != CodePruned || received.Error != response.Error || received.Payload != nil {
+        t.Fatalf("respuesta de error distinta: %+v", received)
+    }
+    if err := received.Decode(&common.Transaction{}); err == nil {
+        t.Fatal("se decodificó el contenido de una respuesta de error")
+    }
+}
+
+func TestMismatchedPayloadRejected(t *testing.T) {
+    request, err := NewRequest(MsgGetBalance, &BalanceRequest{Address: "a"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := request.Decode(&SyncRequest{}); err == nil {
+        t.Fatal("se decodificó el contenido en un tipo distinto")
+    }
+}
+
+func TestOversizedMessageRejected(t *testing.T) {
+    var buf bytes.Buffer
+    buf.Write([]byte{0xff, 0xff, 0xff, 0xff})
+    if _, err := ReadMessage(&buf); err == nil {
+        t.Fatal("se aceptó un mensaje más grande que MaxMessageSize")
+    }
+}
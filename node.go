@@ -2,114 +2,250 @@ package main
 
 import (
     "context"
+    "flag"
     "log"
     "fmt"
     "os"
     "os/signal"
+    "path/filepath"
+    "strings"
+    "time"
     "github.com/libp2p/go-libp2p"
     "github.com/multiformats/go-multiaddr"
+    "github.com/tyler-smith/go-bip32"
+    "github.com/syndtr/goleveldb/leveldb"
     "blockchain/network"
+    "blockchain/network/pb"
     "blockchain/database"
     "blockchain/core"
+    "blockchain/wallet"
+    "blockchain/common"
 )
 
 func main() {
+    prune := flag.Int64("prune", 0, "conservar solo los cuerpos de los últimos N bloques (0 desactiva la poda)")
+    checkpointsFile := flag.String("checkpoints", "", "archivo JSON con los puntos de control {\"altura\": \"hash\"} que debe cumplir la cadena; hasta el último se aceptan transacciones sin firma")
+    bootstrap := flag.String("bootstrap", "", "direcciones multiaddr de nodos de arranque, separadas por comas")
+    useDHT := flag.Bool("dht", true, "buscar pares con la DHT Kademlia")
+    useMDNS := flag.Bool("mdns", true, "buscar pares en la red local con mDNS")
+    legacyEnv := flag.Bool("legacy-env", false, "usar también la lista de nodos semilla del archivo .env")
+    dataDir := flag.String("datadir", "data/node", "directorio de datos del nodo (base de datos y clave)")
+    keyFile := flag.String("key", "", "archivo con la clave privada del nodo (por defecto <datadir>/node.key)")
+    port := flag.Int("port", 0, "puerto en el que escucha el nodo (0 elige uno libre)")
+    minPeers := flag.Int("min-peers", 4, "cantidad mínima de pares conectados que el nodo intenta mantener")
+    maxPeers := flag.Int("max-peers", 50, "cantidad máxima de pares conectados")
+    chainID := flag.String("chain-id", network.DefaultChainID, "identificador de la red; los nodos de otra red se desconectan")
+    adminAddr := flag.String("admin", "", "dirección de la API de administración, por ejemplo "+network.DefaultAdminAddr+" (vacío la desactiva)")
+    rpcAddr := flag.String("rpc", "", "dirección de la API REST para consultar cuentas y enviar transacciones firmadas, por ejemplo "+network.DefaultRPCAddr+" (vacío la desactiva)")
+    flag.Float64Var(&network.MinFee, "min-fee", 0, "comisión mínima que el nodo exige a las transacciones que recibe")
+    wireJSON := flag.Bool("wire-json", false, "enviar los mensajes de red en JSON legible, para depuración")
+    requestTimeout := flag.Duration("request-timeout", network.DefaultTimeouts.Request, "plazo de cada solicitud a otro nodo")
+    snapshotTimeout := flag.Duration("snapshot-timeout", network.DefaultTimeouts.Snapshot, "plazo para descargar una instantánea")
+    handshakeTimeout := flag.Duration("handshake-timeout", network.DefaultTimeouts.Handshake, "plazo del intercambio de estado al conectar con un par")
+    dialTimeout := flag.Duration("dial-timeout", network.DefaultTimeouts.Dial, "plazo de cada intento de conexión con un par")
+    genesisKey := flag.String("genesis-key", "", "clave pública BIP32 (base58) de la cuenta que recibe la emisión al crear una red nueva; sin ella se genera una cuenta y se muestra su frase de recuperación")
+    flag.Parse()
+
+    pb.DebugJSON = *wireJSON
+    common.AddressVersion, common.MultisigAddressVersion = network.AddressVersions(*chainID)
+    network.SetTimeouts(network.Timeouts{
+        Request:   *requestTimeout,
+        Snapshot:  *snapshotTimeout,
+        Handshake: *handshakeTimeout,
+        Dial:      *dialTimeout,
+    })
+
+    if *keyFile == "" {
+        *keyFile = filepath.Join(*dataDir, network.KeyFileName)
+    }
+    dbPath := filepath.Join(*dataDir, "chain")
+    masterPath := filepath.Join(*dataDir, "master")
+
+    var checkpoints core.Checkpoints
+    if *checkpointsFile != "" {
+        var err error
+        checkpoints, err = core.LoadCheckpoints(*checkpointsFile)
+        if err != nil {
+            log.Fatal(err)
+        }
+        log.Printf("%d puntos de control cargados\n", len(checkpoints))
+        core.LegacyHeight = checkpoints.Last()
+    }
+
     ctx, cancel := context.WithCancel(context.Background())
     defer cancel()
 
     sigChan := make(chan os.Signal, 1)
     signal.Notify(sigChan, os.Interrupt)
 
+    // Al cancelar ctx se interrumpen las sincronizaciones y solicitudes en curso
+    go func() {
+        <-sigChan
+        log.Println("Señal de interrupción recibida, limpiando...")
+        cancel()
+    }()
+
     // Crea un nuevo nodo host
 
-    h, err := libp2p.New()
+    key, err := network.LoadOrCreateIdentity(*keyFile)
+    if err != nil {
+        log.Fatalf("Error al cargar la identidad del nodo: %v", err)
+    }
+
+    if err := os.MkdirAll(*dataDir, 0700); err != nil {
+        log.Fatalf("Error al crear el directorio de datos: %v", err)
+    }
+    peers := network.NewPeerManager(network.PeerConfig{
+        MinPeers: *minPeers,
+        MaxPeers: *maxPeers,
+        BanFile:  filepath.Join(*dataDir, "bans.json"),
+    })
+
+    options := []libp2p.Option{libp2p.Identity(key), libp2p.ConnectionGater(peers)}
+    if *port != 0 {
+        options = append(options, libp2p.ListenAddrStrings(
+            fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", *port),
+            fmt.Sprintf("/ip4/0.0.0.0/udp/%d/quic-v1", *port),
+        ))
+    }
+
+    h, err := libp2p.New(options...)
     if err != nil {
         panic(err)
     }
     defer h.Close()
 
-    network.SetupBroadcastStreamHandler(h)
+    // La base de datos del nodo se abre una sola vez: goleveldb no admite dos aperturas de la
+    // misma ruta, así que todos los servicios comparten este acceso. Se conserva entre reinicios
+    db, err := database.InitDB(dbPath)
+    if err != nil {
+        log.Fatalf("Failed to initialize DB: %v", err)
+    }
+    defer db.Close()
+
+    peers.Start(ctx, h)
+    status := network.StartStatusService(ctx, h, db, *chainID, *prune > 0)
+    if *adminAddr != "" {
+        go func() {
+            if err := network.ServeAdminAPI(*adminAddr, peers); err != nil {
+                log.Printf("Error en la API de administración: %v\n", err)
+            }
+        }()
+    }
+
+    network.SetupBroadcastStreamHandler(ctx, h)
 
     hostAddr, _ := multiaddr.NewMultiaddr(fmt.Sprintf("/ipfs/%s", h.ID()))
 
     fullAddr := h.Addrs()[0].Encapsulate(hostAddr).String()
     log.Printf("Dirección completa de este nodo: %s\n", fullAddr)
 
-    // Genera base de datos general
-
-    master, err := database.InitDB("data/master")
-
+    // Descubre pares por los nodos de arranque, la DHT y mDNS
+    discovery, err := network.StartDiscovery(ctx, h, network.DiscoveryConfig{
+        BootstrapPeers: strings.Split(*bootstrap, ","),
+        DHT:            *useDHT,
+        MDNS:           *useMDNS,
+        LegacyEnv:      *legacyEnv,
+    }, fullAddr)
     if err != nil {
-        log.Fatalf("Failed to initialize DB: %v", err)
+        log.Fatal(err)
     }
-    defer master.Close()
+    defer discovery.Close()
 
-    // genera bloque genesis si no existe
-
-    isEmpty := database.IsEmpty(master)
-
-    if isEmpty {
-        genesisBlock, amount := core.CreateGenesisBlock()
-        err := core.SaveBlock(master, genesisBlock)
+    defer func() {
+        // Guarda una copia de la cadena local en la base de datos maestra antes de cerrar
+        err := database.SaveToMasterDB(masterPath, db)
         if err != nil {
-            log.Fatalf("Error al guardar el bloque génesis: %v", err)
+            log.Printf("Error al sincronizar con la base de datos maestra: %v", err)
         }
-        log.Println("Bloque génesis creado y guardado con éxito.")
+    }()
 
-        master.Close()
+    // Sincroniza la base de datos
 
-        _, err = core.NewUser("data/" + h.ID().String(), h, amount)
+    syncPeers := status.WaitForPeers(ctx, 5*time.Second)
+    err = network.SyncDatabase(ctx, h, db, masterPath, syncPeers, checkpoints)
+    if ctx.Err() != nil {
+        return
+    }
+    if err != nil {
+        log.Printf("Error al sincronizar la base de datos: %v\n", err)
+    }
 
+    // Un nodo que se une a una red recibe el génesis de sus pares; solo se crea uno si no se
+    // encontró ningún par, al iniciar una red nueva
+    if _, err := database.LastBlockIndex(db); err == database.ErrNoBlocks {
+        if len(syncPeers) > 0 {
+            log.Println("La cadena local está vacía; se completará con los bloques de los pares.")
+        } else {
+            createGenesis(db, *genesisKey)
+        }
+    } else if err != nil {
+        log.Fatal(err)
     } else {
         log.Println("La blockchain ya existe, no se necesita crear un bloque génesis.")
     }
 
-    master.Close()
-
-    defer network.RemoveNodeFromEnv(network.EnvFilePath, fullAddr)
+    network.SetupCreateAccountHandler(ctx, h, db)
+    network.SetupSyncHandler(ctx, h, db)
+    network.SetupSnapshotHandler(ctx, h, db)
 
-    network.UpdateSeedNodes(fullAddr)
-    activeSeedNodes := network.VerifySeedNodes(ctx, h, fullAddr)
-    network.ConnectToSeedNodes(ctx, h, activeSeedNodes)
+    if *prune > 0 {
+        log.Printf("Modo podado: se conservan los cuerpos de los últimos %d bloques\n", *prune)
+        go core.RunPruner(ctx, db, *prune, network.ChainLock)
+    }
+    network.SetupGetBalanceHandler(ctx, h, db)
+    gossip, err := network.NewGossip(ctx, h, db, checkpoints)
+    if err != nil {
+        log.Fatal(err)
+    }
+    network.SetupSendHandler(ctx, h, db, gossip)
+    network.SetupGetTransHandler(ctx, h, db, gossip)
+    if *rpcAddr != "" {
+        go func() {
+            if err := network.ServeRPC(ctx, *rpcAddr, db, gossip); err != nil {
+                log.Printf("Error en la API REST: %v\n", err)
+            }
+        }()
+    }
 
-    // Genera base de datos para el nodo
+    <-ctx.Done()
 
-    db, err := database.InitDB("data/" + h.ID().String())
-    if err != nil {
-        log.Fatalf("Failed to initialize DB: %v", err)
+    // Las solicitudes en curso ya recibieron la cancelación; se espera a que respondan
+    if !network.WaitStreams(5 * time.Second) {
+        log.Println("Algunas solicitudes no terminaron antes del cierre")
     }
-    defer func() {
-        // Sincroniza la base de datos local con la base de datos maestra antes de cerrar
-        err := database.SyncWithMasterDB("data/" + h.ID().String())
+}
+
+// createGenesis crea el bloque génesis de una red nueva en la base de datos del nodo. La cuenta
+// fundadora recibe toda la emisión. El nodo solo guarda su clave pública; si no se indica una,
+// se genera aquí y la frase se muestra una única vez.
+func createGenesis(db *leveldb.DB, genesisKey string) {
+    var founderKey *bip32.Key
+    if genesisKey != "" {
+        var err error
+        founderKey, err = bip32.B58Deserialize(genesisKey)
         if err != nil {
-            log.Printf("Error al sincronizar con la base de datos maestra: %v", err)
+            log.Fatalf("Clave pública de la cuenta fundadora inválida: %v", err)
         }
-
-        // Cierra y elimina la base de datos local
-        db.Close()
-        err = os.RemoveAll("data/" + h.ID().String())
+    } else {
+        account, mnemonic, err := wallet.NewAccount("")
         if err != nil {
-            log.Printf("Error al eliminar la base de datos local: %v", err)
+            log.Fatalf("Error al crear la cuenta fundadora: %v", err)
         }
-    }()
-
-    db.Close()
-
-    // Sincroniza la base de datos
-
-    err = network.SyncDatabase(h, "data/" + h.ID().String(), activeSeedNodes)
-
-    network.SetupCreateAccountHandler(h)
-    network.SetupSyncHandler(h)
-    network.SetupGetBalanceHandler(h)
-    network.SetupSendHandler(h)
-    network.SetupGetTransHandler(h)
+        founderKey = account.PublicKey
+        fmt.Println("Cuenta fundadora:", account.Address)
+        fmt.Println("Frase de recuperación (anótela, el nodo no la guarda):", mnemonic)
+    }
 
-    go func() {
-        <-sigChan
-        log.Println("Señal de interrupción recibida, limpiando...")
-        cancel()
-    }()
+    founder, err := core.RegisterUser(db, founderKey, core.GenesisSupply)
+    if err != nil {
+        log.Fatalf("Error al crear la cuenta del bloque génesis: %v", err)
+    }
 
-    <-ctx.Done()
+    genesisBlock, _ := core.CreateGenesisBlock(founder.Address)
+    err = core.SaveBlock(db, genesisBlock)
+    if err != nil {
+        log.Fatalf("Error al guardar el bloque génesis: %v", err)
+    }
+    log.Println("Bloque génesis creado y guardado con éxito.")
 }
@@ -0,0 +1,782 @@
+package wallet
+
+import (
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/rand"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+    "github.com/tyler-smith/go-bip32"
+    "golang.org/x/crypto/scrypt"
+    "blockchain/common"
+)
+
+// KeyFileVersion es la versión del formato de los archivos del almacén de claves. Los archivos
+// de la versión 1 guardaban una sola cuenta derivada de la clave maestra y se siguen leyendo.
+// Desde la versión 3 el secreto de una frase puede llevar su passphrase BIP39.
+const KeyFileVersion = 3
+
+// Tipos de secreto que puede guardar un archivo del almacén.
+const (
+    SecretMnemonic   = "mnemonic"
+    SecretPrivateKey = "privateKey"
+)
+
+// Parámetros de scrypt. ScryptN se puede bajar en pruebas; los archivos guardan los parámetros
+// con los que se cifraron, así que cambiarlo no afecta a los existentes.
+var (
+    ScryptN = 1 << 18
+    ScryptR = 8
+    ScryptP = 1
+)
+
+var (
+    // ErrWrongPassword se devuelve cuando la contraseña no descifra el archivo.
+    ErrWrongPassword = errors.New("contraseña incorrecta")
+    // ErrLocked se devuelve al pedir una cuenta que no está desbloqueada.
+    ErrLocked = errors.New("la cuenta está bloqueada")
+    // ErrNoAccount se devuelve cuando el almacén no tiene la cuenta pedida.
+    ErrNoAccount = errors.New("la cuenta no está en el almacén")
+    // ErrExists se devuelve al agregar una cuenta que ya tiene un archivo en el almacén.
+    ErrExists = errors.New("la cuenta ya está en el almacén")
+    // ErrBackupPending se devuelve al desbloquear una billetera cuya frase todavía no se
+    // confirmó con ConfirmBackup.
+    ErrBackupPending = errors.New("la frase de la billetera no se confirmó; confírmela antes de usar la cuenta")
+    // ErrBackupMismatch se devuelve cuando la frase confirmada no es la de la billetera.
+    ErrBackupMismatch = errors.New("la frase o la passphrase no corresponden a la billetera")
+)
+
+// KeyFile es el contenido de un archivo del almacén: una frase mnemónica o una clave privada
+// cifrada con AES-256-GCM bajo una clave derivada de la contraseña con scrypt, y en claro las
+// direcciones que se derivaron de ella. Address es la primera dirección y da nombre al archivo.
+// AccountKeys son las claves públicas extendidas de las cuentas BIP44 en uso, con las que se
+// derivan direcciones nuevas sin la contraseña. BackupPending indica que la frase se generó en
+// este almacén y el usuario todavía no confirmó haberla anotado.
+type KeyFile struct {
+    Version       int
+    Address       string
+    PublicKey     string
+    Secret        string
+    BackupPending bool             `json:",omitempty"`
+    AccountKeys   []string         `json:",omitempty"`
+    Addresses     []DerivedAddress `json:",omitempty"`
+    KDF           string
+    N             int
+    R             int
+    P             int
+    Salt          string
+    Cipher        string
+    Nonce         string
+    Ciphertext    string
+}
+
+func newKeyFile(account *Account, secretType string) *KeyFile {
+    return &KeyFile{
+        Version:   KeyFileVersion,
+        Address:   account.Address,
+        PublicKey: account.PublicKey.String(),
+        Secret:    secretType,
+        Addresses: []DerivedAddress{{Path: account.Path, Address: account.Address, PublicKey: account.PublicKey.String()}},
+    }
+}
+
+// EncryptKey cifra el secreto de una cuenta con la contraseña.
+func EncryptKey(account *Account, secretType, secret, password string) (*KeyFile, error) {
+    kf := newKeyFile(account, secretType)
+    if err := kf.encrypt(secret, password); err != nil {
+        return nil, err
+    }
+    return kf, nil
+}
+
+func (kf *KeyFile) encrypt(secret, password string) error {
+    if password == "" {
+        return fmt.Errorf("se requiere una contraseña")
+    }
+
+    salt := make([]byte, 32)
+    if _, err := rand.Read(salt); err != nil {
+        return fmt.Errorf("error al generar la sal: %v", err)
+    }
+
+    key, err := scrypt.Key([]byte(password), salt, ScryptN, ScryptR, ScryptP, 32)
+    if err != nil {
+        return fmt.Errorf("error al derivar la clave: %v", err)
+    }
+    aead, err := newAEAD(key)
+    if err != nil {
+        return err
+    }
+
+    nonce := make([]byte, aead.NonceSize())
+    if _, err := rand.Read(nonce); err != nil {
+        return fmt.Errorf("error al generar el nonce: %v", err)
+    }
+
+    // La dirección va como dato adicional, así que el secreto no se puede mover a otro archivo
+    ciphertext := aead.Seal(nil, nonce, []byte(secret), []byte(kf.Address))
+
+    kf.KDF, kf.N, kf.R, kf.P = "scrypt", ScryptN, ScryptR, ScryptP
+    kf.Salt = hex.EncodeToString(salt)
+    kf.Cipher = "aes-256-gcm"
+    kf.Nonce = hex.EncodeToString(nonce)
+    kf.Ciphertext = hex.EncodeToString(ciphertext)
+    return nil
+}
+
+func (kf *KeyFile) decrypt(password string) (string, error) {
+    if kf.Version < 1 || kf.Version > KeyFileVersion || kf.KDF != "scrypt" || kf.Cipher != "aes-256-gcm" {
+        return "", fmt.Errorf("formato de archivo de claves no admitido: versión %d, %s, %s", kf.Version, kf.KDF, kf.Cipher)
+    }
+
+    salt, err := hex.DecodeString(kf.Salt)
+    if err != nil {
+        return "", fmt.Errorf("sal inválida: %v", err)
+    }
+    nonce, err := hex.DecodeString(kf.Nonce)
+    if err != nil {
+        return "", fmt.Errorf("nonce inválido: %v", err)
+    }
+    ciphertext, err := hex.DecodeString(kf.Ciphertext)
+    if err != nil {
+        return "", fmt.Errorf("texto cifrado inválido: %v", err)
+    }
+
+    key, err := scrypt.Key([]byte(password), salt, kf.N, kf.R, kf.P, 32)
+    if err != nil {
+        return "", fmt.Errorf("error al derivar la clave: %v", err)
+    }
+    aead, err := newAEAD(key)
+    if err != nil {
+        return "", err
+    }
+    if len(nonce) != aead.NonceSize() {
+        return "", fmt.Errorf("nonce inválido")
+    }
+
+    plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(kf.Address))
+    if err != nil {
+        return "", ErrWrongPassword
+    }
+    return string(plaintext), nil
+}
+
+// Decrypt descifra el secreto del archivo y devuelve la cuenta de su primera dirección junto
+// con el secreto.
+func (kf *KeyFile) Decrypt(password string) (*Account, string, error) {
+    secret, err := kf.decrypt(password)
+    if err != nil {
+        return nil, "", err
+    }
+    account, err := kf.account(secret, kf.Address)
+    if err != nil {
+        return nil, "", err
+    }
+    return account, secret, nil
+}
+
+// account deriva del secreto la cuenta de una de las direcciones del archivo.
+func (kf *KeyFile) account(secret, address string) (*Account, error) {
+    derived, ok := kf.find(address)
+    if !ok {
+        return nil, ErrNoAccount
+    }
+
+    var account *Account
+    var err error
+    switch kf.Secret {
+    case SecretMnemonic:
+        mnemonic, passphrase := splitSecret(secret)
+        account, err = DeriveAccount(mnemonic, passphrase, derived.Path)
+    case SecretPrivateKey:
+        account, err = ParsePrivateKey(secret)
+    default:
+        err = fmt.Errorf("tipo de secreto desconocido: %q", kf.Secret)
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    if !common.SameAddress(account.Address, address) {
+        return nil, fmt.Errorf("el secreto no corresponde a la dirección %s", address)
+    }
+    return account, nil
+}
+
+// joinSecret arma el secreto cifrado de una frase: la frase y, si la hay, la passphrase en la
+// línea siguiente. Una frase normalizada no tiene saltos de línea, así que se separan sin
+// ambigüedad y los archivos anteriores, que solo tenían la frase, se siguen leyendo.
+func joinSecret(mnemonic, passphrase string) string {
+    if passphrase == "" {
+        return mnemonic
+    }
+    return mnemonic + "\n" + passphrase
+}
+
+func splitSecret(secret string) (mnemonic, passphrase string) {
+    mnemonic, passphrase, _ = strings.Cut(secret, "\n")
+    return mnemonic, passphrase
+}
+
+func (kf *KeyFile) find(address string) (DerivedAddress, bool) {
+    for _, derived := range kf.Addresses {
+        if common.SameAddress(derived.Address, address) {
+            return derived, true
+        }
+    }
+    return DerivedAddress{}, false
+}
+
+// add agrega las direcciones que el archivo todavía no tiene y devuelve cuáles eran nuevas.
+func (kf *KeyFile) add(addresses []DerivedAddress) []DerivedAddress {
+    var added []DerivedAddress
+    for _, derived := range addresses {
+        if _, ok := kf.find(derived.Address); !ok {
+            kf.Addresses = append(kf.Addresses, derived)
+            added = append(added, derived)
+        }
+    }
+    return added
+}
+
+// upgrade completa los archivos de la versión 1, que solo tenían la dirección de la clave
+// maestra.
+func (kf *KeyFile) upgrade() {
+    if len(kf.Addresses) == 0 {
+        path := ""
+        if kf.Secret == SecretMnemonic {
+            path = LegacyPath
+        }
+        kf.Addresses = []DerivedAddress{{Path: path, Address: kf.Address, PublicKey: kf.PublicKey}}
+    }
+    kf.Version = KeyFileVersion
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+    block, err := aes.NewCipher(key)
+    if err != nil {
+        return nil, fmt.Errorf("error al crear el cifrador: %v", err)
+    }
+    aead, err := cipher.NewGCM(block)
+    if err != nil {
+        return nil, fmt.Errorf("error al crear el cifrador: %v", err)
+    }
+    return aead, nil
+}
+
+// unlockedAccount es una cuenta desbloqueada y el temporizador que la vuelve a bloquear.
+type unlockedAccount struct {
+    account *Account
+    timer   *time.Timer
+}
+
+// Keystore es un directorio con un archivo cifrado por frase mnemónica o clave privada. Las
+// cuentas desbloqueadas se guardan en memoria hasta que vence su plazo o se bloquean.
+type Keystore struct {
+    dir      string
+    mu       sync.Mutex
+    unlocked map[string]*unlockedAccount
+    // files serializa las modificaciones de los archivos
+    files    sync.Mutex
+}
+
+// AccountInfo describe una dirección del almacén sin revelar su secreto. Wallet es la primera
+// dirección de su archivo, que agrupa las direcciones derivadas de la misma frase.
+// BackupPending indica que falta confirmar la frase de la billetera.
+type AccountInfo struct {
+    Address       string
+    PublicKey     string
+    Path          string `json:",omitempty"`
+    Wallet        string
+    Unlocked      bool
+    BackupPending bool   `json:",omitempty"`
+}
+
+// OpenKeystore abre el almacén del directorio dir, creándolo si no existe.
+func OpenKeystore(dir string) (*Keystore, error) {
+    if err := os.MkdirAll(dir, 0700); err != nil {
+        return nil, fmt.Errorf("error al crear el almacén de claves: %v", err)
+    }
+    return &Keystore{dir: dir, unlocked: make(map[string]*unlockedAccount)}, nil
+}
+
+func (ks *Keystore) path(address string) string {
+    // La dirección puede venir de una solicitud, así que no debe poder salir del directorio
+    return filepath.Join(ks.dir, filepath.Base(address)+".json")
+}
+
+// Create genera una frase mnemónica nueva, la guarda cifrada junto con la passphrase BIP39
+// opcional y devuelve su primera cuenta y la frase. La frase no se vuelve a mostrar: la
+// billetera no se puede desbloquear hasta que el usuario la confirme con ConfirmBackup.
+func (ks *Keystore) Create(password, passphrase string) (*Account, string, error) {
+    if password == "" {
+        return nil, "", fmt.Errorf("se requiere una contraseña")
+    }
+    mnemonic, err := generateMnemonic()
+    if err != nil {
+        return nil, "", err
+    }
+    account, err := ks.storeMnemonic(mnemonic, passphrase, password, 1, nil, true)
+    if err != nil {
+        return nil, "", err
+    }
+    return account, mnemonic, nil
+}
+
+// ConfirmBackup comprueba que el usuario anotó la frase de la billetera que contiene address,
+// y su passphrase si la tiene, y habilita desbloquearla. No hace falta la contraseña: la frase
+// se comprueba derivando la dirección.
+func (ks *Keystore) ConfirmBackup(address, mnemonic, passphrase string) error {
+    if err := ValidateMnemonic(mnemonic); err != nil {
+        return err
+    }
+
+    ks.files.Lock()
+    defer ks.files.Unlock()
+
+    kf, err := ks.load(address)
+    if err != nil {
+        return err
+    }
+    if kf.Secret != SecretMnemonic {
+        return fmt.Errorf("la cuenta se importó a partir de una clave privada y no tiene frase")
+    }
+    account, err := FromMnemonic(mnemonic, passphrase)
+    if err != nil {
+        return err
+    }
+    if !common.SameAddress(account.Address, kf.Address) {
+        return ErrBackupMismatch
+    }
+
+    if !kf.BackupPending {
+        return nil
+    }
+    kf.BackupPending = false
+    return ks.write(kf)
+}
+
+// Import guarda cifrada una frase mnemónica, con su passphrase BIP39 opcional, o una clave
+// privada BIP32 en base58 y devuelve su primera cuenta. Para encontrar las demás direcciones
+// de una frase ya usada está Recover.
+func (ks *Keystore) Import(secret, passphrase, password string) (*Account, error) {
+    secret = strings.TrimSpace(secret)
+
+    if !strings.HasPrefix(secret, "xprv") {
+        return ks.storeMnemonic(secret, passphrase, password, 1, nil, false)
+    }
+    if passphrase != "" {
+        return nil, fmt.Errorf("la passphrase solo se usa con frases mnemónicas")
+    }
+
+    account, err := ParsePrivateKey(secret)
+    if err != nil {
+        return nil, err
+    }
+    kf, err := EncryptKey(account, SecretPrivateKey, secret, password)
+    if err != nil {
+        return nil, err
+    }
+    if err := ks.create(kf); err != nil {
+        return nil, err
+    }
+    return account, nil
+}
+
+// Recover guarda cifrada una frase mnemónica y su passphrase junto con todas sus direcciones
+// usadas, que busca con Recover, y devuelve su primera cuenta y las direcciones encontradas.
+// Una passphrase distinta de la original recupera otra billetera, en general sin direcciones
+// usadas.
+func (ks *Keystore) Recover(mnemonic, passphrase, password string, used UsedFunc) (*Account, []DerivedAddress, error) {
+    if password == "" {
+        return nil, nil, fmt.Errorf("se requiere una contraseña")
+    }
+    // La frase se valida antes de consultar la red, para informar las erratas sin esperar
+    if err := ValidateMnemonic(mnemonic); err != nil {
+        return nil, nil, err
+    }
+
+    accounts, found, err := Recover(mnemonic, passphrase, used)
+    if err != nil {
+        return nil, nil, err
+    }
+    account, err := ks.storeMnemonic(mnemonic, passphrase, password, accounts, found, false)
+    if err != nil {
+        return nil, nil, err
+    }
+    return account, found, nil
+}
+
+// storeMnemonic guarda la frase con las claves de sus primeras accounts cuentas BIP44 y las
+// direcciones indicadas, además de la primera. pending indica que falta confirmar la frase.
+func (ks *Keystore) storeMnemonic(mnemonic, passphrase, password string, accounts int, addresses []DerivedAddress, pending bool) (*Account, error) {
+    mnemonic = NormalizeMnemonic(mnemonic)
+
+    account, err := FromMnemonic(mnemonic, passphrase)
+    if err != nil {
+        return nil, err
+    }
+
+    kf := newKeyFile(account, SecretMnemonic)
+    kf.BackupPending = pending
+    for i := 0; i < accounts; i++ {
+        accountKey, err := AccountKey(mnemonic, passphrase, uint32(i))
+        if err != nil {
+            return nil, err
+        }
+        kf.AccountKeys = append(kf.AccountKeys, accountKey.String())
+    }
+    kf.add(addresses)
+
+    if err := kf.encrypt(joinSecret(mnemonic, passphrase), password); err != nil {
+        return nil, err
+    }
+
+    if err := ks.create(kf); err != nil {
+        return nil, err
+    }
+    return account, nil
+}
+
+// ImportFile agrega al almacén un archivo exportado de otro. La contraseña debe descifrarlo y
+// se conserva.
+func (ks *Keystore) ImportFile(data []byte, password string) (*Account, error) {
+    var kf KeyFile
+    if err := json.Unmarshal(data, &kf); err != nil {
+        return nil, fmt.Errorf("archivo de claves inválido: %v", err)
+    }
+    kf.upgrade()
+
+    account, _, err := kf.Decrypt(password)
+    if err != nil {
+        return nil, err
+    }
+
+    if err := ks.create(&kf); err != nil {
+        return nil, err
+    }
+    return account, nil
+}
+
+// Export devuelve el archivo cifrado que contiene una dirección, para llevarlo a otro almacén.
+func (ks *Keystore) Export(address string) ([]byte, error) {
+    kf, err := ks.load(address)
+    if err != nil {
+        return nil, err
+    }
+    return json.MarshalIndent(kf, "", "  ")
+}
+
+// List devuelve las direcciones del almacén, agrupadas por archivo.
+func (ks *Keystore) List() ([]AccountInfo, error) {
+    files, err := ks.readAll()
+    if err != nil {
+        return nil, err
+    }
+
+    ks.mu.Lock()
+    defer ks.mu.Unlock()
+
+    accounts := []AccountInfo{}
+    for _, kf := range files {
+        for _, derived := range kf.Addresses {
+            _, unlocked := ks.unlocked[common.AddressKey(derived.Address)]
+            accounts = append(accounts, AccountInfo{
+                Address:       common.FormatAddress(derived.Address),
+                PublicKey:     derived.PublicKey,
+                Path:          derived.Path,
+                Wallet:        common.FormatAddress(kf.Address),
+                Unlocked:      unlocked,
+                BackupPending: kf.BackupPending,
+            })
+        }
+    }
+    return accounts, nil
+}
+
+// NextAddress deriva la siguiente dirección de recepción de la cuenta BIP44 account de la
+// billetera que contiene address. Las direcciones de una cuenta ya en uso se derivan sin la
+// contraseña; para empezar la cuenta siguiente hace falta.
+func (ks *Keystore) NextAddress(address string, account uint32, password string) (DerivedAddress, error) {
+    ks.files.Lock()
+    defer ks.files.Unlock()
+
+    kf, err := ks.load(address)
+    if err != nil {
+        return DerivedAddress{}, err
+    }
+    if kf.Secret != SecretMnemonic {
+        return DerivedAddress{}, fmt.Errorf("la cuenta se importó a partir de una clave privada y no deriva direcciones")
+    }
+
+    switch {
+    case int(account) > len(kf.AccountKeys):
+        return DerivedAddress{}, fmt.Errorf("la cuenta %d no existe; la siguiente es la %d", account, len(kf.AccountKeys))
+    case int(account) == len(kf.AccountKeys):
+        secret, err := kf.decrypt(password)
+        if err != nil {
+            return DerivedAddress{}, err
+        }
+        mnemonic, passphrase := splitSecret(secret)
+        accountKey, err := AccountKey(mnemonic, passphrase, account)
+        if err != nil {
+            return DerivedAddress{}, err
+        }
+        kf.AccountKeys = append(kf.AccountKeys, accountKey.String())
+    }
+
+    accountKey, err := bip32.B58Deserialize(kf.AccountKeys[account])
+    if err != nil {
+        return DerivedAddress{}, fmt.Errorf("clave de la cuenta %d inválida: %v", account, err)
+    }
+
+    // La siguiente es la primera ruta de la cuenta que el archivo todavía no tiene
+    index := uint32(0)
+    for kf.hasPath(AddressPath(account, index)) {
+        index++
+    }
+    derived, err := DeriveAddress(accountKey, account, index)
+    if err != nil {
+        return DerivedAddress{}, err
+    }
+
+    kf.add([]DerivedAddress{derived})
+    if err := ks.write(kf); err != nil {
+        return DerivedAddress{}, err
+    }
+    return derived, nil
+}
+
+func (kf *KeyFile) hasPath(path string) bool {
+    for _, derived := range kf.Addresses {
+        if derived.Path == path {
+            return true
+        }
+    }
+    return false
+}
+
+// Scan busca en la cadena direcciones usadas de las cuentas BIP44 de la billetera que contiene
+// address, las agrega al archivo y devuelve las que no tenía.
+func (ks *Keystore) Scan(address string, used UsedFunc) ([]DerivedAddress, error) {
+    kf, err := ks.load(address)
+    if err != nil {
+        return nil, err
+    }
+
+    // La búsqueda consulta la red, así que se hace sin bloquear los archivos
+    var found []DerivedAddress
+    for i, encoded := range kf.AccountKeys {
+        accountKey, err := bip32.B58Deserialize(encoded)
+        if err != nil {
+            return nil, fmt.Errorf("clave de la cuenta %d inválida: %v", i, err)
+        }
+        addresses, err := ScanAccount(accountKey, uint32(i), used)
+        if err != nil {
+            return nil, err
+        }
+        found = append(found, addresses...)
+    }
+
+    ks.files.Lock()
+    defer ks.files.Unlock()
+
+    kf, err = ks.load(address)
+    if err != nil {
+        return nil, err
+    }
+    added := kf.add(found)
+    if len(added) > 0 {
+        if err := ks.write(kf); err != nil {
+            return nil, err
+        }
+    }
+    return added, nil
+}
+
+// Unlock descifra la cuenta de una dirección y la mantiene disponible para firmar durante
+// timeout. Con timeout 0 queda desbloqueada hasta que se llame a Lock. Las billeteras recién
+// creadas no se desbloquean hasta que se confirma su frase.
+func (ks *Keystore) Unlock(address, password string, timeout time.Duration) error {
+    kf, err := ks.load(address)
+    if err != nil {
+        return err
+    }
+    if kf.BackupPending {
+        return ErrBackupPending
+    }
+    secret, err := kf.decrypt(password)
+    if err != nil {
+        return err
+    }
+    account, err := kf.account(secret, address)
+    if err != nil {
+        return err
+    }
+
+    // Las cuentas desbloqueadas se guardan por la forma canónica de la dirección, así que se
+    // pueden pedir en cualquiera de las dos codificaciones
+    key := common.AddressKey(address)
+
+    ks.mu.Lock()
+    defer ks.mu.Unlock()
+
+    if previous, ok := ks.unlocked[key]; ok && previous.timer != nil {
+        previous.timer.Stop()
+    }
+    entry := &unlockedAccount{account: account}
+    if timeout > 0 {
+        entry.timer = time.AfterFunc(timeout, func() {
+            ks.mu.Lock()
+            defer ks.mu.Unlock()
+            if ks.unlocked[key] == entry {
+                delete(ks.unlocked, key)
+            }
+        })
+    }
+    ks.unlocked[key] = entry
+    return nil
+}
+
+// Lock olvida la clave descifrada de una cuenta.
+func (ks *Keystore) Lock(address string) {
+    key := common.AddressKey(address)
+
+    ks.mu.Lock()
+    defer ks.mu.Unlock()
+
+    if entry, ok := ks.unlocked[key]; ok {
+        if entry.timer != nil {
+            entry.timer.Stop()
+        }
+        delete(ks.unlocked, key)
+    }
+}
+
+// Account devuelve una cuenta desbloqueada, o ErrLocked si no lo está.
+func (ks *Keystore) Account(address string) (*Account, error) {
+    ks.mu.Lock()
+    entry, ok := ks.unlocked[common.AddressKey(address)]
+    ks.mu.Unlock()
+
+    if !ok {
+        if _, err := ks.load(address); err != nil {
+            return nil, err
+        }
+        return nil, ErrLocked
+    }
+    return entry.account, nil
+}
+
+// ChangePassword vuelve a cifrar con una contraseña nueva el archivo que contiene una dirección.
+func (ks *Keystore) ChangePassword(address, oldPassword, newPassword string) error {
+    ks.files.Lock()
+    defer ks.files.Unlock()
+
+    kf, err := ks.load(address)
+    if err != nil {
+        return err
+    }
+    secret, err := kf.decrypt(oldPassword)
+    if err != nil {
+        return err
+    }
+    if err := kf.encrypt(secret, newPassword); err != nil {
+        return err
+    }
+    return ks.write(kf)
+}
+
+// create guarda el archivo de una cuenta nueva. Si la cuenta ya tiene un archivo devuelve
+// ErrExists: reemplazarlo perdería su contraseña y las direcciones que ya se derivaron.
+func (ks *Keystore) create(kf *KeyFile) error {
+    ks.files.Lock()
+    defer ks.files.Unlock()
+
+    if _, err := os.Stat(ks.path(kf.Address)); err == nil {
+        return ErrExists
+    } else if !os.IsNotExist(err) {
+        return err
+    }
+    return ks.write(kf)
+}
+
+// write guarda el archivo en un temporal y lo renombra, para no dejar un archivo a medias.
+func (ks *Keystore) write(kf *KeyFile) error {
+    data, err := json.MarshalIndent(kf, "", "  ")
+    if err != nil {
+        return err
+    }
+
+    tmp := ks.path(kf.Address) + ".tmp"
+    if err := os.WriteFile(tmp, data, 0600); err != nil {
+        return fmt.Errorf("error al guardar el archivo de claves: %v", err)
+    }
+    if err := os.Rename(tmp, ks.path(kf.Address)); err != nil {
+        os.Remove(tmp)
+        return fmt.Errorf("error al guardar el archivo de claves: %v", err)
+    }
+    return nil
+}
+
+// load devuelve el archivo que contiene una dirección: el que lleva su nombre o, si es una
+// dirección derivada, el que la tiene en su lista.
+func (ks *Keystore) load(address string) (*KeyFile, error) {
+    kf, err := readKeyFile(ks.path(address))
+    if err == nil {
+        return kf, nil
+    }
+    if !os.IsNotExist(err) {
+        return nil, err
+    }
+
+    files, err := ks.readAll()
+    if err != nil {
+        return nil, err
+    }
+    for _, kf := range files {
+        if _, ok := kf.find(address); ok {
+            return kf, nil
+        }
+    }
+    return nil, ErrNoAccount
+}
+
+func (ks *Keystore) readAll() ([]*KeyFile, error) {
+    paths, err := filepath.Glob(filepath.Join(ks.dir, "*.json"))
+    if err != nil {
+        return nil, err
+    }
+    sort.Strings(paths)
+
+    var files []*KeyFile
+    for _, path := range paths {
+        kf, err := readKeyFile(path)
+        if err != nil {
+            return nil, err
+        }
+        files = append(files, kf)
+    }
+    return files, nil
+}
+
+func readKeyFile(path string) (*KeyFile, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    var kf KeyFile
+    if err := json.Unmarshal(data, &kf); err != nil {
+        return nil, fmt.Errorf("archivo de claves inválido %s: %v", path, err)
+    }
+    kf.upgrade()
+    return &kf, nil
+}
@@ -0,0 +1,140 @@
+package wallet
+
+import (
+    "fmt"
+    "strings"
+    "github.com/tyler-smith/go-bip32"
+    "github.com/tyler-smith/go-bip39"
+)
+
+// MnemonicBits es la entropía de las frases que genera la billetera: 128 bits, 12 palabras.
+const MnemonicBits = 128
+
+// MnemonicError describe por qué una frase mnemónica no es válida. Word es la posición de la
+// palabra con el problema, contando desde 1, o 0 si el problema es de toda la frase.
+type MnemonicError struct {
+    Word        int
+    Reason      string
+    Suggestions []string
+}
+
+func (e *MnemonicError) Error() string {
+    msg := "frase mnemónica inválida: " + e.Reason
+    if len(e.Suggestions) > 0 {
+        msg += fmt.Sprintf(" (¿quiso decir %s?)", strings.Join(e.Suggestions, ", "))
+    }
+    return msg
+}
+
+// NormalizeMnemonic pasa la frase a minúsculas y deja un solo espacio entre palabras, como se
+// escribe al copiarla a mano.
+func NormalizeMnemonic(mnemonic string) string {
+    return strings.Join(strings.Fields(strings.ToLower(mnemonic)), " ")
+}
+
+// ValidateMnemonic comprueba que la frase tenga una cantidad de palabras válida, que todas
+// estén en la lista BIP39 y que la suma de verificación coincida. Los errores indican la
+// palabra con el problema y sugieren las palabras parecidas de la lista.
+func ValidateMnemonic(mnemonic string) error {
+    words := strings.Fields(NormalizeMnemonic(mnemonic))
+
+    switch len(words) {
+    case 12, 15, 18, 21, 24:
+    default:
+        return &MnemonicError{Reason: fmt.Sprintf("tiene %d palabras y debe tener 12, 15, 18, 21 o 24", len(words))}
+    }
+
+    for i, word := range words {
+        if _, ok := bip39.GetWordIndex(word); !ok {
+            return &MnemonicError{
+                Word:        i + 1,
+                Reason:      fmt.Sprintf("la palabra %d, %q, no está en la lista BIP39", i+1, word),
+                Suggestions: suggestWords(word),
+            }
+        }
+    }
+
+    // Con todas las palabras en la lista, un error de suma indica una palabra cambiada por
+    // otra válida o dos palabras en otro orden
+    if _, err := bip39.MnemonicToByteArray(strings.Join(words, " ")); err != nil {
+        return &MnemonicError{Reason: "la suma de verificación no coincide; revise que las palabras y su orden sean los anotados"}
+    }
+    return nil
+}
+
+// suggestWords devuelve las palabras de la lista BIP39 más parecidas a una palabra
+// desconocida. Las palabras de la lista se distinguen por sus cuatro primeras letras, así que
+// primero se buscan las que empiezan igual y, si no hay, las que están a una o dos letras de
+// distancia.
+func suggestWords(word string) []string {
+    var suggestions []string
+    if len(word) >= 4 {
+        for _, candidate := range bip39.GetWordList() {
+            if strings.HasPrefix(candidate, word[:4]) {
+                suggestions = append(suggestions, candidate)
+            }
+        }
+        if len(suggestions) > 0 {
+            return suggestions
+        }
+    }
+
+    for distance := 1; distance <= 2 && len(suggestions) == 0; distance++ {
+        for _, candidate := range bip39.GetWordList() {
+            if editDistance(word, candidate) == distance {
+                suggestions = append(suggestions, candidate)
+            }
+        }
+    }
+    if len(suggestions) > 5 {
+        suggestions = suggestions[:5]
+    }
+    return suggestions
+}
+
+// editDistance es la distancia de Levenshtein entre dos palabras.
+func editDistance(a, b string) int {
+    previous := make([]int, len(b)+1)
+    current := make([]int, len(b)+1)
+    for j := range previous {
+        previous[j] = j
+    }
+    for i := 1; i <= len(a); i++ {
+        current[0] = i
+        for j := 1; j <= len(b); j++ {
+            cost := 1
+            if a[i-1] == b[j-1] {
+                cost = 0
+            }
+            current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+        }
+        previous, current = current, previous
+    }
+    return previous[len(b)]
+}
+
+func generateMnemonic() (string, error) {
+    entropy, err := bip39.NewEntropy(MnemonicBits)
+    if err != nil {
+        return "", fmt.Errorf("error al generar la entropía: %v", err)
+    }
+    mnemonic, err := bip39.NewMnemonic(entropy)
+    if err != nil {
+        return "", fmt.Errorf("error al generar la frase mnemónica: %v", err)
+    }
+    return mnemonic, nil
+}
+
+// derivePrivateKey devuelve la clave maestra de la frase con la contraseña BIP39 opcional
+// passphrase. Una passphrase distinta da otra billetera, no un error.
+func derivePrivateKey(mnemonic, passphrase string) (*bip32.Key, error) {
+    if err := ValidateMnemonic(mnemonic); err != nil {
+        return nil, err
+    }
+    seed := bip39.NewSeed(NormalizeMnemonic(mnemonic), passphrase)
+    masterKey, err := bip32.NewMasterKey(seed)
+    if err != nil {
+        return nil, fmt.Errorf("error al derivar la clave maestra: %v", err)
+    }
+    return masterKey, nil
+}
@@ -0,0 +1,172 @@
+package wallet
+
+import (
+    "bytes"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "strings"
+    "time"
+    "github.com/decred/dcrd/dcrec/secp256k1/v4"
+    "github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+    "github.com/tyler-smith/go-bip32"
+    "blockchain/common"
+)
+
+// PartialVersion es la versión del formato de las transacciones parcialmente firmadas.
+const PartialVersion = 1
+
+// PartialTransaction es una transacción multifirma que pasa de un firmante a otro hasta
+// reunir el umbral. Cada firmante la firma con su clave, sin conexión si hace falta, y
+// cualquiera puede combinar las copias firmadas por separado. Signed y Required son
+// informativos: se recalculan al leerla.
+type PartialTransaction struct {
+    Version     int
+    Transaction common.Transaction
+    Signed      int
+    Required    int
+}
+
+// ParsePublicKey lee una clave pública como clave BIP32 en base58, la que muestra el almacén
+// de cada dirección, o como clave comprimida en hexadecimal, y la devuelve en hexadecimal.
+func ParsePublicKey(s string) (string, error) {
+    s = strings.TrimSpace(s)
+    if key, err := bip32.B58Deserialize(s); err == nil {
+        if key.IsPrivate {
+            return "", fmt.Errorf("se recibió una clave privada; solo se debe indicar la clave pública")
+        }
+        return hex.EncodeToString(key.Key), nil
+    }
+
+    keyBytes, err := hex.DecodeString(s)
+    if err != nil {
+        return "", fmt.Errorf("clave pública inválida: %q", s)
+    }
+    if _, err := secp256k1.ParsePubKey(keyBytes); err != nil || len(keyBytes) != secp256k1.PubKeyBytesLenCompressed {
+        return "", fmt.Errorf("clave pública inválida: se espera una clave comprimida")
+    }
+    return hex.EncodeToString(keyBytes), nil
+}
+
+// NewMultisigTransaction crea una transacción sin firmas que envía amount desde la cuenta
+// multifirma a recipient, con el nonce siguiente de la cuenta y la comisión fee.
+func NewMultisigTransaction(multisig *common.Multisig, recipient string, amount, fee float64, nonce int64) (*PartialTransaction, error) {
+    if err := multisig.Validate(); err != nil {
+        return nil, err
+    }
+    if err := common.ValidateAddress(recipient); err != nil {
+        return nil, err
+    }
+    if amount <= 0 {
+        return nil, fmt.Errorf("monto inválido: %f", amount)
+    }
+    if fee < 0 {
+        return nil, fmt.Errorf("comisión inválida: %f", fee)
+    }
+    if nonce <= 0 {
+        return nil, fmt.Errorf("nonce inválido: %d", nonce)
+    }
+
+    transaction := common.Transaction{
+        Sender:     multisig.Address(),
+        Recipient:  common.FormatAddress(recipient),
+        Ammount:    amount,
+        TimeStamp:  time.Now().Unix(),
+        Multisig:   multisig,
+        Signatures: make([]string, len(multisig.PublicKeys)),
+        Nonce:      nonce,
+        Fee:        fee,
+    }
+    transaction.Hash = common.GenerateTransactionHash(transaction)
+    return newPartial(transaction)
+}
+
+// ParsePartial lee una transacción parcialmente firmada, o una transacción multifirma sola, y
+// comprueba su descripción y las firmas que ya tiene.
+func ParsePartial(data []byte) (*PartialTransaction, error) {
+    var partial PartialTransaction
+    if err := json.Unmarshal(data, &partial); err != nil {
+        return nil, fmt.Errorf("transacción parcial inválida: %v", err)
+    }
+    if partial.Transaction.Multisig == nil {
+        if err := json.Unmarshal(data, &partial.Transaction); err != nil {
+            return nil, fmt.Errorf("transacción parcial inválida: %v", err)
+        }
+    }
+    if partial.Version > PartialVersion {
+        return nil, fmt.Errorf("versión de transacción parcial no admitida: %d", partial.Version)
+    }
+    return newPartial(partial.Transaction)
+}
+
+func newPartial(transaction common.Transaction) (*PartialTransaction, error) {
+    if transaction.Multisig == nil {
+        return nil, fmt.Errorf("la transacción no es de una cuenta multifirma")
+    }
+    if transaction.Sender != transaction.Multisig.Address() {
+        return nil, fmt.Errorf("la multifirma no corresponde al remitente %s", transaction.Sender)
+    }
+    signed, err := common.CountSignatures(transaction)
+    if err != nil {
+        return nil, err
+    }
+    return &PartialTransaction{
+        Version:     PartialVersion,
+        Transaction: transaction,
+        Signed:      signed,
+        Required:    transaction.Multisig.Threshold,
+    }, nil
+}
+
+// Complete indica si la transacción ya tiene el umbral de firmas y se puede enviar.
+func (p *PartialTransaction) Complete() bool {
+    return p.Signed >= p.Required
+}
+
+// Combine agrega a la transacción las firmas de otra copia de la misma transacción firmada
+// por separado.
+func (p *PartialTransaction) Combine(other *PartialTransaction) error {
+    if !bytes.Equal(common.TransactionSigningHash(p.Transaction), common.TransactionSigningHash(other.Transaction)) {
+        return fmt.Errorf("las transacciones no son la misma")
+    }
+
+    combined := p.Transaction
+    combined.Signatures = append([]string(nil), p.Transaction.Signatures...)
+    for i, signature := range other.Transaction.Signatures {
+        if combined.Signatures[i] == "" {
+            combined.Signatures[i] = signature
+        }
+    }
+    combined.Hash = common.GenerateTransactionHash(combined)
+
+    updated, err := newPartial(combined)
+    if err != nil {
+        return err
+    }
+    *p = *updated
+    return nil
+}
+
+// SignMultisig agrega la firma de la cuenta, que debe ser una de las claves de la multifirma,
+// a una transacción parcialmente firmada.
+func (a *Account) SignMultisig(partial *PartialTransaction) error {
+    transaction := partial.Transaction
+    index := transaction.Multisig.Index(a.PublicKey.Key)
+    if index < 0 {
+        return fmt.Errorf("la cuenta %s no es una de las claves de la multifirma", a.Address)
+    }
+
+    privateKey := secp256k1.PrivKeyFromBytes(a.PrivateKey.Key)
+    signature := ecdsa.Sign(privateKey, common.TransactionSigningHash(transaction))
+
+    transaction.Signatures = append([]string(nil), transaction.Signatures...)
+    transaction.Signatures[index] = hex.EncodeToString(signature.Serialize())
+    transaction.Hash = common.GenerateTransactionHash(transaction)
+
+    updated, err := newPartial(transaction)
+    if err != nil {
+        return err
+    }
+    *partial = *updated
+    return nil
+}
@@ -0,0 +1,69 @@
+package wallet
+
+import (
+    "encoding/json"
+    "fmt"
+    "time"
+    "blockchain/common"
+)
+
+// NewTransaction crea una transacción sin firmar de sender a recipient. El nonce, el
+// siguiente de la cuenta, y la comisión se consultan antes a un nodo, así que la transacción
+// se puede guardar en un archivo y firmar después en una máquina sin conexión.
+func NewTransaction(sender, recipient string, amount, fee float64, nonce int64) (*common.Transaction, error) {
+    if err := common.ValidateAddress(sender); err != nil {
+        return nil, fmt.Errorf("remitente inválido: %v", err)
+    }
+    if common.IsMultisigAddress(sender) {
+        return nil, fmt.Errorf("el remitente es una cuenta multifirma; use una transacción multifirma")
+    }
+    if err := common.ValidateAddress(recipient); err != nil {
+        return nil, fmt.Errorf("destinatario inválido: %v", err)
+    }
+    if amount <= 0 {
+        return nil, fmt.Errorf("monto inválido: %f", amount)
+    }
+    if fee < 0 {
+        return nil, fmt.Errorf("comisión inválida: %f", fee)
+    }
+    if nonce <= 0 {
+        return nil, fmt.Errorf("nonce inválido: %d", nonce)
+    }
+
+    transaction := &common.Transaction{
+        Sender:    common.FormatAddress(sender),
+        Recipient: common.FormatAddress(recipient),
+        Ammount:   amount,
+        TimeStamp: time.Now().Unix(),
+        Nonce:     nonce,
+        Fee:       fee,
+    }
+    transaction.Hash = common.GenerateTransactionHash(*transaction)
+    return transaction, nil
+}
+
+// ParseTransaction lee una transacción en JSON, firmada o no, y comprueba su hash. Si está
+// firmada también comprueba la firma.
+func ParseTransaction(data []byte) (*common.Transaction, error) {
+    var transaction common.Transaction
+    if err := json.Unmarshal(data, &transaction); err != nil {
+        return nil, fmt.Errorf("transacción inválida: %v", err)
+    }
+    if transaction.Multisig != nil {
+        return nil, fmt.Errorf("la transacción es de una cuenta multifirma; use una transacción multifirma")
+    }
+    if transaction.Hash != common.GenerateTransactionHash(transaction) {
+        return nil, fmt.Errorf("el hash de la transacción no coincide con su contenido")
+    }
+    if Signed(transaction) {
+        if err := common.VerifyTransactionSignature(transaction); err != nil {
+            return nil, err
+        }
+    }
+    return &transaction, nil
+}
+
+// Signed indica si la transacción ya lleva una firma.
+func Signed(transaction common.Transaction) bool {
+    return transaction.Signature != ""
+}
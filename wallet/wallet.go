@@ -0,0 +1,263 @@
+package wallet
+
+import (
+    "encoding/hex"
+    "fmt"
+    "strconv"
+    "strings"
+    "github.com/tyler-smith/go-bip32"
+    "github.com/decred/dcrd/dcrec/secp256k1/v4"
+    "github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+    "blockchain/common"
+)
+
+// CoinType es el tipo de moneda de las rutas BIP44. La red no tiene uno registrado en SLIP-44,
+// así que se usa 1, el reservado para redes de prueba.
+const CoinType = 1
+
+// LegacyPath es la ruta de las cuentas creadas antes de la derivación BIP44, que usaban
+// directamente la clave maestra de la frase.
+const LegacyPath = "m"
+
+// GapLimit es la cantidad de direcciones seguidas sin usar tras la cual se deja de buscar en
+// una cuenta, como recomienda BIP44.
+const GapLimit = 20
+
+// Account es una cuenta de la billetera. Las claves se generan y se usan en el cliente: al nodo
+// solo se le envían la dirección y la clave pública. Path es la ruta de derivación desde la
+// frase, o vacío si la cuenta se importó a partir de una clave privada.
+type Account struct {
+    PrivateKey *bip32.Key
+    PublicKey  *bip32.Key
+    Address    string
+    Path       string
+}
+
+// DerivedAddress es una dirección de la billetera con su clave pública y su ruta.
+type DerivedAddress struct {
+    Path      string
+    Address   string
+    PublicKey string
+}
+
+func derivePublicKey(privateKey *bip32.Key) *bip32.Key {
+    return privateKey.PublicKey()
+}
+
+func deriveAddress(publicKey *bip32.Key) string {
+    return common.DeriveAddress(publicKey.Key)
+}
+
+// AccountPath es la ruta BIP44 de una cuenta: m/44'/CoinType'/account'.
+func AccountPath(account uint32) string {
+    return fmt.Sprintf("m/44'/%d'/%d'", CoinType, account)
+}
+
+// AddressPath es la ruta de la dirección de recepción index de una cuenta BIP44.
+func AddressPath(account, index uint32) string {
+    return fmt.Sprintf("%s/0/%d", AccountPath(account), index)
+}
+
+// DerivePath deriva la clave de la ruta indicada, por ejemplo m/44'/1'/0'/0/3, a partir de la
+// clave maestra. Los índices con ' son endurecidos.
+func DerivePath(master *bip32.Key, path string) (*bip32.Key, error) {
+    parts := strings.Split(path, "/")
+    if parts[0] != "m" {
+        return nil, fmt.Errorf("ruta de derivación inválida: %q", path)
+    }
+
+    key := master
+    for _, part := range parts[1:] {
+        hardened := strings.HasSuffix(part, "'")
+        index, err := strconv.ParseUint(strings.TrimSuffix(part, "'"), 10, 31)
+        if err != nil {
+            return nil, fmt.Errorf("ruta de derivación inválida: %q", path)
+        }
+        child := uint32(index)
+        if hardened {
+            child += bip32.FirstHardenedChild
+        }
+        if key, err = key.NewChildKey(child); err != nil {
+            return nil, fmt.Errorf("error al derivar %s: %v", path, err)
+        }
+    }
+    return key, nil
+}
+
+// NewAccount genera una frase mnemónica nueva y devuelve la primera cuenta que deriva de ella
+// con la passphrase indicada, junto con la frase. La frase y la passphrase son lo único que
+// hace falta para recuperarla.
+func NewAccount(passphrase string) (*Account, string, error) {
+    mnemonic, err := generateMnemonic()
+    if err != nil {
+        return nil, "", err
+    }
+    account, err := FromMnemonic(mnemonic, passphrase)
+    if err != nil {
+        return nil, "", err
+    }
+    return account, mnemonic, nil
+}
+
+// FromMnemonic devuelve la primera dirección de recepción de la primera cuenta BIP44 de una
+// frase mnemónica con su passphrase BIP39, que puede ser vacía.
+func FromMnemonic(mnemonic, passphrase string) (*Account, error) {
+    return DeriveAccount(mnemonic, passphrase, AddressPath(0, 0))
+}
+
+// DeriveAccount devuelve la cuenta de la ruta indicada de una frase mnemónica.
+func DeriveAccount(mnemonic, passphrase, path string) (*Account, error) {
+    master, err := derivePrivateKey(mnemonic, passphrase)
+    if err != nil {
+        return nil, err
+    }
+    key, err := DerivePath(master, path)
+    if err != nil {
+        return nil, err
+    }
+    account := FromPrivateKey(key)
+    account.Path = path
+    return account, nil
+}
+
+// AccountKey devuelve la clave pública extendida de una cuenta BIP44, con la que se derivan sus
+// direcciones de recepción sin conocer la frase.
+func AccountKey(mnemonic, passphrase string, account uint32) (*bip32.Key, error) {
+    master, err := derivePrivateKey(mnemonic, passphrase)
+    if err != nil {
+        return nil, err
+    }
+    key, err := DerivePath(master, AccountPath(account))
+    if err != nil {
+        return nil, err
+    }
+    return key.PublicKey(), nil
+}
+
+// DeriveAddress devuelve la dirección de recepción index a partir de la clave pública
+// extendida de la cuenta BIP44 account.
+func DeriveAddress(accountKey *bip32.Key, account, index uint32) (DerivedAddress, error) {
+    external, err := accountKey.NewChildKey(0)
+    if err != nil {
+        return DerivedAddress{}, fmt.Errorf("error al derivar la cadena de recepción: %v", err)
+    }
+    key, err := external.NewChildKey(index)
+    if err != nil {
+        return DerivedAddress{}, fmt.Errorf("error al derivar la dirección %d: %v", index, err)
+    }
+    key = key.PublicKey()
+    return DerivedAddress{Path: AddressPath(account, index), Address: deriveAddress(key), PublicKey: key.String()}, nil
+}
+
+// UsedFunc indica si una dirección ya se usó en la cadena.
+type UsedFunc func(address string) (bool, error)
+
+// ScanAccount recorre las direcciones de recepción de una cuenta BIP44 a partir de su clave
+// pública extendida y devuelve las usadas. Se detiene tras GapLimit direcciones seguidas sin
+// usar.
+func ScanAccount(accountKey *bip32.Key, account uint32, used UsedFunc) ([]DerivedAddress, error) {
+    var found []DerivedAddress
+    for index, gap := uint32(0), 0; gap < GapLimit; index++ {
+        address, err := DeriveAddress(accountKey, account, index)
+        if err != nil {
+            return nil, err
+        }
+        ok, err := used(address.Address)
+        if err != nil {
+            return nil, err
+        }
+        if ok {
+            found = append(found, address)
+            gap = 0
+        } else {
+            gap++
+        }
+    }
+    return found, nil
+}
+
+// Recover busca todas las direcciones usadas de una frase mnemónica. Recorre las cuentas
+// BIP44 en orden hasta la primera sin direcciones usadas, e incluye la dirección de la clave
+// maestra si se usó antes de la derivación BIP44. Devuelve la cantidad de cuentas BIP44 en uso,
+// al menos una.
+func Recover(mnemonic, passphrase string, used UsedFunc) (int, []DerivedAddress, error) {
+    var found []DerivedAddress
+
+    legacy, err := DeriveAccount(mnemonic, passphrase, LegacyPath)
+    if err != nil {
+        return 0, nil, err
+    }
+    ok, err := used(legacy.Address)
+    if err != nil {
+        return 0, nil, err
+    }
+    if ok {
+        found = append(found, DerivedAddress{Path: LegacyPath, Address: legacy.Address, PublicKey: legacy.PublicKey.String()})
+    }
+
+    accounts := 0
+    for account := uint32(0); ; account++ {
+        accountKey, err := AccountKey(mnemonic, passphrase, account)
+        if err != nil {
+            return 0, nil, err
+        }
+        addresses, err := ScanAccount(accountKey, account, used)
+        if err != nil {
+            return 0, nil, err
+        }
+        if len(addresses) == 0 {
+            break
+        }
+        found = append(found, addresses...)
+        accounts++
+    }
+
+    if accounts == 0 {
+        accounts = 1
+    }
+    return accounts, found, nil
+}
+
+// FromPrivateKey devuelve la cuenta de una clave privada BIP32.
+func FromPrivateKey(privateKey *bip32.Key) *Account {
+    publicKey := derivePublicKey(privateKey)
+    return &Account{
+        PrivateKey: privateKey,
+        PublicKey:  publicKey,
+        Address:    deriveAddress(publicKey),
+    }
+}
+
+// ParsePrivateKey lee una clave privada BIP32 serializada en base58 y devuelve su cuenta.
+func ParsePrivateKey(s string) (*Account, error) {
+    privateKey, err := bip32.B58Deserialize(s)
+    if err != nil {
+        return nil, fmt.Errorf("clave privada inválida: %v", err)
+    }
+    if !privateKey.IsPrivate {
+        return nil, fmt.Errorf("se esperaba una clave privada y se recibió una pública")
+    }
+    return FromPrivateKey(privateKey), nil
+}
+
+// User devuelve la parte pública de la cuenta, que es lo que se registra en el nodo.
+func (a *Account) User() common.User {
+    return common.User{PublicKey: a.PublicKey, Address: a.Address}
+}
+
+// Sign firma la transacción con la clave de la cuenta, que debe ser la del remitente, y
+// calcula su hash.
+func (a *Account) Sign(transaction *common.Transaction) error {
+    if !common.SameAddress(transaction.Sender, a.Address) {
+        return fmt.Errorf("la cuenta %s no puede firmar transacciones de %s", a.Address, transaction.Sender)
+    }
+
+    transaction.PublicKey = hex.EncodeToString(a.PublicKey.Key)
+
+    privateKey := secp256k1.PrivKeyFromBytes(a.PrivateKey.Key)
+    signature := ecdsa.Sign(privateKey, common.TransactionSigningHash(*transaction))
+    transaction.Signature = hex.EncodeToString(signature.Serialize())
+
+    transaction.Hash = common.GenerateTransactionHash(*transaction)
+    return nil
+}
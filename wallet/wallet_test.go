@@ -0,0 +1,561 @@
+package wallet
+
+import (
+    "encoding/json"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+    "blockchain/common"
+)
+
+const testMnemonic = "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+
+func TestSignAndVerify(t *testing.T) {
+    account, err := FromMnemonic(testMnemonic, "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    transaction := common.Transaction{Sender: account.Address, Recipient: "b", Ammount: 1.5, TimeStamp: 1700000000}
+
+    if err := account.Sign(&transaction); err != nil {
+        t.Fatal(err)
+    }
+    if err := common.VerifyTransactionSignature(transaction); err != nil {
+        t.Fatalf("firma rechazada: %v", err)
+    }
+    if transaction.Hash != common.GenerateTransactionHash(transaction) {
+        t.Fatal("el hash no cubre la firma")
+    }
+
+    // Cualquier cambio en los campos firmados invalida la firma
+    tampered := transaction
+    tampered.Ammount = 100
+    if err := common.VerifyTransactionSignature(tampered); err == nil {
+        t.Fatal("se aceptó una transacción modificada")
+    }
+
+    // La clave pública de otra cuenta no corresponde al remitente
+    other, _, err := NewAccount("")
+    if err != nil {
+        t.Fatal(err)
+    }
+    forged := transaction
+    if err := other.Sign(&forged); err == nil {
+        t.Fatal("se firmó una transacción de otra cuenta")
+    }
+    forged.Sender = other.Address
+    if err := other.Sign(&forged); err != nil {
+        t.Fatal(err)
+    }
+    forged.Sender = account.Address
+    if err := common.VerifyTransactionSignature(forged); err == nil {
+        t.Fatal("se aceptó una firma de otra cuenta")
+    }
+}
+
+func TestParsePrivateKey(t *testing.T) {
+    account, err := FromMnemonic(testMnemonic, "")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    parsed, err := ParsePrivateKey(account.PrivateKey.String())
+    if err != nil {
+        t.Fatal(err)
+    }
+    if parsed.Address != account.Address {
+        t.Fatalf("dirección distinta: %s y %s", parsed.Address, account.Address)
+    }
+
+    if _, err := ParsePrivateKey(account.PublicKey.String()); err == nil {
+        t.Fatal("se aceptó una clave pública como privada")
+    }
+}
+
+func TestKeystore(t *testing.T) {
+    defer func(n int) { ScryptN = n }(ScryptN)
+    ScryptN = 1 << 10
+
+    ks, err := OpenKeystore(t.TempDir())
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    account, mnemonic, err := ks.Create("clave", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if first, err := FromMnemonic(mnemonic, ""); err != nil || first.Address != account.Address {
+        t.Fatal("la frase no corresponde a la cuenta creada")
+    }
+
+    // La billetera nueva no se desbloquea hasta confirmar la frase
+    if err := ks.Unlock(account.Address, "clave", 0); err != ErrBackupPending {
+        t.Fatalf("se esperaba ErrBackupPending y se recibió %v", err)
+    }
+    if err := ks.ConfirmBackup(account.Address, testMnemonic, ""); err != ErrBackupMismatch {
+        t.Fatalf("se confirmó otra frase: %v", err)
+    }
+    if err := ks.ConfirmBackup(account.Address, strings.ToUpper(mnemonic), ""); err != nil {
+        t.Fatal(err)
+    }
+
+    if _, err := ks.Account(account.Address); err != ErrLocked {
+        t.Fatalf("se esperaba ErrLocked y se recibió %v", err)
+    }
+    if err := ks.Unlock(account.Address, "otra", 0); err != ErrWrongPassword {
+        t.Fatalf("se esperaba ErrWrongPassword y se recibió %v", err)
+    }
+
+    // La cuenta se vuelve a bloquear al vencer el plazo
+    if err := ks.Unlock(account.Address, "clave", 50*time.Millisecond); err != nil {
+        t.Fatal(err)
+    }
+    if unlocked, err := ks.Account(account.Address); err != nil || unlocked.Address != account.Address {
+        t.Fatalf("cuenta desbloqueada distinta: %v", err)
+    }
+    time.Sleep(100 * time.Millisecond)
+    if _, err := ks.Account(account.Address); err != ErrLocked {
+        t.Fatalf("la cuenta sigue desbloqueada tras el plazo: %v", err)
+    }
+
+    if err := ks.ChangePassword(account.Address, "clave", "nueva"); err != nil {
+        t.Fatal(err)
+    }
+    if err := ks.Unlock(account.Address, "clave", 0); err != ErrWrongPassword {
+        t.Fatalf("la contraseña anterior sigue sirviendo: %v", err)
+    }
+    if err := ks.Unlock(account.Address, "nueva", 0); err != nil {
+        t.Fatal(err)
+    }
+
+    // Un archivo exportado se importa en otro almacén con su contraseña
+    data, err := ks.Export(account.Address)
+    if err != nil {
+        t.Fatal(err)
+    }
+    other, err := OpenKeystore(t.TempDir())
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := other.ImportFile(data, "clave"); err != ErrWrongPassword {
+        t.Fatalf("se importó un archivo con una contraseña incorrecta: %v", err)
+    }
+    if imported, err := other.ImportFile(data, "nueva"); err != nil || imported.Address != account.Address {
+        t.Fatalf("error al importar: %v", err)
+    }
+
+    accounts, err := other.List()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(accounts) != 1 || accounts[0].Address != account.Address || accounts[0].Unlocked {
+        t.Fatalf("lista inesperada: %+v", accounts)
+    }
+}
+
+// TestDerivation comprueba que las direcciones derivadas de la clave pública de la cuenta sean
+// las mismas que se obtienen desde la frase, que es lo que permite firmar con ellas.
+func TestImportExisting(t *testing.T) {
+    defer func(n int) { ScryptN = n }(ScryptN)
+    ScryptN = 1 << 10
+
+    ks, err := OpenKeystore(t.TempDir())
+    if err != nil {
+        t.Fatal(err)
+    }
+    account, err := FromMnemonic(testMnemonic, "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    key := account.PrivateKey.B58Serialize()
+
+    if _, err := ks.Import(testMnemonic, "", "clave"); err != nil {
+        t.Fatal(err)
+    }
+    data, err := ks.Export(account.Address)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // Otra dirección de la frase importada por su clave privada tiene su propio archivo
+    other, err := DeriveAccount(testMnemonic, "", AddressPath(0, 1))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := ks.Import(other.PrivateKey.B58Serialize(), "", "clave"); err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        name   string
+        add    func() error
+    }{
+        {"frase", func() error { _, err := ks.Import(testMnemonic, "", "otra"); return err }},
+        {"clave privada", func() error { _, err := ks.Import(key, "", "otra"); return err }},
+        {"clave privada de una cuenta derivada", func() error { _, err := ks.Import(other.PrivateKey.B58Serialize(), "", "otra"); return err }},
+        {"archivo exportado", func() error { _, err := ks.ImportFile(data, "clave"); return err }},
+    }
+
+    for _, test := range tests {
+        if err := test.add(); err != ErrExists {
+            t.Errorf("%s: se esperaba ErrExists y se recibió %v", test.name, err)
+        }
+        // El archivo existente conserva su contraseña
+        if err := ks.Unlock(account.Address, "clave", 0); err != nil {
+            t.Errorf("%s: %v", test.name, err)
+        }
+        if err := ks.Unlock(other.Address, "clave", 0); err != nil {
+            t.Errorf("%s: %v", test.name, err)
+        }
+    }
+}
+
+func TestDerivation(t *testing.T) {
+    accountKey, err := AccountKey(testMnemonic, "", 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    seen := make(map[string]bool)
+    for index := uint32(0); index < 5; index++ {
+        derived, err := DeriveAddress(accountKey, 0, index)
+        if err != nil {
+            t.Fatal(err)
+        }
+        account, err := DeriveAccount(testMnemonic, "", derived.Path)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if account.Address != derived.Address || account.PublicKey.String() != derived.PublicKey {
+            t.Fatalf("%s: la derivación pública no coincide con la privada", derived.Path)
+        }
+        if seen[derived.Address] {
+            t.Fatalf("%s: dirección repetida", derived.Path)
+        }
+        seen[derived.Address] = true
+    }
+
+    master, err := derivePrivateKey(testMnemonic, "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := DerivePath(master, "m/44'/x"); err == nil {
+        t.Fatal("se aceptó una ruta inválida")
+    }
+}
+
+func TestRecover(t *testing.T) {
+    address := func(path string) string {
+        account, err := DeriveAccount(testMnemonic, "", path)
+        if err != nil {
+            t.Fatal(err)
+        }
+        return account.Address
+    }
+
+    // La dirección 30 queda más allá del límite de huecos tras la 5, así que no se encuentra
+    used := map[string]bool{
+        address(LegacyPath):        true,
+        address(AddressPath(0, 0)):  true,
+        address(AddressPath(0, 5)):  true,
+        address(AddressPath(0, 30)): true,
+        address(AddressPath(1, 0)):  true,
+    }
+    usedFunc := func(address string) (bool, error) { return used[address], nil }
+
+    accounts, found, err := Recover(testMnemonic, "", usedFunc)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if accounts != 2 {
+        t.Fatalf("se esperaban 2 cuentas y se encontraron %d", accounts)
+    }
+    var paths []string
+    for _, derived := range found {
+        paths = append(paths, derived.Path)
+    }
+    want := []string{LegacyPath, AddressPath(0, 0), AddressPath(0, 5), AddressPath(1, 0)}
+    if !reflect.DeepEqual(paths, want) {
+        t.Fatalf("direcciones recuperadas:\n%v\nse esperaban:\n%v", paths, want)
+    }
+
+    // El almacén guarda lo recuperado y sigue derivando a partir de ello
+    defer func(n int) { ScryptN = n }(ScryptN)
+    ScryptN = 1 << 10
+
+    ks, err := OpenKeystore(t.TempDir())
+    if err != nil {
+        t.Fatal(err)
+    }
+    first, _, err := ks.Recover(testMnemonic, "", "clave", usedFunc)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    next, err := ks.NextAddress(first.Address, 0, "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if next.Path != AddressPath(0, 1) {
+        t.Fatalf("siguiente dirección inesperada: %s", next.Path)
+    }
+    if _, err := ks.NextAddress(first.Address, 2, ""); err != ErrWrongPassword {
+        t.Fatalf("se empezó una cuenta nueva sin la contraseña: %v", err)
+    }
+    if next, err = ks.NextAddress(first.Address, 2, "clave"); err != nil || next.Path != AddressPath(2, 0) {
+        t.Fatalf("cuenta nueva: %v %s", err, next.Path)
+    }
+
+    // Cualquier dirección de la billetera se desbloquea y firma con su propia clave
+    legacy := address(LegacyPath)
+    if err := ks.Unlock(legacy, "clave", 0); err != nil {
+        t.Fatal(err)
+    }
+    if account, err := ks.Account(legacy); err != nil || account.Path != LegacyPath {
+        t.Fatalf("cuenta desbloqueada inesperada: %v", err)
+    }
+    if err := ks.Unlock(next.Address, "clave", 0); err != nil {
+        t.Fatal(err)
+    }
+
+    // Scan encuentra las direcciones que se usaron desde otra copia de la billetera
+    used[address(AddressPath(0, 3))] = true
+    added, err := ks.Scan(first.Address, usedFunc)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(added) != 1 || added[0].Path != AddressPath(0, 3) {
+        t.Fatalf("la búsqueda agregó %+v", added)
+    }
+}
+
+func TestValidateMnemonic(t *testing.T) {
+    if err := ValidateMnemonic("  Abandon abandon abandon abandon abandon abandon\nabandon abandon abandon abandon abandon ABOUT "); err != nil {
+        t.Fatalf("se rechazó una frase válida con otro formato: %v", err)
+    }
+
+    words := strings.Fields(testMnemonic)
+    tests := []struct {
+        name       string
+        mnemonic   string
+        word       int
+        suggestion string
+    }{
+        {"palabras de menos", strings.Join(words[:11], " "), 0, ""},
+        {"errata", strings.Join(append(words[:11:11], "abuot"), " "), 12, "about"},
+        {"errata en el prefijo", strings.Join(append(words[:11:11], "aboutt"), " "), 12, "about"},
+        {"suma incorrecta", strings.Join(append(words[:11:11], "abandon"), " "), 0, ""},
+    }
+    for _, test := range tests {
+        err := ValidateMnemonic(test.mnemonic)
+        mnemonicErr, ok := err.(*MnemonicError)
+        if !ok {
+            t.Fatalf("%s: se esperaba un MnemonicError y se recibió %v", test.name, err)
+        }
+        if mnemonicErr.Word != test.word {
+            t.Fatalf("%s: palabra %d, se esperaba la %d", test.name, mnemonicErr.Word, test.word)
+        }
+        if test.suggestion != "" && !strings.Contains(strings.Join(mnemonicErr.Suggestions, " "), test.suggestion) {
+            t.Fatalf("%s: sugerencias %v sin %q", test.name, mnemonicErr.Suggestions, test.suggestion)
+        }
+    }
+}
+
+// TestPassphrase comprueba que la passphrase BIP39 da otra billetera y que el almacén la
+// guarda con la frase para firmar y derivar cuentas nuevas.
+func TestPassphrase(t *testing.T) {
+    plain, err := FromMnemonic(testMnemonic, "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    protected, err := FromMnemonic(testMnemonic, "TREZOR")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if plain.Address == protected.Address {
+        t.Fatal("la passphrase no cambió la billetera")
+    }
+
+    defer func(n int) { ScryptN = n }(ScryptN)
+    ScryptN = 1 << 10
+
+    ks, err := OpenKeystore(t.TempDir())
+    if err != nil {
+        t.Fatal(err)
+    }
+    imported, err := ks.Import(testMnemonic, "TREZOR", "clave")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if imported.Address != protected.Address {
+        t.Fatal("el almacén no usó la passphrase")
+    }
+    if err := ks.ConfirmBackup(imported.Address, testMnemonic, ""); err != ErrBackupMismatch {
+        t.Fatalf("se confirmó la frase sin la passphrase: %v", err)
+    }
+    if err := ks.Unlock(imported.Address, "clave", 0); err != nil {
+        t.Fatal(err)
+    }
+
+    next, err := ks.NextAddress(imported.Address, 1, "clave")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if want, err := DeriveAccount(testMnemonic, "TREZOR", next.Path); err != nil || want.Address != next.Address {
+        t.Fatalf("%s: la cuenta nueva no usó la passphrase", next.Path)
+    }
+}
+
+// TestMultisig recorre el flujo de una transacción 2 de 3: dos firmantes firman copias por
+// separado, se combinan y la transacción queda completa.
+func TestMultisig(t *testing.T) {
+    var accounts []*Account
+    var keys []string
+    for i := uint32(0); i < 3; i++ {
+        account, err := DeriveAccount(testMnemonic, "", AddressPath(0, i))
+        if err != nil {
+            t.Fatal(err)
+        }
+        key, err := ParsePublicKey(account.PublicKey.String())
+        if err != nil {
+            t.Fatal(err)
+        }
+        accounts = append(accounts, account)
+        keys = append(keys, key)
+    }
+
+    multisig, err := common.NewMultisig(2, keys)
+    if err != nil {
+        t.Fatal(err)
+    }
+    reversed, err := common.NewMultisig(2, []string{keys[2], keys[1], keys[0]})
+    if err != nil || reversed.Address() != multisig.Address() {
+        t.Fatal("la dirección depende del orden de las claves")
+    }
+    if !common.IsMultisigAddress(multisig.Address()) || common.IsMultisigAddress(accounts[0].Address) {
+        t.Fatal("tipo de dirección incorrecto")
+    }
+    if _, err := common.NewMultisig(4, keys); err == nil {
+        t.Fatal("se aceptó un umbral mayor que la cantidad de claves")
+    }
+
+    partial, err := NewMultisigTransaction(multisig, accounts[0].Address, 10, 0, 1)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if common.VerifyTransactionSignature(partial.Transaction) == nil {
+        t.Fatal("se aceptó una transacción sin firmas")
+    }
+
+    // Cada firmante trabaja sobre su propia copia, como si la recibiera en un archivo
+    data, err := json.Marshal(partial)
+    if err != nil {
+        t.Fatal(err)
+    }
+    first, err := ParsePartial(data)
+    if err != nil {
+        t.Fatal(err)
+    }
+    second, err := ParsePartial(data)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := accounts[0].SignMultisig(first); err != nil {
+        t.Fatal(err)
+    }
+    if first.Complete() || common.VerifyTransactionSignature(first.Transaction) == nil {
+        t.Fatal("una firma alcanzó el umbral de 2")
+    }
+    if err := accounts[2].SignMultisig(second); err != nil {
+        t.Fatal(err)
+    }
+
+    if err := first.Combine(second); err != nil {
+        t.Fatal(err)
+    }
+    if !first.Complete() || first.Signed != 2 {
+        t.Fatalf("firmas tras combinar: %d", first.Signed)
+    }
+    if err := common.VerifyTransactionSignature(first.Transaction); err != nil {
+        t.Fatalf("se rechazó la transacción completa: %v", err)
+    }
+
+    // Una firma falsa invalida la transacción aunque las demás alcancen el umbral
+    tampered := first.Transaction
+    tampered.Signatures = append([]string(nil), first.Transaction.Signatures...)
+    tampered.Signatures[1] = tampered.Signatures[0]
+    if common.VerifyTransactionSignature(tampered) == nil {
+        t.Fatal("se aceptó una firma de otra clave")
+    }
+    tampered = first.Transaction
+    tampered.Ammount = 1000
+    if common.VerifyTransactionSignature(tampered) == nil {
+        t.Fatal("se aceptó una transacción modificada")
+    }
+
+    outsider, _, err := NewAccount("")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := outsider.SignMultisig(second); err == nil {
+        t.Fatal("firmó una cuenta que no es de la multifirma")
+    }
+}
+
+func TestOfflineTransaction(t *testing.T) {
+    account, err := DeriveAccount(testMnemonic, "", AddressPath(0, 0))
+    if err != nil {
+        t.Fatal(err)
+    }
+    recipient, err := DeriveAccount(testMnemonic, "", AddressPath(0, 1))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // Se construye con el nonce y la comisión del nodo y viaja como archivo a la máquina que firma
+    unsigned, err := NewTransaction(account.Address, recipient.Address, 5, 0.1, 3)
+    if err != nil {
+        t.Fatal(err)
+    }
+    data, err := json.Marshal(unsigned)
+    if err != nil {
+        t.Fatal(err)
+    }
+    transaction, err := ParseTransaction(data)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if Signed(*transaction) {
+        t.Fatal("la transacción sin firmar figura como firmada")
+    }
+    if err := account.Sign(transaction); err != nil {
+        t.Fatal(err)
+    }
+
+    // De vuelta en la máquina conectada, la transacción firmada se comprueba antes de enviarla
+    data, err = json.Marshal(transaction)
+    if err != nil {
+        t.Fatal(err)
+    }
+    signed, err := ParseTransaction(data)
+    if err != nil {
+        t.Fatalf("se rechazó la transacción firmada: %v", err)
+    }
+    if signed.Nonce != 3 || signed.Fee != 0.1 {
+        t.Fatalf("nonce o comisión perdidos: %d %f", signed.Nonce, signed.Fee)
+    }
+
+    // El nonce y la comisión están firmados: otro nonce repetiría la transacción
+    tampered := *signed
+    tampered.Nonce = 4
+    tampered.Hash = common.GenerateTransactionHash(tampered)
+    if common.VerifyTransactionSignature(tampered) == nil {
+        t.Fatal("se aceptó la transacción con otro nonce")
+    }
+
+    if _, err := NewTransaction(account.Address, recipient.Address, 5, 0, 0); err == nil {
+        t.Fatal("se aceptó una transacción sin nonce")
+    }
+}